@@ -0,0 +1,112 @@
+/*
+Copyright 2024. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	utilconversion "sigs.k8s.io/cluster-api/util/conversion"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	"github.com/gianlucam76/ytt-controller/api/v1beta1"
+)
+
+// ConvertTo converts v1alpha1 to the Hub version (v1beta1).
+// Fields which only exist in v1beta1 are restored from the annotation
+// set by ConvertFrom, so that a v1beta1 -> v1alpha1 -> v1beta1 round trip
+// is not lossy.
+func (src *YttSource) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1beta1.YttSource)
+
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+	Convert_v1alpha1_YttSourceSpec_To_v1beta1_YttSourceSpec(&src.Spec, &dst.Spec)
+	Convert_v1alpha1_YttSourceStatus_To_v1beta1_YttSourceStatus(&src.Status, &dst.Status)
+
+	// UnmarshalData also removes the annotation from dst
+	restored := &v1beta1.YttSource{}
+	ok, err := utilconversion.UnmarshalData(dst, restored)
+	if err != nil {
+		return err
+	}
+	if len(dst.Annotations) == 0 {
+		dst.Annotations = nil
+	}
+	if !ok {
+		return nil
+	}
+
+	dst.Spec.Sources = restored.Spec.Sources
+	dst.Spec.Files = restored.Spec.Files
+	dst.Spec.DataLayout = restored.Spec.DataLayout
+	dst.Spec.DataValues = restored.Spec.DataValues
+	dst.Spec.Output = restored.Spec.Output
+	dst.Spec.KeepLastOutputOnFailure = restored.Spec.KeepLastOutputOnFailure
+	dst.Spec.SecretRedaction = restored.Spec.SecretRedaction
+	dst.Spec.AllowedSecretTypes = restored.Spec.AllowedSecretTypes
+	dst.Spec.Decryption = restored.Spec.Decryption
+	dst.Spec.Timeout = restored.Spec.Timeout
+	dst.Spec.URL = restored.Spec.URL
+	dst.Spec.OCI = restored.Spec.OCI
+
+	dst.Status.OutputRef = restored.Status.OutputRef
+	dst.Status.OutputDigest = restored.Status.OutputDigest
+	dst.Status.InputDigest = restored.Status.InputDigest
+	dst.Status.ObservedGeneration = restored.Status.ObservedGeneration
+	dst.Status.LastAttemptedRevision = restored.Status.LastAttemptedRevision
+	dst.Status.LastAppliedRevision = restored.Status.LastAppliedRevision
+	dst.Status.Conditions = restored.Status.Conditions
+
+	return nil
+}
+
+// ConvertFrom converts from the Hub version (v1beta1) to v1alpha1.
+// Fields which do not exist in v1alpha1 are preserved in an annotation.
+func (dst *YttSource) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1beta1.YttSource)
+
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+	Convert_v1beta1_YttSourceSpec_To_v1alpha1_YttSourceSpec(&src.Spec, &dst.Spec)
+	Convert_v1beta1_YttSourceStatus_To_v1alpha1_YttSourceStatus(&src.Status, &dst.Status)
+
+	return utilconversion.MarshalData(src, dst)
+}
+
+//nolint:revive,stylecheck // follow conversion-gen naming
+func Convert_v1alpha1_YttSourceSpec_To_v1beta1_YttSourceSpec(in *YttSourceSpec, out *v1beta1.YttSourceSpec) {
+	out.Namespace = in.Namespace
+	out.Name = in.Name
+	out.Kind = in.Kind
+	out.Path = in.Path
+}
+
+//nolint:revive,stylecheck // follow conversion-gen naming
+func Convert_v1beta1_YttSourceSpec_To_v1alpha1_YttSourceSpec(in *v1beta1.YttSourceSpec, out *YttSourceSpec) {
+	out.Namespace = in.Namespace
+	out.Name = in.Name
+	out.Kind = in.Kind
+	out.Path = in.Path
+}
+
+//nolint:revive,stylecheck // follow conversion-gen naming
+func Convert_v1alpha1_YttSourceStatus_To_v1beta1_YttSourceStatus(in *YttSourceStatus, out *v1beta1.YttSourceStatus) {
+	out.Resources = in.Resources
+	out.FailureMessage = in.FailureMessage
+}
+
+//nolint:revive,stylecheck // follow conversion-gen naming
+func Convert_v1beta1_YttSourceStatus_To_v1alpha1_YttSourceStatus(in *v1beta1.YttSourceStatus, out *YttSourceStatus) {
+	out.Resources = in.Resources
+	out.FailureMessage = in.FailureMessage
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2024. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	utilconversion "sigs.k8s.io/cluster-api/util/conversion"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/conversion"
+
+	"github.com/gianlucam76/ytt-controller/api/v1alpha1"
+	"github.com/gianlucam76/ytt-controller/api/v1beta1"
+)
+
+func TestFuzzyConversion(t *testing.T) {
+	g := NewWithT(t)
+	scheme := runtime.NewScheme()
+	g.Expect(v1alpha1.AddToScheme(scheme)).To(Succeed())
+	g.Expect(v1beta1.AddToScheme(scheme)).To(Succeed())
+
+	// Conversion webhook is served only if all versions are convertible
+	convertible, err := conversion.IsConvertible(scheme, &v1beta1.YttSource{})
+	g.Expect(err).To(BeNil())
+	g.Expect(convertible).To(BeTrue())
+
+	t.Run("for YttSource", utilconversion.FuzzTestFunc(utilconversion.FuzzTestFuncInput{
+		Scheme: scheme,
+		Hub:    &v1beta1.YttSource{},
+		Spoke:  &v1alpha1.YttSource{},
+	}))
+}
+
+func TestConvertFromPreservesHubOnlyFields(t *testing.T) {
+	g := NewWithT(t)
+
+	keep := false
+	hub := &v1beta1.YttSource{
+		Spec: v1beta1.YttSourceSpec{
+			Namespace: "flux-system",
+			Name:      "flux-system",
+			Kind:      "GitRepository",
+			Path:      "./deployment",
+			DataValues: &v1beta1.DataValues{
+				Values: map[string]string{"env": "production"},
+			},
+			Output:                  &v1beta1.Output{Kind: "ConfigMap", Name: "output"},
+			KeepLastOutputOnFailure: &keep,
+		},
+		Status: v1beta1.YttSourceStatus{
+			Resources:           "resources",
+			LastAppliedRevision: "main@sha1:abc",
+		},
+	}
+
+	spoke := &v1alpha1.YttSource{}
+	g.Expect(spoke.ConvertFrom(hub)).To(Succeed())
+	g.Expect(spoke.Spec.Path).To(Equal(hub.Spec.Path))
+	g.Expect(spoke.Status.Resources).To(Equal(hub.Status.Resources))
+	g.Expect(hub.Annotations).To(BeEmpty())
+
+	// A v1alpha1 client changes path. Change must be preserved while v1beta1 only
+	// fields are restored.
+	spoke.Spec.Path = "./other"
+	restored := &v1beta1.YttSource{}
+	g.Expect(spoke.ConvertTo(restored)).To(Succeed())
+	g.Expect(restored.Spec.Path).To(Equal("./other"))
+	g.Expect(restored.Spec.DataValues).To(Equal(hub.Spec.DataValues))
+	g.Expect(restored.Spec.Output).To(Equal(hub.Spec.Output))
+	g.Expect(restored.Spec.KeepLastOutputOnFailure).To(Equal(hub.Spec.KeepLastOutputOnFailure))
+	g.Expect(restored.Status.LastAppliedRevision).To(Equal(hub.Status.LastAppliedRevision))
+	g.Expect(restored.Annotations).To(BeEmpty())
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2024. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+// Condition types set on YttSource.
+const (
+	// ReadyCondition indicates the YttSource has been successfully reconciled
+	// and its output reflects the last attempted revision.
+	ReadyCondition = "Ready"
+
+	// SourceReadyCondition indicates the referenced source has been found
+	// and its content fetched.
+	SourceReadyCondition = "SourceReady"
+
+	// TemplateRenderedCondition indicates ytt successfully evaluated the templates.
+	TemplateRenderedCondition = "TemplateRendered"
+
+	// StalledCondition indicates reconciliation cannot make progress until
+	// either the YttSource spec or the referenced source changes.
+	StalledCondition = "Stalled"
+)
+
+// Condition reasons set on YttSource.
+const (
+	// SucceededReason is used when a step completed successfully.
+	SucceededReason = "Succeeded"
+
+	// SourceNotFoundReason is used when the referenced source does not exist.
+	SourceNotFoundReason = "SourceNotFound"
+
+	// ArtifactNotReadyReason is used when the referenced Flux source has not
+	// produced an artifact yet.
+	ArtifactNotReadyReason = "ArtifactNotReady"
+
+	// ArtifactFetchFailedReason is used when the content of the referenced
+	// source could not be fetched or extracted.
+	ArtifactFetchFailedReason = "ArtifactFetchFailed"
+
+	// PathNotFoundReason is used when Spec.Path does not exist in the source.
+	PathNotFoundReason = "PathNotFound"
+
+	// SecretTypeNotAllowedReason is used when a referenced Secret has a type
+	// which is not allowed. See Spec.AllowedSecretTypes.
+	SecretTypeNotAllowedReason = "SecretTypeNotAllowed"
+
+	// DecryptionFailedReason is used when SOPS-encrypted files cannot be decrypted.
+	DecryptionFailedReason = "DecryptionFailed"
+
+	// DataValuesFailedReason is used when data values could not be collected.
+	DataValuesFailedReason = "DataValuesFailed"
+
+	// YttEvaluationFailedReason is used when ytt fails evaluating the templates.
+	YttEvaluationFailedReason = "YttEvaluationFailed"
+
+	// RenderTimeoutReason is used when ytt does not complete evaluating the
+	// templates within Spec.Timeout.
+	RenderTimeoutReason = "RenderTimeout"
+
+	// OutputTooLargeReason is used when ytt output exceeds the maximum size
+	// allowed by the controller.
+	OutputTooLargeReason = "OutputTooLarge"
+
+	// OutputWriteFailedReason is used when output cannot be written to the
+	// ConfigMap/Secret defined in Spec.Output.
+	OutputWriteFailedReason = "OutputWriteFailed"
+)
@@ -17,11 +17,54 @@ limitations under the License.
 package v1beta1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 const (
 	YttSourceKind = "YttSourceKind"
+
+	// YttSourceFinalizer allows YttSourceReconciler to clean up resources associated with
+	// YttSource before removing it from the apiserver.
+	YttSourceFinalizer = "yttsourcefinalizer.extension.projectsveltos.io"
+
+	// OutputOwnerAnnotation is set on ConfigMaps/Secrets created to store YttSource
+	// output. Its value is the YttSource namespace/name.
+	OutputOwnerAnnotation = "extension.projectsveltos.io/yttsource"
+
+	// DefaultOutputKey is the key used to store output when Output.Key is not set
+	DefaultOutputKey = "resources.yaml"
+
+	// LibraryDirectory is the directory ytt loads libraries from
+	LibraryDirectory = "_ytt_lib"
+
+	// URLSourceKind is the kind of sources fetched over HTTP(S)
+	URLSourceKind = "URL"
+
+	// URLSourceCAKey is the key of the Secret referenced by URLSource.CASecretRef
+	// containing the PEM-encoded CA bundle
+	URLSourceCAKey = "ca.crt"
+
+	// OCISourceKind is the kind of sources pulled from OCI registries
+	OCISourceKind = "OCI"
+
+	// CosignPublicKeySuffix is the suffix of the keys of the Secret referenced by
+	// OCIVerification.SecretRef containing cosign public keys
+	CosignPublicKeySuffix = ".pub"
+)
+
+// SourceType is the type of the ytt files contained in a source
+type SourceType string
+
+const (
+	// SourceTypeTemplate indicates the source contains templates
+	SourceTypeTemplate = SourceType("Template")
+
+	// SourceTypeOverlay indicates the source contains overlays
+	SourceTypeOverlay = SourceType("Overlay")
+
+	// SourceTypeLibrary indicates the source contains a ytt library
+	SourceTypeLibrary = SourceType("Library")
 )
 
 // YttSourceSpec defines the desired state of YttSource
@@ -29,39 +72,494 @@ type YttSourceSpec struct {
 	// Namespace of the referenced resource.
 	// Namespace can be left empty. In such a case, namespace will
 	// be implicit set to cluster's namespace.
-	Namespace string `json:"namespace"`
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
 
 	// Name of the rreferenced resource.
 	// +kubebuilder:validation:MinLength=1
-	Name string `json:"name"`
+	// +optional
+	Name string `json:"name,omitempty"`
 
 	// Kind of the resource. Supported kinds are:
-	// - flux GitRepository;OCIRepository;Bucket
+	// - flux GitRepository;OCIRepository;Bucket;HelmChart;ExternalArtifact
 	// - ConfigMap/Secret (which will be mounted as volume)
-	// +kubebuilder:validation:Enum=GitRepository;OCIRepository;Bucket;ConfigMap;Secret
-	Kind string `json:"kind"`
+	// - URL, a tarball fetched over HTTP(S) as described by URL
+	// - OCI, an artifact pulled from an OCI registry as described by OCI
+	// At least one of Kind and Name, Sources or Files must be set. When
+	// Sources is also set, the resource referenced here is used as first source.
+	// +kubebuilder:validation:Enum=GitRepository;OCIRepository;Bucket;HelmChart;ExternalArtifact;ConfigMap;Secret;URL;OCI
+	// +optional
+	Kind string `json:"kind,omitempty"`
+
+	// URL describes where the tarball is fetched from.
+	// Only used, and required, when Kind is URL.
+	// +optional
+	URL *URLSource `json:"url,omitempty"`
+
+	// OCI describes the artifact pulled from an OCI registry.
+	// Only used, and required, when Kind is OCI.
+	// +optional
+	OCI *OCISource `json:"oci,omitempty"`
 
 	// Path to the directory containing the kustomization.yaml file, or the
 	// set of plain YAMLs a kustomization.yaml should be generated for.
 	// Defaults to 'None', which translates to the root path of the SourceRef.
 	// +optional
 	Path string `json:"path,omitempty"`
+
+	// DataLayout describes how ytt files are stored in the referenced resource.
+	// Only used when Kind is ConfigMap or Secret.
+	// +optional
+	DataLayout *DataLayout `json:"dataLayout,omitempty"`
+
+	// Sources references additional resources containing ytt files
+	// (for instance shared libraries or environment overlays living in
+	// different repositories). Files from all sources are combined in a
+	// single ytt invocation, in order.
+	// +optional
+	Sources []SourceReference `json:"sources,omitempty"`
+
+	// Files contains ytt files, keyed by file name, combined with files from
+	// the sources. Names are relative to the root of the ytt input and can contain
+	// directories. Inline files are placed after files from all sources.
+	// Meant for small templates and quick experiments which do not deserve a
+	// separate source.
+	// +optional
+	Files map[string]string `json:"files,omitempty"`
+
+	// DataValues contains the ytt data values used to parametrize
+	// the templates.
+	// +optional
+	DataValues *DataValues `json:"dataValues,omitempty"`
+
+	// Output defines the ConfigMap/Secret the ytt output is written to.
+	// When set, the output is not stored in Status.Resources.
+	// +optional
+	Output *Output `json:"output,omitempty"`
+
+	// KeepLastOutputOnFailure indicates whether the last successfully rendered
+	// output (and its revision) must be kept when reconciliation fails.
+	// Failures are then only reported via conditions and FailureMessage.
+	// When set to false, any failure clears Status.Resources and the content
+	// of the ConfigMap/Secret defined in Output.
+	// +kubebuilder:default:=true
+	// +optional
+	KeepLastOutputOnFailure *bool `json:"keepLastOutputOnFailure,omitempty"`
+
+	// SecretRedaction indicates how data and stringData of rendered Secrets are
+	// reported in Status.Resources, so that read access to YttSource does not
+	// grant access to credentials:
+	// - None: values are reported as rendered;
+	// - Redact: values are replaced with <redacted>;
+	// - Hash: values are replaced with their sha256 digest, so changes can still be spotted.
+	// With Redact and Hash, Status.Resources is set even when Output is set, while
+	// real values are only written to the ConfigMap/Secret defined in Output.
+	// +kubebuilder:validation:Enum=None;Redact;Hash
+	// +kubebuilder:default:=None
+	// +optional
+	SecretRedaction SecretRedactionMode `json:"secretRedaction,omitempty"`
+
+	// Decryption configures decryption of SOPS-encrypted files contained
+	// in the sources. Files are decrypted in memory, right before being handed
+	// to ytt.
+	// +optional
+	Decryption *Decryption `json:"decryption,omitempty"`
+
+	// AllowedSecretTypes lists the types of the Secrets ytt files, data values
+	// and decryption keys can be read from (for instance Opaque, for Secrets created by
+	// External Secrets Operator or sealed-secrets).
+	// When not set, the types allowed by the controller are used.
+	// +optional
+	AllowedSecretTypes []corev1.SecretType `json:"allowedSecretTypes,omitempty"`
+
+	// Timeout is the maximum time ytt can spend evaluating the templates.
+	// When exceeded, reconciliation fails with reason RenderTimeout.
+	// Capped by the controller --max-render-timeout, which is also the default.
+	// +kubebuilder:validation:Type=string
+	// +kubebuilder:validation:Pattern="^([0-9]+(\\.[0-9]+)?(ms|s|m|h))+$"
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// SourceReference references a resource containing ytt files.
+type SourceReference struct {
+	// Kind of the resource. Supported kinds are:
+	// - flux GitRepository;OCIRepository;Bucket;HelmChart;ExternalArtifact
+	// - ConfigMap/Secret
+	// - URL, a tarball fetched over HTTP(S) as described by URL
+	// - OCI, an artifact pulled from an OCI registry as described by OCI
+	// +kubebuilder:validation:Enum=GitRepository;OCIRepository;Bucket;HelmChart;ExternalArtifact;ConfigMap;Secret;URL;OCI
+	Kind string `json:"kind"`
+
+	// URL describes where the tarball is fetched from.
+	// Only used, and required, when Kind is URL.
+	// +optional
+	URL *URLSource `json:"url,omitempty"`
+
+	// OCI describes the artifact pulled from an OCI registry.
+	// Only used, and required, when Kind is OCI.
+	// +optional
+	OCI *OCISource `json:"oci,omitempty"`
+
+	// Namespace of the resource.
+	// Namespace can be left empty. In such a case, namespace will
+	// be implicit set to YttSource's namespace.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// Name of the resource.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Path to the directory, within the resource, containing ytt files.
+	// Defaults to the root of the resource.
+	// +optional
+	Path string `json:"path,omitempty"`
+
+	// DataLayout describes how ytt files are stored in the resource.
+	// Only used when Kind is ConfigMap or Secret.
+	// +optional
+	DataLayout *DataLayout `json:"dataLayout,omitempty"`
+
+	// MountPrefix is the directory files from this source are placed in,
+	// relative to the root of the ytt input (or to the library directory
+	// for libraries). Defaults to the root.
+	// Two sources cannot provide a file at the same location.
+	// +optional
+	MountPrefix string `json:"mountPrefix,omitempty"`
+
+	// Type of the ytt files contained in the resource:
+	// - Template: files are combined with the other templates, in order;
+	// - Overlay: files are placed after all templates and libraries, so overlays
+	// are applied last, in order, as with `ytt -f templates -f overlays`;
+	// - Library: files are placed in _ytt_lib/<LibraryName>, so templates can
+	// load them with load("@<LibraryName>:file").
+	// +kubebuilder:validation:Enum=Template;Overlay;Library
+	// +kubebuilder:default:=Template
+	// +optional
+	Type SourceType `json:"type,omitempty"`
+
+	// LibraryName is the name templates load the library with.
+	// Only used when Type is Library. Defaults to Name.
+	// +optional
+	LibraryName string `json:"libraryName,omitempty"`
+}
+
+// URLSource describes a tarball fetched over HTTP(S).
+type URLSource struct {
+	// Address of the tarball (.tar.gz).
+	// +kubebuilder:validation:Pattern="^https?://"
+	Address string `json:"address"`
+
+	// Checksum pins the content of the tarball, in the form sha256:<hex>.
+	// When set, a tarball with different content is rejected and, once fetched,
+	// the address is not polled anymore.
+	// +kubebuilder:validation:Pattern="^sha256:[a-f0-9]{64}$"
+	// +optional
+	Checksum string `json:"checksum,omitempty"`
+
+	// HeadersSecretRef references a Secret whose keys and values are sent as
+	// HTTP headers (for instance Authorization).
+	// The Secret must be in the YttSource namespace and of one of the allowed
+	// Secret types.
+	// +optional
+	HeadersSecretRef *corev1.SecretReference `json:"headersSecretRef,omitempty"`
+
+	// CASecretRef references a Secret whose ca.crt key contains the PEM-encoded
+	// certificates used, in addition to the system ones, to verify the server.
+	// The Secret must be in the YttSource namespace and of one of the allowed
+	// Secret types.
+	// +optional
+	CASecretRef *corev1.SecretReference `json:"caSecretRef,omitempty"`
+
+	// Interval at which the address is polled for changes. Requests are
+	// conditional (If-None-Match/If-Modified-Since), so the tarball is only
+	// downloaded again when the server reports it has changed.
+	// Defaults to 5m.
+	// +kubebuilder:validation:Type=string
+	// +kubebuilder:validation:Pattern="^([0-9]+(\\.[0-9]+)?(ms|s|m|h))+$"
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+}
+
+// OCISource describes an artifact (for instance an imgpkg bundle or an ORAS
+// artifact) pulled from an OCI registry.
+type OCISource struct {
+	// Reference of the artifact, in the form <registry>/<repository>:<tag>
+	// or <registry>/<repository>@<digest>.
+	// +kubebuilder:validation:MinLength=1
+	Reference string `json:"reference"`
+
+	// SecretRef references a kubernetes.io/dockerconfigjson Secret containing
+	// the credentials to pull the artifact.
+	// The Secret must be in the YttSource namespace.
+	// +optional
+	SecretRef *corev1.SecretReference `json:"secretRef,omitempty"`
+
+	// Verify, when set, requires the artifact to be signed with cosign.
+	// +optional
+	Verify *OCIVerification `json:"verify,omitempty"`
+
+	// Insecure allows pulling from registries served over plain HTTP.
+	// +optional
+	Insecure bool `json:"insecure,omitempty"`
+
+	// Interval at which the tag is resolved again to detect new digests.
+	// Not used when Reference contains a digest. Defaults to 5m.
+	// +kubebuilder:validation:Type=string
+	// +kubebuilder:validation:Pattern="^([0-9]+(\\.[0-9]+)?(ms|s|m|h))+$"
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+}
+
+// OCIVerification describes how the signature of an OCI artifact is verified.
+type OCIVerification struct {
+	// SecretRef references the Secret containing the cosign public keys.
+	// Keys ending with .pub contain PEM-encoded public keys. Artifact must be
+	// signed with at least one of them.
+	// The Secret must be in the YttSource namespace and of one of the allowed
+	// Secret types.
+	SecretRef corev1.SecretReference `json:"secretRef"`
+}
+
+// DataLayoutMode indicates how ytt files are stored in a ConfigMap/Secret
+type DataLayoutMode string
+
+const (
+	// DataLayoutArchive indicates ytt files are stored in archives
+	DataLayoutArchive = DataLayoutMode("Archive")
+
+	// DataLayoutFiles indicates each key is a ytt file
+	DataLayoutFiles = DataLayoutMode("Files")
+)
+
+const (
+	// DefaultArchiveKey is the key archive is read from when ArchiveKeys is not set
+	DefaultArchiveKey = "ytt.tar.gz"
+
+	// DefaultPathSeparator is the separator used when PathSeparator is not set
+	DefaultPathSeparator = "__"
+)
+
+// DataLayout describes how ytt files are stored in a ConfigMap/Secret.
+type DataLayout struct {
+	// Mode indicates how ytt files are stored:
+	// - Archive: ytt files are extracted from the archives stored in ArchiveKeys;
+	// - Files: each key of ConfigMap Data/BinaryData or Secret Data is a ytt file.
+	// +kubebuilder:validation:Enum=Archive;Files
+	// +kubebuilder:default:=Archive
+	// +optional
+	Mode DataLayoutMode `json:"mode,omitempty"`
+
+	// ArchiveKeys are the keys containing archives. Only used in Archive mode.
+	// Archive format is derived from key suffix: .tar, .tar.gz, .tgz or .zip.
+	// Archives are extracted in order in the same directory.
+	// Defaults to ytt.tar.gz.
+	// +optional
+	ArchiveKeys []string `json:"archiveKeys,omitempty"`
+
+	// PathSeparator is replaced with '/' in keys, so that files can be placed
+	// in subdirectories (for instance with the default separator, key
+	// _ytt_lib__helpers__labels.star is file _ytt_lib/helpers/labels.star).
+	// Only used in Files mode. Defaults to "__".
+	// +optional
+	PathSeparator string `json:"pathSeparator,omitempty"`
+}
+
+const (
+	// AgeKeySuffix is the suffix of the keys of the decryption Secret
+	// containing age identities
+	AgeKeySuffix = ".agekey"
+
+	// PGPKeySuffix is the suffix of the keys of the decryption Secret
+	// containing armored PGP private keys
+	PGPKeySuffix = ".asc"
+)
+
+// Decryption describes how SOPS-encrypted files are decrypted.
+type Decryption struct {
+	// SecretRef references the Secret containing the decryption keys.
+	// Keys ending with .agekey contain age identities, keys ending with
+	// .asc contain armored PGP private keys.
+	// The Secret must be of one of the allowed Secret types.
+	// Namespace can be left empty. In such a case, namespace will
+	// be implicit set to YttSource's namespace.
+	SecretRef corev1.SecretReference `json:"secretRef"`
+
+	// Suffixes of the files to decrypt. Only YAML and JSON files are supported.
+	// When neither Suffixes nor Files is set, files ending with .sops.yaml,
+	// .sops.yml and .sops.json are decrypted.
+	// +optional
+	Suffixes []string `json:"suffixes,omitempty"`
+
+	// Files lists the files to decrypt, relative to the root of the ytt input
+	// (so including the source MountPrefix, if any).
+	// +optional
+	Files []string `json:"files,omitempty"`
+}
+
+// SecretRedactionMode indicates how data and stringData of rendered Secrets
+// are reported in Status.Resources
+type SecretRedactionMode string
+
+const (
+	// SecretRedactionNone reports values as rendered
+	SecretRedactionNone = SecretRedactionMode("None")
+
+	// SecretRedactionRedact replaces values with RedactedValue
+	SecretRedactionRedact = SecretRedactionMode("Redact")
+
+	// SecretRedactionHash replaces values with their sha256 digest
+	SecretRedactionHash = SecretRedactionMode("Hash")
+)
+
+// RedactedValue replaces values of Secrets data and stringData in Redact mode
+const RedactedValue = "<redacted>"
+
+// Output defines where ytt output is stored.
+type Output struct {
+	// Kind of the resource. Supported kinds are ConfigMap and Secret.
+	// Secrets are created with type addons.projectsveltos.io/cluster-profile
+	// so they can be directly referenced by Sveltos.
+	// +kubebuilder:validation:Enum=ConfigMap;Secret
+	Kind string `json:"kind"`
+
+	// Namespace of the resource.
+	// Namespace can be left empty. In such a case, namespace will
+	// be implicit set to YttSource's namespace.
+	// A different namespace is only allowed when the controller runs
+	// with --allow-cross-namespace-output.
+	// An owner reference is set only when the resource is in the
+	// YttSource namespace. Otherwise the resource is removed when
+	// YttSource is deleted.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// Name of the resource.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Key the output is stored at. Defaults to resources.yaml
+	// +optional
+	Key string `json:"key,omitempty"`
+
+	// Gzip indicates whether output must be gzip compressed.
+	// For ConfigMaps, compressed output is stored in BinaryData.
+	// +optional
+	Gzip bool `json:"gzip,omitempty"`
+}
+
+// DataValues defines the ytt data values passed to the templates.
+// When more than one source is set, values are applied in the same
+// order ytt uses: files (ValuesFrom first, then Inline), then Values,
+// then YAMLValues.
+type DataValues struct {
+	// Inline contains a plain YAML document with data values.
+	// Equivalent to `--data-values-file`.
+	// +optional
+	Inline string `json:"inline,omitempty"`
+
+	// Values contains key/value pairs. Values are used as strings.
+	// Keys can reference nested data values using dots (e.g. app.name).
+	// Equivalent to `--data-value key=value`.
+	// +optional
+	Values map[string]string `json:"values,omitempty"`
+
+	// YAMLValues contains key/value pairs. Values are parsed as YAML.
+	// Keys can reference nested data values using dots (e.g. app.replicas).
+	// Equivalent to `--data-value-yaml key=value`.
+	// +optional
+	YAMLValues map[string]string `json:"yamlValues,omitempty"`
+
+	// ValuesFrom references ConfigMaps/Secrets containing plain YAML
+	// data values files. Each key is treated as a separate file.
+	// Equivalent to `--data-values-file`.
+	// +optional
+	ValuesFrom []ValuesReference `json:"valuesFrom,omitempty"`
+}
+
+// ValuesReference references a ConfigMap or Secret containing data values.
+type ValuesReference struct {
+	// Kind of the resource. Supported kinds are ConfigMap and Secret.
+	// +kubebuilder:validation:Enum=ConfigMap;Secret
+	Kind string `json:"kind"`
+
+	// Namespace of the referenced resource.
+	// Namespace can be left empty. In such a case, namespace will
+	// be implicit set to YttSource's namespace.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// Name of the referenced resource.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Key within the referenced resource. If not set, all keys
+	// are used, in alphabetical order.
+	// +optional
+	Key string `json:"key,omitempty"`
+
+	// Optional indicates whether a missing resource (or key)
+	// should be ignored.
+	// +optional
+	Optional bool `json:"optional,omitempty"`
 }
 
 // YttSourceStatus defines the observed state of YttSource
 type YttSourceStatus struct {
 	// Resources contains the output of YTT, so the
-	// resources to be deployed
+	// resources to be deployed.
+	// Not set when Spec.Output is set, unless Spec.SecretRedaction is
+	// Redact or Hash.
 	Resources string `json:"resources,omitempty"`
 
+	// OutputRef references the ConfigMap/Secret the output is
+	// written to, when Spec.Output is set.
+	// +optional
+	OutputRef *corev1.ObjectReference `json:"outputRef,omitempty"`
+
+	// OutputDigest is the sha256 digest of the last rendered output.
+	// +optional
+	OutputDigest string `json:"outputDigest,omitempty"`
+
+	// InputDigest is the fingerprint of the inputs of the last successful
+	// rendering: source revision, path, data values and spec generation.
+	// Rendering is skipped when inputs have not changed.
+	// +optional
+	InputDigest string `json:"inputDigest,omitempty"`
+
 	// FailureMessage provides more information about the error.
 	// +optional
 	FailureMessage *string `json:"failureMessage,omitempty"`
+
+	// ObservedGeneration is the last generation reconciled by the controller.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// LastAttemptedRevision is the revision of the source the controller
+	// last attempted to render.
+	// +optional
+	LastAttemptedRevision string `json:"lastAttemptedRevision,omitempty"`
+
+	// LastAppliedRevision is the revision of the source the controller
+	// last successfully rendered.
+	// +optional
+	LastAppliedRevision string `json:"lastAppliedRevision,omitempty"`
+
+	// Conditions contains the Ready, SourceReady, TemplateRendered and
+	// Stalled conditions.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
 // +kubebuilder:storageversion
+//+kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+//+kubebuilder:printcolumn:name="Status",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].message"
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 
 // YttSource is the Schema for the yttsources API
 type YttSource struct {
@@ -72,6 +570,18 @@ type YttSource struct {
 	Status YttSourceStatus `json:"status,omitempty"`
 }
 
+// ShouldKeepLastOutputOnFailure returns true if the last successfully rendered
+// output must be kept when reconciliation fails. Defaults to true.
+func (s *YttSourceSpec) ShouldKeepLastOutputOnFailure() bool {
+	return s.KeepLastOutputOnFailure == nil || *s.KeepLastOutputOnFailure
+}
+
+// IsSecretRedactionEnabled returns true if values of rendered Secrets must not be
+// reported in Status.Resources
+func (s *YttSourceSpec) IsSecretRedactionEnabled() bool {
+	return s.SecretRedaction == SecretRedactionRedact || s.SecretRedaction == SecretRedactionHash
+}
+
 //+kubebuilder:object:root=true
 
 // YttSourceList contains a list of YttSource
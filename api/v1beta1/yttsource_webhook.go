@@ -0,0 +1,514 @@
+/*
+Copyright 2024. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	configMapKind = "ConfigMap"
+	secretKind    = "Secret"
+)
+
+// sourceKinds contains the kinds a YttSource can fetch ytt files from
+var sourceKinds = []string{"GitRepository", "OCIRepository", "Bucket", "HelmChart", "ExternalArtifact",
+	configMapKind, secretKind, URLSourceKind, OCISourceKind}
+
+// archiveSuffixes contains the suffixes of the archive formats which can be extracted
+var archiveSuffixes = []string{".tar", ".tar.gz", ".tgz", ".zip"}
+
+// referenceKinds contains the kinds data values can be read from and output can be written to
+var referenceKinds = []string{configMapKind, secretKind}
+
+//+kubebuilder:webhook:path=/validate-extension-projectsveltos-io-v1beta1-yttsource,mutating=false,failurePolicy=fail,sideEffects=None,groups=extension.projectsveltos.io,resources=yttsources,verbs=create;update,versions=v1beta1,name=vyttsource.extension.projectsveltos.io,admissionReviewVersions=v1
+
+// YttSourceValidator validates YttSources on create and update.
+// +kubebuilder:object:generate=false
+type YttSourceValidator struct {
+	// AllowCrossNamespaceReferences indicates whether a YttSource can reference
+	// sources, data values and decryption keys in a namespace different from its own.
+	AllowCrossNamespaceReferences bool
+	// AllowCrossNamespaceOutput indicates whether a YttSource can write its output
+	// to a namespace different from its own.
+	AllowCrossNamespaceOutput bool
+}
+
+var _ admission.CustomValidator = &YttSourceValidator{}
+
+// SetupWebhookWithManager registers the YttSource webhooks with the manager.
+func (v *YttSourceValidator) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&YttSource{}).
+		WithValidator(v).
+		Complete()
+}
+
+// ValidateCreate implements admission.CustomValidator.
+func (v *YttSourceValidator) ValidateCreate(_ context.Context, obj runtime.Object) (admission.Warnings, error) {
+	yttSource, ok := obj.(*YttSource)
+	if !ok {
+		return nil, fmt.Errorf("expected a YttSource but got a %T", obj)
+	}
+
+	return getWarnings(yttSource), v.validate(yttSource)
+}
+
+// ValidateUpdate implements admission.CustomValidator.
+func (v *YttSourceValidator) ValidateUpdate(_ context.Context, _, newObj runtime.Object) (admission.Warnings, error) {
+	yttSource, ok := newObj.(*YttSource)
+	if !ok {
+		return nil, fmt.Errorf("expected a YttSource but got a %T", newObj)
+	}
+
+	return getWarnings(yttSource), v.validate(yttSource)
+}
+
+// ValidateDelete implements admission.CustomValidator.
+func (v *YttSourceValidator) ValidateDelete(_ context.Context, _ runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// getWarnings returns warnings for valid but risky settings
+func getWarnings(yttSource *YttSource) admission.Warnings {
+	if !yttSource.Spec.IsSecretRedactionEnabled() {
+		return nil
+	}
+
+	output := yttSource.Spec.Output
+	if output == nil {
+		return admission.Warnings{"spec.secretRedaction is set but spec.output is not: " +
+			"rendered Secrets are only available with redacted values"}
+	}
+	if output.Kind == configMapKind {
+		return admission.Warnings{"spec.secretRedaction is set but spec.output is a ConfigMap: " +
+			"values of rendered Secrets are stored in clear text in the ConfigMap"}
+	}
+
+	return nil
+}
+
+func (v *YttSourceValidator) validate(yttSource *YttSource) error {
+	specPath := field.NewPath("spec")
+
+	var allErrs field.ErrorList
+	allErrs = append(allErrs, validateSource(&yttSource.Spec, specPath)...)
+	allErrs = append(allErrs, validateDataValues(yttSource.Spec.DataValues, specPath.Child("dataValues"))...)
+	allErrs = append(allErrs, validateOutput(yttSource, specPath.Child("output"))...)
+	allErrs = append(allErrs, validateDecryption(yttSource.Spec.Decryption, specPath.Child("decryption"))...)
+	if yttSource.Spec.Timeout != nil && yttSource.Spec.Timeout.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("timeout"), yttSource.Spec.Timeout.Duration.String(),
+			"timeout must be positive"))
+	}
+	if !v.AllowCrossNamespaceReferences {
+		allErrs = append(allErrs, validateNamespaces(yttSource, specPath)...)
+	}
+	if !v.AllowCrossNamespaceOutput {
+		allErrs = append(allErrs, validateOutputNamespace(yttSource, specPath.Child("output"))...)
+	}
+	allErrs = append(allErrs, validateSourceSecretNamespaces(yttSource, specPath)...)
+
+	if len(allErrs) == 0 {
+		return nil
+	}
+
+	return apierrors.NewInvalid(GroupVersion.WithKind("YttSource").GroupKind(), yttSource.Name, allErrs)
+}
+
+func validateSource(spec *YttSourceSpec, specPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if spec.Kind == "" && len(spec.Sources) == 0 && len(spec.Files) == 0 {
+		allErrs = append(allErrs, field.Required(specPath.Child("kind"),
+			"one of kind and name, sources or files must be set"))
+	}
+
+	if spec.Kind != "" {
+		if !slices.Contains(sourceKinds, spec.Kind) {
+			allErrs = append(allErrs, field.NotSupported(specPath.Child("kind"), spec.Kind, sourceKinds))
+		}
+
+		if spec.Name == "" {
+			allErrs = append(allErrs, field.Required(specPath.Child("name"),
+				"name of the referenced source must be set"))
+		}
+	}
+
+	allErrs = append(allErrs, validateURLSource(spec.Kind, spec.URL, specPath.Child("url"))...)
+	allErrs = append(allErrs, validateOCISource(spec.Kind, spec.OCI, specPath.Child("oci"))...)
+	allErrs = append(allErrs, validateRelativePath(spec.Path, specPath.Child("path"))...)
+	allErrs = append(allErrs, validateDataLayout(spec.Kind, spec.DataLayout, specPath.Child("dataLayout"))...)
+
+	for i := range spec.Sources {
+		sourcePath := specPath.Child("sources").Index(i)
+		if !slices.Contains(sourceKinds, spec.Sources[i].Kind) {
+			allErrs = append(allErrs, field.NotSupported(sourcePath.Child("kind"), spec.Sources[i].Kind, sourceKinds))
+		}
+		allErrs = append(allErrs, validateURLSource(spec.Sources[i].Kind, spec.Sources[i].URL, sourcePath.Child("url"))...)
+		allErrs = append(allErrs, validateOCISource(spec.Sources[i].Kind, spec.Sources[i].OCI, sourcePath.Child("oci"))...)
+		allErrs = append(allErrs, validateRelativePath(spec.Sources[i].Path, sourcePath.Child("path"))...)
+		allErrs = append(allErrs, validateRelativePath(spec.Sources[i].MountPrefix, sourcePath.Child("mountPrefix"))...)
+		allErrs = append(allErrs, validateDataLayout(spec.Sources[i].Kind, spec.Sources[i].DataLayout,
+			sourcePath.Child("dataLayout"))...)
+		if spec.Sources[i].LibraryName != "" && spec.Sources[i].Type != SourceTypeLibrary {
+			allErrs = append(allErrs, field.Invalid(sourcePath.Child("libraryName"), spec.Sources[i].LibraryName,
+				"only allowed for sources of type Library"))
+		}
+		allErrs = append(allErrs, validateRelativePath(spec.Sources[i].LibraryName, sourcePath.Child("libraryName"))...)
+	}
+
+	// Names are sorted so the same one is reported, whatever the map order
+	names := make([]string, 0, len(spec.Files))
+	for name := range spec.Files {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	cleanNames := make(map[string]string, len(names))
+	for _, name := range names {
+		filePath := specPath.Child("files").Key(name)
+		if strings.Trim(name, "/.") == "" {
+			allErrs = append(allErrs, field.Invalid(filePath, name, "must be a file name"))
+			continue
+		}
+		allErrs = append(allErrs, validateRelativePath(name, filePath)...)
+		if other, ok := cleanNames[path.Clean(name)]; ok {
+			allErrs = append(allErrs, field.Duplicate(filePath, fmt.Sprintf("same file as %q", other)))
+		}
+		cleanNames[path.Clean(name)] = name
+	}
+
+	return allErrs
+}
+
+// validateURLSource verifies url is set if and only if kind is URL
+func validateURLSource(kind string, url *URLSource, urlPath *field.Path) field.ErrorList {
+	if kind != URLSourceKind {
+		if url != nil {
+			return field.ErrorList{field.Forbidden(urlPath, "only allowed for kind URL")}
+		}
+		return nil
+	}
+
+	if url == nil {
+		return field.ErrorList{field.Required(urlPath, "url must be set for kind URL")}
+	}
+
+	var allErrs field.ErrorList
+	if !strings.HasPrefix(url.Address, "http://") && !strings.HasPrefix(url.Address, "https://") {
+		allErrs = append(allErrs, field.Invalid(urlPath.Child("address"), url.Address,
+			"must be an http:// or https:// address"))
+	}
+	if url.HeadersSecretRef != nil && url.HeadersSecretRef.Name == "" {
+		allErrs = append(allErrs, field.Required(urlPath.Child("headersSecretRef").Child("name"),
+			"name of the headers Secret must be set"))
+	}
+	if url.CASecretRef != nil && url.CASecretRef.Name == "" {
+		allErrs = append(allErrs, field.Required(urlPath.Child("caSecretRef").Child("name"),
+			"name of the CA Secret must be set"))
+	}
+	if url.Interval != nil && url.Interval.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(urlPath.Child("interval"), url.Interval.Duration.String(),
+			"interval must be positive"))
+	}
+
+	return allErrs
+}
+
+// validateOCISource verifies oci is set if and only if kind is OCI
+func validateOCISource(kind string, oci *OCISource, ociPath *field.Path) field.ErrorList {
+	if kind != OCISourceKind {
+		if oci != nil {
+			return field.ErrorList{field.Forbidden(ociPath, "only allowed for kind OCI")}
+		}
+		return nil
+	}
+
+	if oci == nil {
+		return field.ErrorList{field.Required(ociPath, "oci must be set for kind OCI")}
+	}
+
+	var allErrs field.ErrorList
+	if oci.Reference == "" {
+		allErrs = append(allErrs, field.Required(ociPath.Child("reference"), "reference of the artifact must be set"))
+	}
+	if oci.SecretRef != nil && oci.SecretRef.Name == "" {
+		allErrs = append(allErrs, field.Required(ociPath.Child("secretRef").Child("name"),
+			"name of the registry credentials Secret must be set"))
+	}
+	if oci.Verify != nil && oci.Verify.SecretRef.Name == "" {
+		allErrs = append(allErrs, field.Required(ociPath.Child("verify").Child("secretRef").Child("name"),
+			"name of the cosign public keys Secret must be set"))
+	}
+	if oci.Interval != nil && oci.Interval.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(ociPath.Child("interval"), oci.Interval.Duration.String(),
+			"interval must be positive"))
+	}
+
+	return allErrs
+}
+
+// validateDataLayout verifies layout is only set for ConfigMaps/Secrets and only
+// references supported archive formats
+func validateDataLayout(kind string, layout *DataLayout, layoutPath *field.Path) field.ErrorList {
+	if layout == nil {
+		return nil
+	}
+
+	if !slices.Contains(referenceKinds, kind) {
+		return field.ErrorList{field.Forbidden(layoutPath, "only allowed for ConfigMap and Secret")}
+	}
+
+	var allErrs field.ErrorList
+	for i, key := range layout.ArchiveKeys {
+		if !slices.ContainsFunc(archiveSuffixes, func(suffix string) bool { return strings.HasSuffix(key, suffix) }) {
+			allErrs = append(allErrs, field.Invalid(layoutPath.Child("archiveKeys").Index(i), key,
+				fmt.Sprintf("must end with one of %s", strings.Join(archiveSuffixes, ", "))))
+		}
+	}
+
+	if strings.Contains(layout.PathSeparator, "/") {
+		allErrs = append(allErrs, field.Invalid(layoutPath.Child("pathSeparator"), layout.PathSeparator,
+			"must not contain '/'"))
+	}
+
+	return allErrs
+}
+
+// validateRelativePath verifies p, when set, is relative and does not escape its root
+func validateRelativePath(p string, fldPath *field.Path) field.ErrorList {
+	if p == "" {
+		return nil
+	}
+
+	if path.IsAbs(p) {
+		return field.ErrorList{field.Invalid(fldPath, p, "must be a relative path")}
+	}
+	if hasParentReference(p) {
+		return field.ErrorList{field.Invalid(fldPath, p, "must not contain '..'")}
+	}
+
+	return nil
+}
+
+func validateDataValues(dataValues *DataValues, dataValuesPath *field.Path) field.ErrorList {
+	if dataValues == nil {
+		return nil
+	}
+
+	var allErrs field.ErrorList
+	for i := range dataValues.ValuesFrom {
+		valuesFromPath := dataValuesPath.Child("valuesFrom").Index(i)
+		if !slices.Contains(referenceKinds, dataValues.ValuesFrom[i].Kind) {
+			allErrs = append(allErrs, field.NotSupported(valuesFromPath.Child("kind"),
+				dataValues.ValuesFrom[i].Kind, referenceKinds))
+		}
+	}
+
+	return allErrs
+}
+
+func validateDecryption(decryption *Decryption, decryptionPath *field.Path) field.ErrorList {
+	if decryption == nil {
+		return nil
+	}
+
+	var allErrs field.ErrorList
+	if decryption.SecretRef.Name == "" {
+		allErrs = append(allErrs, field.Required(decryptionPath.Child("secretRef").Child("name"),
+			"name of the Secret containing decryption keys must be set"))
+	}
+
+	for i, suffix := range decryption.Suffixes {
+		if suffix == "" {
+			allErrs = append(allErrs, field.Invalid(decryptionPath.Child("suffixes").Index(i), suffix,
+				"must not be empty"))
+		}
+	}
+
+	for i, file := range decryption.Files {
+		allErrs = append(allErrs, validateRelativePath(file, decryptionPath.Child("files").Index(i))...)
+	}
+
+	return allErrs
+}
+
+func validateOutput(yttSource *YttSource, outputPath *field.Path) field.ErrorList {
+	output := yttSource.Spec.Output
+	if output == nil {
+		return nil
+	}
+
+	var allErrs field.ErrorList
+	if !slices.Contains(referenceKinds, output.Kind) {
+		allErrs = append(allErrs, field.NotSupported(outputPath.Child("kind"), output.Kind, referenceKinds))
+	}
+
+	// Output must never overwrite any of YttSource inputs
+	outputNamespace := getNamespace(output.Namespace, yttSource.Namespace)
+	if output.Kind == yttSource.Spec.Kind && output.Name == yttSource.Spec.Name &&
+		outputNamespace == yttSource.Spec.Namespace {
+
+		allErrs = append(allErrs, field.Invalid(outputPath, fmt.Sprintf("%s %s/%s", output.Kind, outputNamespace, output.Name),
+			"output must not reference the source containing ytt files"))
+	}
+
+	for i := range yttSource.Spec.Sources {
+		source := &yttSource.Spec.Sources[i]
+		if output.Kind == source.Kind && output.Name == source.Name &&
+			outputNamespace == getNamespace(source.Namespace, yttSource.Namespace) {
+
+			allErrs = append(allErrs, field.Invalid(outputPath, fmt.Sprintf("%s %s/%s", output.Kind, outputNamespace, output.Name),
+				fmt.Sprintf("output must not reference spec.sources[%d]", i)))
+		}
+	}
+
+	if yttSource.Spec.DataValues != nil {
+		for i := range yttSource.Spec.DataValues.ValuesFrom {
+			ref := &yttSource.Spec.DataValues.ValuesFrom[i]
+			if output.Kind == ref.Kind && output.Name == ref.Name &&
+				outputNamespace == getNamespace(ref.Namespace, yttSource.Namespace) {
+
+				allErrs = append(allErrs, field.Invalid(outputPath, fmt.Sprintf("%s %s/%s", output.Kind, outputNamespace, output.Name),
+					fmt.Sprintf("output must not reference spec.dataValues.valuesFrom[%d]", i)))
+			}
+		}
+	}
+
+	return allErrs
+}
+
+// validateNamespaces verifies all resources referenced by YttSource are in YttSource namespace.
+func validateNamespaces(yttSource *YttSource, specPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	msg := fmt.Sprintf("cross-namespace references are not allowed: namespace must be %q", yttSource.Namespace)
+
+	if yttSource.Spec.Namespace != "" && yttSource.Spec.Namespace != yttSource.Namespace {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("namespace"), msg))
+	}
+
+	for i := range yttSource.Spec.Sources {
+		sourcePath := specPath.Child("sources").Index(i)
+		namespace := yttSource.Spec.Sources[i].Namespace
+		if namespace != "" && namespace != yttSource.Namespace {
+			allErrs = append(allErrs, field.Forbidden(sourcePath.Child("namespace"), msg))
+		}
+	}
+
+	if yttSource.Spec.DataValues != nil {
+		for i := range yttSource.Spec.DataValues.ValuesFrom {
+			namespace := yttSource.Spec.DataValues.ValuesFrom[i].Namespace
+			if namespace != "" && namespace != yttSource.Namespace {
+				allErrs = append(allErrs, field.Forbidden(
+					specPath.Child("dataValues").Child("valuesFrom").Index(i).Child("namespace"), msg))
+			}
+		}
+	}
+
+	if decryption := yttSource.Spec.Decryption; decryption != nil &&
+		decryption.SecretRef.Namespace != "" && decryption.SecretRef.Namespace != yttSource.Namespace {
+
+		allErrs = append(allErrs, field.Forbidden(
+			specPath.Child("decryption").Child("secretRef").Child("namespace"), msg))
+	}
+
+	return allErrs
+}
+
+// validateOutputNamespace verifies output is written to the YttSource namespace
+func validateOutputNamespace(yttSource *YttSource, outputPath *field.Path) field.ErrorList {
+	output := yttSource.Spec.Output
+	if output == nil || output.Namespace == "" || output.Namespace == yttSource.Namespace {
+		return nil
+	}
+	return field.ErrorList{field.Forbidden(outputPath.Child("namespace"),
+		fmt.Sprintf("cross-namespace output is not allowed: namespace must be %q", yttSource.Namespace))}
+}
+
+// validateSourceSecretNamespaces verifies the Secrets remote sources are fetched with are in
+// the YttSource namespace. Their content is sent to an address chosen by whoever creates the
+// YttSource, so this is enforced even when cross-namespace references are allowed.
+func validateSourceSecretNamespaces(yttSource *YttSource, specPath *field.Path) field.ErrorList {
+	msg := fmt.Sprintf("Secrets remote sources are fetched with must be in namespace %q", yttSource.Namespace)
+
+	allErrs := validateURLSourceNamespaces(yttSource, yttSource.Spec.URL, specPath.Child("url"), msg)
+	allErrs = append(allErrs, validateOCISourceNamespaces(yttSource, yttSource.Spec.OCI, specPath.Child("oci"), msg)...)
+	for i := range yttSource.Spec.Sources {
+		sourcePath := specPath.Child("sources").Index(i)
+		allErrs = append(allErrs, validateURLSourceNamespaces(yttSource, yttSource.Spec.Sources[i].URL,
+			sourcePath.Child("url"), msg)...)
+		allErrs = append(allErrs, validateOCISourceNamespaces(yttSource, yttSource.Spec.Sources[i].OCI,
+			sourcePath.Child("oci"), msg)...)
+	}
+	return allErrs
+}
+
+// validateURLSourceNamespaces verifies the Secrets referenced by url are in the YttSource namespace
+func validateURLSourceNamespaces(yttSource *YttSource, url *URLSource, urlPath *field.Path, msg string) field.ErrorList {
+	if url == nil {
+		return nil
+	}
+
+	var allErrs field.ErrorList
+	if ref := url.HeadersSecretRef; ref != nil && ref.Namespace != "" && ref.Namespace != yttSource.Namespace {
+		allErrs = append(allErrs, field.Forbidden(urlPath.Child("headersSecretRef").Child("namespace"), msg))
+	}
+	if ref := url.CASecretRef; ref != nil && ref.Namespace != "" && ref.Namespace != yttSource.Namespace {
+		allErrs = append(allErrs, field.Forbidden(urlPath.Child("caSecretRef").Child("namespace"), msg))
+	}
+	return allErrs
+}
+
+// validateOCISourceNamespaces verifies the Secrets referenced by oci are in the YttSource namespace
+func validateOCISourceNamespaces(yttSource *YttSource, oci *OCISource, ociPath *field.Path, msg string) field.ErrorList {
+	if oci == nil {
+		return nil
+	}
+
+	var allErrs field.ErrorList
+	if ref := oci.SecretRef; ref != nil && ref.Namespace != "" && ref.Namespace != yttSource.Namespace {
+		allErrs = append(allErrs, field.Forbidden(ociPath.Child("secretRef").Child("namespace"), msg))
+	}
+	if verify := oci.Verify; verify != nil && verify.SecretRef.Namespace != "" &&
+		verify.SecretRef.Namespace != yttSource.Namespace {
+
+		allErrs = append(allErrs, field.Forbidden(ociPath.Child("verify").Child("secretRef").Child("namespace"), msg))
+	}
+	return allErrs
+}
+
+// hasParentReference returns true if any element of p is ".."
+func hasParentReference(p string) bool {
+	return slices.Contains(strings.Split(p, "/"), "..")
+}
+
+func getNamespace(namespace, defaultNamespace string) string {
+	if namespace == "" {
+		return defaultNamespace
+	}
+	return namespace
+}
@@ -0,0 +1,465 @@
+/*
+Copyright 2024. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	extensionv1beta1 "github.com/gianlucam76/ytt-controller/api/v1beta1"
+)
+
+func getYttSource() *extensionv1beta1.YttSource {
+	return &extensionv1beta1.YttSource{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "apps",
+			Name:      "ytt",
+		},
+		Spec: extensionv1beta1.YttSourceSpec{
+			Namespace: "flux-system",
+			Name:      "flux-system",
+			Kind:      "GitRepository",
+			Path:      "./deployment/",
+		},
+	}
+}
+
+var _ = Describe("YttSource webhook", func() {
+	var validator *extensionv1beta1.YttSourceValidator
+
+	BeforeEach(func() {
+		validator = &extensionv1beta1.YttSourceValidator{AllowCrossNamespaceReferences: true}
+	})
+
+	It("accepts a valid YttSource", func() {
+		_, err := validator.ValidateCreate(context.TODO(), getYttSource())
+		Expect(err).To(BeNil())
+	})
+
+	DescribeTable("rejects invalid paths",
+		func(path string) {
+			yttSource := getYttSource()
+			yttSource.Spec.Path = path
+			_, err := validator.ValidateCreate(context.TODO(), yttSource)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.path"))
+		},
+		Entry("absolute", "/deployment"),
+		Entry("parent", "../deployment"),
+		Entry("nested parent", "./deployment/../../other"),
+	)
+
+	It("accepts paths containing dots which are not parent references", func() {
+		yttSource := getYttSource()
+		yttSource.Spec.Path = "./deployment..v2/.config"
+		_, err := validator.ValidateCreate(context.TODO(), yttSource)
+		Expect(err).To(BeNil())
+	})
+
+	It("accepts an empty source namespace, which defaults to the YttSource one", func() {
+		yttSource := getYttSource()
+		yttSource.Spec.Namespace = ""
+		_, err := validator.ValidateUpdate(context.TODO(), getYttSource(), yttSource)
+		Expect(err).To(BeNil())
+
+		validator.AllowCrossNamespaceReferences = false
+		_, err = validator.ValidateUpdate(context.TODO(), getYttSource(), yttSource)
+		Expect(err).To(BeNil())
+	})
+
+	DescribeTable("accepts every Flux source kind",
+		func(kind string) {
+			yttSource := getYttSource()
+			yttSource.Spec.Kind = kind
+			_, err := validator.ValidateCreate(context.TODO(), yttSource)
+			Expect(err).To(BeNil())
+		},
+		Entry("GitRepository", "GitRepository"),
+		Entry("OCIRepository", "OCIRepository"),
+		Entry("Bucket", "Bucket"),
+		Entry("HelmChart", "HelmChart"),
+		Entry("ExternalArtifact", "ExternalArtifact"),
+	)
+
+	It("rejects unsupported kinds", func() {
+		yttSource := getYttSource()
+		yttSource.Spec.Kind = "HelmRepository"
+		yttSource.Spec.DataValues = &extensionv1beta1.DataValues{
+			ValuesFrom: []extensionv1beta1.ValuesReference{{Kind: "GitRepository", Name: "values"}},
+		}
+		_, err := validator.ValidateCreate(context.TODO(), yttSource)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("spec.kind"))
+		Expect(err.Error()).To(ContainSubstring("spec.dataValues.valuesFrom[0].kind"))
+	})
+
+	It("rejects output overwriting the source or data values", func() {
+		yttSource := getYttSource()
+		yttSource.Spec.Kind = "ConfigMap"
+		yttSource.Spec.Namespace = yttSource.Namespace
+		yttSource.Spec.Output = &extensionv1beta1.Output{Kind: "ConfigMap", Name: yttSource.Spec.Name}
+		_, err := validator.ValidateCreate(context.TODO(), yttSource)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("source containing ytt files"))
+
+		yttSource = getYttSource()
+		yttSource.Spec.DataValues = &extensionv1beta1.DataValues{
+			ValuesFrom: []extensionv1beta1.ValuesReference{{Kind: "Secret", Name: "values"}},
+		}
+		yttSource.Spec.Output = &extensionv1beta1.Output{Kind: "Secret", Name: "values"}
+		_, err = validator.ValidateCreate(context.TODO(), yttSource)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("spec.dataValues.valuesFrom[0]"))
+	})
+
+	It("rejects cross-namespace references when not allowed", func() {
+		yttSource := getYttSource()
+
+		_, err := validator.ValidateCreate(context.TODO(), yttSource)
+		Expect(err).To(BeNil())
+
+		validator.AllowCrossNamespaceReferences = false
+		_, err = validator.ValidateCreate(context.TODO(), yttSource)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("spec.namespace"))
+
+		yttSource.Spec.Namespace = yttSource.Namespace
+		_, err = validator.ValidateCreate(context.TODO(), yttSource)
+		Expect(err).To(BeNil())
+	})
+
+	It("rejects output in another namespace unless allowed", func() {
+		yttSource := getYttSource()
+		yttSource.Spec.Output = &extensionv1beta1.Output{Kind: "Secret", Namespace: "other", Name: "output"}
+
+		// Cross-namespace references do not allow cross-namespace output
+		_, err := validator.ValidateCreate(context.TODO(), yttSource)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("spec.output.namespace"))
+
+		validator.AllowCrossNamespaceOutput = true
+		_, err = validator.ValidateCreate(context.TODO(), yttSource)
+		Expect(err).To(BeNil())
+
+		validator.AllowCrossNamespaceOutput = false
+		yttSource.Spec.Output.Namespace = yttSource.Namespace
+		_, err = validator.ValidateCreate(context.TODO(), yttSource)
+		Expect(err).To(BeNil())
+	})
+
+	It("accepts a YttSource referencing only sources", func() {
+		yttSource := getYttSource()
+		yttSource.Spec = extensionv1beta1.YttSourceSpec{
+			Sources: []extensionv1beta1.SourceReference{
+				{Kind: "GitRepository", Namespace: "flux-system", Name: "base", Path: "./config"},
+				{Kind: "ConfigMap", Name: "overlays", MountPrefix: "overlays"},
+			},
+		}
+		_, err := validator.ValidateCreate(context.TODO(), yttSource)
+		Expect(err).To(BeNil())
+	})
+
+	It("rejects a YttSource referencing no source", func() {
+		yttSource := getYttSource()
+		yttSource.Spec = extensionv1beta1.YttSourceSpec{}
+		_, err := validator.ValidateCreate(context.TODO(), yttSource)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("one of kind and name, sources or files must be set"))
+	})
+
+	It("rejects invalid sources", func() {
+		yttSource := getYttSource()
+		yttSource.Spec.Sources = []extensionv1beta1.SourceReference{
+			{Kind: "HelmRepository", Name: "charts"},
+			{Kind: "ConfigMap", Name: "overlays", Path: "/overlays", MountPrefix: "../overlays"},
+		}
+		_, err := validator.ValidateCreate(context.TODO(), yttSource)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("spec.sources[0].kind"))
+		Expect(err.Error()).To(ContainSubstring("spec.sources[1].path"))
+		Expect(err.Error()).To(ContainSubstring("spec.sources[1].mountPrefix"))
+	})
+
+	It("rejects output overwriting any of the sources", func() {
+		yttSource := getYttSource()
+		yttSource.Spec.Sources = []extensionv1beta1.SourceReference{{Kind: "ConfigMap", Name: "overlays"}}
+		yttSource.Spec.Output = &extensionv1beta1.Output{Kind: "ConfigMap", Name: "overlays"}
+		_, err := validator.ValidateCreate(context.TODO(), yttSource)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("spec.sources[0]"))
+	})
+
+	It("rejects sources in other namespaces when cross-namespace references are not allowed", func() {
+		validator.AllowCrossNamespaceReferences = false
+		yttSource := getYttSource()
+		yttSource.Spec.Namespace = yttSource.Namespace
+		yttSource.Spec.Sources = []extensionv1beta1.SourceReference{
+			{Kind: "ConfigMap", Name: "overlays"},
+			{Kind: "ConfigMap", Namespace: "other", Name: "lib"},
+		}
+		_, err := validator.ValidateCreate(context.TODO(), yttSource)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("spec.sources[1].namespace"))
+		Expect(err.Error()).ToNot(ContainSubstring("spec.sources[0].namespace"))
+	})
+
+	It("rejects libraryName on sources which are not libraries", func() {
+		yttSource := getYttSource()
+		yttSource.Spec.Sources = []extensionv1beta1.SourceReference{
+			{Kind: "ConfigMap", Name: "helpers", Type: extensionv1beta1.SourceTypeLibrary, LibraryName: "helpers"},
+			{Kind: "ConfigMap", Name: "overlays", Type: extensionv1beta1.SourceTypeOverlay, LibraryName: "overlays"},
+			{Kind: "ConfigMap", Name: "other", Type: extensionv1beta1.SourceTypeLibrary, LibraryName: "../other"},
+		}
+		_, err := validator.ValidateCreate(context.TODO(), yttSource)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+		Expect(err.Error()).ToNot(ContainSubstring("spec.sources[0]"))
+		Expect(err.Error()).To(ContainSubstring("spec.sources[1].libraryName"))
+		Expect(err.Error()).To(ContainSubstring("spec.sources[2].libraryName"))
+	})
+
+	It("accepts a YttSource containing only inline files", func() {
+		yttSource := getYttSource()
+		yttSource.Spec = extensionv1beta1.YttSourceSpec{
+			Files: map[string]string{"config/deployment.yaml": "---\nkind: Deployment\n"},
+		}
+		_, err := validator.ValidateCreate(context.TODO(), yttSource)
+		Expect(err).To(BeNil())
+	})
+
+	It("rejects invalid inline file names", func() {
+		yttSource := getYttSource()
+		yttSource.Spec.Files = map[string]string{
+			"/etc/deployment.yaml": "",
+			"../deployment.yaml":   "",
+			"./":                   "",
+		}
+		_, err := validator.ValidateCreate(context.TODO(), yttSource)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("spec.files[/etc/deployment.yaml]"))
+		Expect(err.Error()).To(ContainSubstring("spec.files[../deployment.yaml]"))
+		Expect(err.Error()).To(ContainSubstring("spec.files[./]"))
+	})
+
+	It("rejects inline file names which are the same file", func() {
+		yttSource := getYttSource()
+		yttSource.Spec.Files = map[string]string{
+			"deployment.yaml":   "",
+			"./deployment.yaml": "",
+		}
+		_, err := validator.ValidateCreate(context.TODO(), yttSource)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("spec.files[deployment.yaml]: Duplicate value"))
+	})
+
+	It("rejects invalid data layouts", func() {
+		yttSource := getYttSource()
+		yttSource.Spec.DataLayout = &extensionv1beta1.DataLayout{Mode: extensionv1beta1.DataLayoutFiles}
+		yttSource.Spec.Sources = []extensionv1beta1.SourceReference{
+			{
+				Kind: "ConfigMap", Name: "archives",
+				DataLayout: &extensionv1beta1.DataLayout{ArchiveKeys: []string{"ytt.zip", "ytt.rar"}},
+			},
+			{
+				Kind: "Secret", Name: "files",
+				DataLayout: &extensionv1beta1.DataLayout{Mode: extensionv1beta1.DataLayoutFiles, PathSeparator: "/"},
+			},
+		}
+		_, err := validator.ValidateCreate(context.TODO(), yttSource)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("spec.dataLayout: Forbidden"))
+		Expect(err.Error()).ToNot(ContainSubstring("spec.sources[0].dataLayout.archiveKeys[0]"))
+		Expect(err.Error()).To(ContainSubstring("spec.sources[0].dataLayout.archiveKeys[1]"))
+		Expect(err.Error()).To(ContainSubstring("spec.sources[1].dataLayout.pathSeparator"))
+	})
+
+	It("rejects invalid decryption settings", func() {
+		yttSource := getYttSource()
+		yttSource.Spec.Decryption = &extensionv1beta1.Decryption{
+			Suffixes: []string{".sops.yaml", ""},
+			Files:    []string{"values/secrets.yaml", "../secrets.yaml"},
+		}
+		_, err := validator.ValidateCreate(context.TODO(), yttSource)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("spec.decryption.secretRef.name"))
+		Expect(err.Error()).ToNot(ContainSubstring("spec.decryption.suffixes[0]"))
+		Expect(err.Error()).To(ContainSubstring("spec.decryption.suffixes[1]"))
+		Expect(err.Error()).ToNot(ContainSubstring("spec.decryption.files[0]"))
+		Expect(err.Error()).To(ContainSubstring("spec.decryption.files[1]"))
+	})
+
+	It("rejects a decryption Secret in another namespace when cross-namespace references are not allowed", func() {
+		yttSource := getYttSource()
+		yttSource.Spec.Namespace = yttSource.Namespace
+		yttSource.Spec.Decryption = &extensionv1beta1.Decryption{
+			SecretRef: corev1.SecretReference{Namespace: "other", Name: "sops-keys"},
+		}
+
+		validator.AllowCrossNamespaceReferences = false
+		_, err := validator.ValidateCreate(context.TODO(), yttSource)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("spec.decryption.secretRef.namespace"))
+
+		yttSource.Spec.Decryption.SecretRef.Namespace = ""
+		_, err = validator.ValidateCreate(context.TODO(), yttSource)
+		Expect(err).To(BeNil())
+	})
+
+	It("accepts a URL source without namespace", func() {
+		yttSource := getYttSource()
+		yttSource.Spec.Kind = extensionv1beta1.URLSourceKind
+		yttSource.Spec.Namespace = ""
+		yttSource.Spec.URL = &extensionv1beta1.URLSource{
+			Address:          "https://example.com/ytt.tar.gz",
+			HeadersSecretRef: &corev1.SecretReference{Name: "headers"},
+		}
+		_, err := validator.ValidateCreate(context.TODO(), yttSource)
+		Expect(err).To(BeNil())
+	})
+
+	It("rejects invalid URL sources", func() {
+		yttSource := getYttSource()
+		yttSource.Spec.URL = &extensionv1beta1.URLSource{Address: "https://example.com/ytt.tar.gz"}
+		yttSource.Spec.Sources = []extensionv1beta1.SourceReference{
+			{Kind: extensionv1beta1.URLSourceKind, Name: "missing"},
+			{
+				Kind: extensionv1beta1.URLSourceKind, Name: "invalid",
+				URL: &extensionv1beta1.URLSource{
+					Address:     "ftp://example.com/ytt.tar.gz",
+					CASecretRef: &corev1.SecretReference{},
+					Interval:    &metav1.Duration{},
+				},
+			},
+		}
+		_, err := validator.ValidateCreate(context.TODO(), yttSource)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("spec.url: Forbidden"))
+		Expect(err.Error()).To(ContainSubstring("spec.sources[0].url: Required"))
+		Expect(err.Error()).To(ContainSubstring("spec.sources[1].url.address"))
+		Expect(err.Error()).To(ContainSubstring("spec.sources[1].url.caSecretRef.name"))
+		Expect(err.Error()).To(ContainSubstring("spec.sources[1].url.interval"))
+	})
+
+	It("rejects URL source Secrets in another namespace even when cross-namespace references are allowed", func() {
+		yttSource := getYttSource()
+		yttSource.Spec.Kind = extensionv1beta1.URLSourceKind
+		yttSource.Spec.Namespace = ""
+		yttSource.Spec.URL = &extensionv1beta1.URLSource{
+			Address:          "https://example.com/ytt.tar.gz",
+			HeadersSecretRef: &corev1.SecretReference{Namespace: "other", Name: "headers"},
+			CASecretRef:      &corev1.SecretReference{Namespace: "other", Name: "ca"},
+		}
+
+		_, err := validator.ValidateCreate(context.TODO(), yttSource)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("spec.url.headersSecretRef.namespace"))
+		Expect(err.Error()).To(ContainSubstring("spec.url.caSecretRef.namespace"))
+	})
+
+	It("accepts an OCI source without namespace", func() {
+		yttSource := getYttSource()
+		yttSource.Spec.Kind = extensionv1beta1.OCISourceKind
+		yttSource.Spec.Namespace = ""
+		yttSource.Spec.OCI = &extensionv1beta1.OCISource{
+			Reference: "ghcr.io/org/ytt:v1",
+			SecretRef: &corev1.SecretReference{Name: "credentials"},
+			Verify:    &extensionv1beta1.OCIVerification{SecretRef: corev1.SecretReference{Name: "cosign"}},
+		}
+		_, err := validator.ValidateCreate(context.TODO(), yttSource)
+		Expect(err).To(BeNil())
+	})
+
+	It("rejects invalid OCI sources", func() {
+		yttSource := getYttSource()
+		yttSource.Spec.OCI = &extensionv1beta1.OCISource{Reference: "ghcr.io/org/ytt:v1"}
+		yttSource.Spec.Sources = []extensionv1beta1.SourceReference{
+			{Kind: extensionv1beta1.OCISourceKind, Name: "missing"},
+			{
+				Kind: extensionv1beta1.OCISourceKind, Name: "invalid",
+				OCI: &extensionv1beta1.OCISource{
+					SecretRef: &corev1.SecretReference{},
+					Verify:    &extensionv1beta1.OCIVerification{},
+					Interval:  &metav1.Duration{},
+				},
+			},
+		}
+		_, err := validator.ValidateCreate(context.TODO(), yttSource)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("spec.oci: Forbidden"))
+		Expect(err.Error()).To(ContainSubstring("spec.sources[0].oci: Required"))
+		Expect(err.Error()).To(ContainSubstring("spec.sources[1].oci.reference"))
+		Expect(err.Error()).To(ContainSubstring("spec.sources[1].oci.secretRef.name"))
+		Expect(err.Error()).To(ContainSubstring("spec.sources[1].oci.verify.secretRef.name"))
+		Expect(err.Error()).To(ContainSubstring("spec.sources[1].oci.interval"))
+	})
+
+	It("rejects OCI source Secrets in another namespace even when cross-namespace references are allowed", func() {
+		yttSource := getYttSource()
+		yttSource.Spec.Kind = extensionv1beta1.OCISourceKind
+		yttSource.Spec.Namespace = ""
+		yttSource.Spec.OCI = &extensionv1beta1.OCISource{
+			Reference: "ghcr.io/org/ytt:v1",
+			SecretRef: &corev1.SecretReference{Namespace: "other", Name: "credentials"},
+			Verify: &extensionv1beta1.OCIVerification{
+				SecretRef: corev1.SecretReference{Namespace: "other", Name: "cosign"},
+			},
+		}
+
+		_, err := validator.ValidateCreate(context.TODO(), yttSource)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("spec.oci.secretRef.namespace"))
+		Expect(err.Error()).To(ContainSubstring("spec.oci.verify.secretRef.namespace"))
+	})
+
+	It("rejects a non positive timeout", func() {
+		yttSource := getYttSource()
+		yttSource.Spec.Timeout = &metav1.Duration{}
+		_, err := validator.ValidateCreate(context.TODO(), yttSource)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("spec.timeout"))
+
+		yttSource.Spec.Timeout = &metav1.Duration{Duration: time.Minute}
+		_, err = validator.ValidateCreate(context.TODO(), yttSource)
+		Expect(err).To(BeNil())
+	})
+
+	It("warns when Secrets are redacted but output is missing or a ConfigMap", func() {
+		yttSource := getYttSource()
+		yttSource.Spec.SecretRedaction = extensionv1beta1.SecretRedactionRedact
+		warnings, err := validator.ValidateCreate(context.TODO(), yttSource)
+		Expect(err).To(BeNil())
+		Expect(warnings).To(HaveLen(1))
+		Expect(warnings[0]).To(ContainSubstring("spec.output is not"))
+
+		yttSource.Spec.Output = &extensionv1beta1.Output{Kind: "ConfigMap", Name: "output"}
+		warnings, err = validator.ValidateCreate(context.TODO(), yttSource)
+		Expect(err).To(BeNil())
+		Expect(warnings).To(HaveLen(1))
+		Expect(warnings[0]).To(ContainSubstring("ConfigMap"))
+
+		yttSource.Spec.Output.Kind = "Secret"
+		warnings, err = validator.ValidateCreate(context.TODO(), yttSource)
+		Expect(err).To(BeNil())
+		Expect(warnings).To(BeEmpty())
+	})
+})
@@ -21,6 +21,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 	"sync"
 	"syscall"
 	"time"
@@ -48,8 +49,10 @@ import (
 
 	//+kubebuilder:scaffold:imports
 
+	extensionv1beta1 "github.com/gianlucam76/ytt-controller/api/v1beta1"
 	"github.com/gianlucam76/ytt-controller/controllers"
 
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
 	"github.com/projectsveltos/libsveltos/lib/crd"
 	"github.com/projectsveltos/libsveltos/lib/logsettings"
 	libsveltosset "github.com/projectsveltos/libsveltos/lib/set"
@@ -60,16 +63,29 @@ var (
 	metricsAddr          string
 	probeAddr            string
 	workers              int
+	downloadWorkers      int
 	concurrentReconciles int
 	restConfigQPS        float32
 	restConfigBurst      int
 	webhookPort          int
 	syncPeriod           time.Duration
+	artifactRequeue      time.Duration
+	artifactRequeueMax   time.Duration
+	artifactCacheDir     string
+	artifactCacheSizeMB  int
+	maxRenderTimeout     time.Duration
+	maxOutputSizeKB      int
+	maxDownloadSizeMB    int
+	enableWebhooks       bool
+	allowCrossNamespace  bool
+	allowCrossNsOutput   bool
+	allowedSecretTypes   []string
 )
 
 const (
-	defaultReconcilers = 10
-	defaultWorkers     = 20
+	defaultReconcilers     = 10
+	defaultWorkers         = 20
+	defaultDownloadWorkers = 10
 )
 
 func main() {
@@ -87,6 +103,14 @@ func main() {
 
 	ctrl.SetLogger(klog.Background())
 
+	// Abandoned ytt evaluations release their slot, so only a bounded pool keeps
+	// the CPUs used by evaluations bounded
+	if workers <= 0 {
+		setupLog.Error(fmt.Errorf("--worker-number must be positive, got %d", workers),
+			"invalid evaluation workers")
+		os.Exit(1)
+	}
+
 	ctrlOptions := ctrl.Options{
 		Scheme:                 scheme,
 		HealthProbeBindAddress: probeAddr,
@@ -115,20 +139,51 @@ func main() {
 	// Setup the context that's going to be used in controllers and for the manager.
 	ctx := ctrl.SetupSignalHandler()
 
+	var artifactCache *controllers.ArtifactCache
+	if artifactCacheSizeMB > 0 {
+		artifactCache, err = controllers.NewArtifactCache(artifactCacheDir, int64(artifactCacheSizeMB)*1024*1024)
+		if err != nil {
+			setupLog.Error(err, "unable to create artifact cache")
+			os.Exit(1)
+		}
+	}
+
 	var yttController controller.Controller
 	yttReconciler := (&controllers.YttSourceReconciler{
-		Client:               mgr.GetClient(),
-		Scheme:               mgr.GetScheme(),
-		ReferenceMap:         make(map[corev1.ObjectReference]*libsveltosset.Set),
-		YttSourceMap:         make(map[types.NamespacedName]*libsveltosset.Set),
-		PolicyMux:            sync.Mutex{},
-		ConcurrentReconciles: concurrentReconciles,
+		Client:                        mgr.GetClient(),
+		Scheme:                        mgr.GetScheme(),
+		ReferenceMap:                  make(map[corev1.ObjectReference]*libsveltosset.Set),
+		YttSourceMap:                  make(map[types.NamespacedName]*libsveltosset.Set),
+		PolicyMux:                     sync.Mutex{},
+		ConcurrentReconciles:          concurrentReconciles,
+		ArtifactRequeueInterval:       artifactRequeue,
+		ArtifactRequeueMaxInterval:    artifactRequeueMax,
+		ArtifactCache:                 artifactCache,
+		EventRecorder:                 mgr.GetEventRecorderFor("ytt-controller"),
+		AllowedSecretTypes:            getAllowedSecretTypes(),
+		EvaluationWorkers:             workers,
+		DownloadWorkers:               downloadWorkers,
+		MaxRenderTimeout:              maxRenderTimeout,
+		MaxOutputSize:                 int64(maxOutputSizeKB) * 1024,
+		MaxDownloadSize:               int64(maxDownloadSizeMB) * 1024 * 1024,
+		AllowCrossNamespaceOutput:     allowCrossNsOutput,
+		AllowCrossNamespaceReferences: allowCrossNamespace,
 	})
 	yttController, err = yttReconciler.SetupWithManager(mgr)
 	if err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "YttSource")
 		os.Exit(1)
 	}
+	if enableWebhooks {
+		validator := &extensionv1beta1.YttSourceValidator{
+			AllowCrossNamespaceReferences: allowCrossNamespace,
+			AllowCrossNamespaceOutput:     allowCrossNsOutput,
+		}
+		if err = validator.SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "YttSource")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -166,7 +221,13 @@ func initFlags(fs *pflag.FlagSet) {
 		&workers,
 		"worker-number",
 		defaultWorkers,
-		"Number of worker. Workers are used to deploy features in CAPI clusters")
+		"Maximum number of ytt evaluations running concurrently across all YttSources. Must be positive. Defaults to 20")
+
+	fs.IntVar(
+		&downloadWorkers,
+		"download-workers",
+		defaultDownloadWorkers,
+		"Maximum number of Flux artifacts, URL tarballs and OCI artifacts downloaded concurrently across all YttSources. Zero means no limit. Defaults to 10")
 
 	fs.IntVar(
 		&concurrentReconciles,
@@ -192,6 +253,60 @@ func initFlags(fs *pflag.FlagSet) {
 	fs.DurationVar(&syncPeriod, "sync-period", defaultSyncPeriod*time.Minute,
 		fmt.Sprintf("The minimum interval at which watched resources are reconciled (e.g. 15m). Default: %d minutes",
 			defaultSyncPeriod))
+
+	const defaultArtifactRequeue = 10
+	fs.DurationVar(&artifactRequeue, "artifact-requeue-interval", defaultArtifactRequeue*time.Second,
+		fmt.Sprintf("The initial interval after which a YttSource is requeued when its Flux source has no artifact yet. Default: %d seconds",
+			defaultArtifactRequeue))
+
+	const defaultArtifactRequeueMax = 5
+	fs.DurationVar(&artifactRequeueMax, "artifact-requeue-max-interval", defaultArtifactRequeueMax*time.Minute,
+		fmt.Sprintf("The maximum interval after which a YttSource is requeued when its Flux source has no artifact yet. Default: %d minutes",
+			defaultArtifactRequeueMax))
+
+	fs.StringVar(&artifactCacheDir, "artifact-cache-dir", filepath.Join(os.TempDir(), "ytt-artifacts"),
+		"The directory where Flux artifacts, shared across YttSources, are cached")
+
+	const defaultArtifactCacheSizeMB = 512
+	fs.IntVar(&artifactCacheSizeMB, "artifact-cache-size", defaultArtifactCacheSizeMB,
+		fmt.Sprintf("The maximum size, in MiB, of the artifact cache. Artifacts in use are never evicted. 0 disables the cache. Default: %d",
+			defaultArtifactCacheSizeMB))
+
+	const defaultMaxRenderTimeout = 30
+	fs.DurationVar(&maxRenderTimeout, "max-render-timeout", defaultMaxRenderTimeout*time.Second,
+		fmt.Sprintf("The maximum time ytt can spend evaluating the templates of a YttSource. Caps, and defaults, YttSource spec.timeout. Default: %d seconds",
+			defaultMaxRenderTimeout))
+
+	const defaultMaxOutputSizeKB = 1024
+	fs.IntVar(&maxOutputSizeKB, "max-output-size", defaultMaxOutputSizeKB,
+		fmt.Sprintf("The maximum size, in KiB, of the output rendered for a YttSource. 0 means no limit. Default: %d",
+			defaultMaxOutputSizeKB))
+
+	const defaultMaxDownloadSizeMB = 100
+	fs.IntVar(&maxDownloadSizeMB, "max-download-size", defaultMaxDownloadSizeMB,
+		fmt.Sprintf("The maximum size, in MiB, of Flux artifacts and URL tarballs, both downloaded and extracted. 0 means no limit. Default: %d",
+			defaultMaxDownloadSizeMB))
+
+	fs.BoolVar(&enableWebhooks, "enable-webhooks", true,
+		"Serve the YttSource validating and conversion webhooks. Requires serving certificates. Default: true")
+
+	fs.BoolVar(&allowCrossNamespace, "allow-cross-namespace-references", true,
+		"Allow YttSources to reference sources, data values and decryption keys in other namespaces. Default: true")
+
+	fs.BoolVar(&allowCrossNsOutput, "allow-cross-namespace-output", false,
+		"Allow YttSources to write their output to ConfigMaps/Secrets in other namespaces. Default: false")
+
+	fs.StringSliceVar(&allowedSecretTypes, "allowed-secret-types",
+		[]string{string(libsveltosv1beta1.ClusterProfileSecretType)},
+		"Types of the Secrets YttSources can read ytt files and data values from, unless YttSource sets its own allowedSecretTypes")
+}
+
+func getAllowedSecretTypes() []corev1.SecretType {
+	secretTypes := make([]corev1.SecretType, len(allowedSecretTypes))
+	for i := range allowedSecretTypes {
+		secretTypes[i] = corev1.SecretType(allowedSecretTypes[i])
+	}
+	return secretTypes
 }
 
 // fluxCRDHandler restarts process if a Flux CRD is updated
@@ -201,12 +316,35 @@ func fluxCRDHandler(gvk *schema.GroupVersionKind, action crd.ChangeType) {
 	}
 
 	if gvk.Group == sourcev1.GroupVersion.Group {
-		setupLog.V(logsettings.LogInfo).Info("Initiating graceful restart due to Flux CRD update",
-			"GVK", gvk.String(), "Action", string(action))
+		restart(gvk, action)
+	}
+}
 
-		if killErr := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); killErr != nil {
-			panic("kill -TERM failed")
+// fluxSourceCRDHandler returns a handler restarting process if the versions served
+// for Flux sources change, as watchers on Flux sources cannot be replaced
+func fluxSourceCRDHandler(ctx context.Context, c client.Reader) func(*schema.GroupVersionKind, crd.ChangeType) {
+	return func(gvk *schema.GroupVersionKind, action crd.ChangeType) {
+		if !controllers.IsFluxSourceKind(gvk) {
+			return
+		}
+
+		changed, err := controllers.FluxSourceVersionsChanged(ctx, c)
+		if err != nil {
+			setupLog.V(logsettings.LogInfo).Info(fmt.Sprintf("failed to verify Flux source versions: %v", err))
+			return
 		}
+		if changed {
+			restart(gvk, action)
+		}
+	}
+}
+
+func restart(gvk *schema.GroupVersionKind, action crd.ChangeType) {
+	setupLog.V(logsettings.LogInfo).Info("Initiating graceful restart due to Flux CRD update",
+		"GVK", gvk.String(), "Action", string(action))
+
+	if killErr := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); killErr != nil {
+		panic("kill -TERM failed")
 	}
 }
 
@@ -226,6 +364,28 @@ func isFluxInstalled(ctx context.Context, c client.Client) (bool, error) {
 	return true, nil
 }
 
+// waitBeforeRetry waits before the given retry, doubling the wait at each retry up
+// to a minute. Returns false if ctx is done in the meantime.
+func waitBeforeRetry(ctx context.Context, retries int) bool {
+	const (
+		initialInterval = time.Second
+		maxInterval     = time.Minute
+	)
+
+	interval := initialInterval
+	for i := 1; i < retries && interval < maxInterval; i++ {
+		interval *= 2
+	}
+	interval = min(interval, maxInterval)
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(interval):
+		return true
+	}
+}
+
 func fluxWatchers(ctx context.Context, mgr ctrl.Manager,
 	yttSourceReconciler *controllers.YttSourceReconciler, yttSourceController controller.Controller,
 	logger logr.Logger) {
@@ -237,19 +397,29 @@ func fluxWatchers(ctx context.Context, mgr ctrl.Manager,
 		if err != nil {
 			if retries < maxRetries {
 				logger.Info(fmt.Sprintf("failed to verify if Flux is present: %v", err))
-				time.Sleep(time.Second)
 			}
 			retries++
+			if !waitBeforeRetry(ctx, retries) {
+				return
+			}
 		} else {
 			if !fluxPresent {
 				setupLog.V(logsettings.LogInfo).Info("Flux currently not present. Starting CRD watcher")
 				go crd.WatchCustomResourceDefinition(ctx, mgr.GetConfig(), fluxCRDHandler, setupLog)
 			} else {
 				setupLog.V(logsettings.LogInfo).Info("Flux present.")
-				err = yttSourceReconciler.WatchForFlux(mgr, yttSourceController)
+				err = yttSourceReconciler.WatchForFlux(ctx, mgr, yttSourceController)
 				if err != nil {
+					logger.Error(err, "failed to watch Flux sources")
+					retries++
+					if !waitBeforeRetry(ctx, retries) {
+						return
+					}
 					continue
 				}
+				// Restart if Flux is upgraded and serves different versions of its sources
+				go crd.WatchCustomResourceDefinition(ctx, mgr.GetConfig(),
+					fluxSourceCRDHandler(ctx, mgr.GetAPIReader()), setupLog)
 			}
 			return
 		}
@@ -0,0 +1,240 @@
+/*
+Copyright 2024. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fluxcd/pkg/tar"
+)
+
+// ArtifactCache keeps extracted artifacts on disk, keyed by source kind and artifact
+// digest (see getArtifactCacheKey), so that YttSources referencing the same source
+// revision download and extract it only once.
+// Entries in use are reference counted and never evicted. Entries not in use are
+// evicted, least recently used first, when total size exceeds maxSize.
+// Directories returned by the cache are shared and must not be modified.
+type ArtifactCache struct {
+	mu      sync.Mutex
+	dir     string
+	maxSize int64
+	size    int64
+	entries map[string]*cacheEntry
+	lru     *list.List // front is most recently used. Values are digests
+}
+
+type cacheEntry struct {
+	dir  string
+	size int64
+	refs int
+	elem *list.Element
+
+	// ready is closed once the artifact has been fetched. err is set if fetching failed.
+	ready chan struct{}
+	err   error
+}
+
+// getArtifactCacheKey returns the key, in the ArtifactCache, of the artifact with given
+// digest fetched from a source of given kind. Keys are prefixed by source kind, so artifacts
+// of different kinds of sources never share an entry. When set, credentials identifies the
+// credentials the artifact was fetched with, so that it is never served to callers which
+// could not fetch it.
+func getArtifactCacheKey(kind, credentials, digest string) string {
+	if credentials == "" {
+		return fmt.Sprintf("%s-%s", strings.ToLower(kind), digest)
+	}
+	return fmt.Sprintf("%s-%s-%s", strings.ToLower(kind), credentials, digest)
+}
+
+// getMaxUntarSize returns the maximum size of the extracted content of an artifact
+// not larger than maxSize. Zero maxSize means no limit.
+func getMaxUntarSize(maxSize int64) int {
+	if maxSize <= 0 {
+		return tar.UnlimitedUntarSize
+	}
+	return int(maxSize)
+}
+
+// NewArtifactCache returns an ArtifactCache storing artifacts in dir.
+// Any content already present in dir is removed.
+func NewArtifactCache(dir string, maxSize int64) (*ArtifactCache, error) {
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, permission0755); err != nil {
+		return nil, err
+	}
+
+	return &ArtifactCache{
+		dir:     dir,
+		maxSize: maxSize,
+		entries: make(map[string]*cacheEntry),
+		lru:     list.New(),
+	}, nil
+}
+
+// Acquire returns the directory containing the artifact with given digest. If the artifact
+// is not cached yet, fetch is invoked to populate the directory. Concurrent calls for the
+// same digest wait for a single fetch.
+// Caller must invoke release once done with the directory.
+func (c *ArtifactCache) Acquire(digest string, fetch func(dir string) error) (dir string, release func(), err error) {
+	c.mu.Lock()
+	entry, ok := c.entries[digest]
+	if ok {
+		entry.refs++
+		c.lru.MoveToFront(entry.elem)
+		c.mu.Unlock()
+
+		<-entry.ready
+		if entry.err != nil {
+			c.release(digest, entry)
+			return "", nil, entry.err
+		}
+		artifactCacheHits.Inc()
+		return entry.dir, func() { c.release(digest, entry) }, nil
+	}
+
+	artifactCacheMisses.Inc()
+	entry = &cacheEntry{
+		dir:   filepath.Join(c.dir, strings.ReplaceAll(digest, ":", "-")),
+		refs:  1,
+		ready: make(chan struct{}),
+	}
+	entry.elem = c.lru.PushFront(digest)
+	c.entries[digest] = entry
+	c.mu.Unlock()
+
+	entry.err = c.populate(entry, fetch)
+
+	c.mu.Lock()
+	if entry.err != nil {
+		// Do not cache failures. Next Acquire fetches again.
+		c.remove(digest, entry)
+	} else {
+		c.size += entry.size
+		c.evict()
+	}
+	c.mu.Unlock()
+	close(entry.ready)
+
+	if entry.err != nil {
+		c.release(digest, entry)
+		return "", nil, entry.err
+	}
+
+	return entry.dir, func() { c.release(digest, entry) }, nil
+}
+
+func (c *ArtifactCache) populate(entry *cacheEntry, fetch func(dir string) error) error {
+	if err := os.RemoveAll(entry.dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(entry.dir, permission0755); err != nil {
+		return err
+	}
+	if err := fetch(entry.dir); err != nil {
+		_ = os.RemoveAll(entry.dir)
+		return err
+	}
+
+	size, err := getDirSize(entry.dir)
+	if err != nil {
+		_ = os.RemoveAll(entry.dir)
+		return err
+	}
+	entry.size = size
+	return nil
+}
+
+func (c *ArtifactCache) release(digest string, entry *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry.refs--
+	c.evict()
+}
+
+// remove deletes entry from the index. Must be called with lock held.
+func (c *ArtifactCache) remove(digest string, entry *cacheEntry) {
+	if current, ok := c.entries[digest]; ok && current == entry {
+		delete(c.entries, digest)
+		c.lru.Remove(entry.elem)
+	}
+}
+
+// evict removes least recently used entries not in use till total size is
+// within bound. Must be called with lock held.
+func (c *ArtifactCache) evict() {
+	for elem := c.lru.Back(); elem != nil && c.size > c.maxSize; {
+		prev := elem.Prev()
+		digest := elem.Value.(string)
+		entry := c.entries[digest]
+		if entry.refs == 0 && isClosed(entry.ready) {
+			c.remove(digest, entry)
+			c.size -= entry.size
+			_ = os.RemoveAll(entry.dir)
+			artifactCacheEvictions.Inc()
+		}
+		elem = prev
+	}
+}
+
+// Size returns the total size, in bytes, of cached artifacts.
+func (c *ArtifactCache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// Len returns the number of cached artifacts.
+func (c *ArtifactCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// getDirSize returns the total size of regular files in dir
+func getDirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to compute size of %s: %w", dir, err)
+	}
+	return size, nil
+}
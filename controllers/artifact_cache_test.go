@@ -0,0 +1,160 @@
+/*
+Copyright 2024. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/gianlucam76/ytt-controller/controllers"
+)
+
+var _ = Describe("ArtifactCache", func() {
+	var cacheDir string
+
+	BeforeEach(func() {
+		var err error
+		cacheDir, err = os.MkdirTemp("", "artifact-cache")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(cacheDir)
+	})
+
+	// writeFile returns a fetch function writing a file of given size
+	writeFile := func(size int, fetches *int) func(dir string) error {
+		return func(dir string) error {
+			*fetches++
+			return os.WriteFile(filepath.Join(dir, "file.yaml"), make([]byte, size), 0600)
+		}
+	}
+
+	It("Acquire fetches an artifact only once", func() {
+		cache, err := controllers.NewArtifactCache(cacheDir, 1024)
+		Expect(err).To(BeNil())
+
+		fetches := 0
+		digest := "sha256:" + randomString()
+		dir, release, err := cache.Acquire(digest, writeFile(10, &fetches))
+		Expect(err).To(BeNil())
+		Expect(filepath.Join(dir, "file.yaml")).To(BeARegularFile())
+		release()
+
+		otherDir, release, err := cache.Acquire(digest, writeFile(10, &fetches))
+		Expect(err).To(BeNil())
+		Expect(otherDir).To(Equal(dir))
+		release()
+
+		Expect(fetches).To(Equal(1))
+		Expect(cache.Len()).To(Equal(1))
+		Expect(cache.Size()).To(Equal(int64(10)))
+	})
+
+	It("Acquire shares a single fetch between concurrent callers", func() {
+		cache, err := controllers.NewArtifactCache(cacheDir, 1024)
+		Expect(err).To(BeNil())
+
+		var mu sync.Mutex
+		fetches := 0
+		digest := "sha256:" + randomString()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				_, release, err := cache.Acquire(digest, func(dir string) error {
+					mu.Lock()
+					fetches++
+					mu.Unlock()
+					return os.WriteFile(filepath.Join(dir, "file.yaml"), []byte("a: b"), 0600)
+				})
+				Expect(err).To(BeNil())
+				release()
+			}()
+		}
+		wg.Wait()
+
+		Expect(fetches).To(Equal(1))
+	})
+
+	It("Acquire does not cache failures", func() {
+		cache, err := controllers.NewArtifactCache(cacheDir, 1024)
+		Expect(err).To(BeNil())
+
+		digest := "sha256:" + randomString()
+		_, _, err = cache.Acquire(digest, func(dir string) error {
+			return errors.New("download failed")
+		})
+		Expect(err).ToNot(BeNil())
+		Expect(cache.Len()).To(Equal(0))
+
+		fetches := 0
+		_, release, err := cache.Acquire(digest, writeFile(10, &fetches))
+		Expect(err).To(BeNil())
+		release()
+		Expect(fetches).To(Equal(1))
+	})
+
+	It("evicts least recently used artifacts not in use", func() {
+		cache, err := controllers.NewArtifactCache(cacheDir, 25)
+		Expect(err).To(BeNil())
+
+		fetches := 0
+		first := "sha256:" + randomString()
+		second := "sha256:" + randomString()
+		third := "sha256:" + randomString()
+
+		firstDir, releaseFirst, err := cache.Acquire(first, writeFile(10, &fetches))
+		Expect(err).To(BeNil())
+		_, releaseSecond, err := cache.Acquire(second, writeFile(10, &fetches))
+		Expect(err).To(BeNil())
+		releaseSecond()
+
+		// first is in use and more recently used than second. Adding third goes
+		// over the limit: second is evicted.
+		_, releaseThird, err := cache.Acquire(third, writeFile(10, &fetches))
+		Expect(err).To(BeNil())
+		Expect(cache.Len()).To(Equal(2))
+		Expect(cache.Size()).To(Equal(int64(20)))
+		Expect(firstDir).To(BeADirectory())
+
+		// first is still cached
+		_, release, err := cache.Acquire(first, writeFile(10, &fetches))
+		Expect(err).To(BeNil())
+		release()
+		Expect(fetches).To(Equal(3))
+
+		// second must be downloaded again. first is in use, third is not: third is evicted.
+		releaseThird()
+		_, release, err = cache.Acquire(second, writeFile(10, &fetches))
+		Expect(err).To(BeNil())
+		release()
+		Expect(fetches).To(Equal(4))
+		Expect(cache.Len()).To(Equal(2))
+
+		releaseFirst()
+		Expect(firstDir).To(BeADirectory())
+	})
+})
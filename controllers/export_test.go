@@ -16,6 +16,12 @@ limitations under the License.
 
 package controllers
 
+import (
+	"context"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
 var (
 	AddTypeInformationToObject = addTypeInformationToObject
 )
@@ -25,5 +31,76 @@ var (
 )
 
 var (
-	ExtractTarGz = extractTarGz
+	ExtractTarGz   = extractTarGz
+	ExtractArchive = extractArchive
+)
+
+var (
+	SetDataValues           = setDataValues
+	GetDataValuesReferences = getDataValuesReferences
+)
+
+var (
+	GetArtifactRequeueAfter = (*YttSourceReconciler).getArtifactRequeueAfter
+)
+
+var (
+	GetInputFingerprint = (*YttSourceReconciler).getInputFingerprint
 )
+
+var (
+	RenderSuccesses      = renderSuccesses
+	RenderFailures       = renderFailures
+	OutputBytes          = outputBytes
+	OutputObjects        = outputObjects
+	AbandonedEvaluations = abandonedEvaluations
+	YttSources           = yttSources
+)
+
+var (
+	NewWorkerPool = newWorkerPool
+	Acquire       = (*workerPool).acquire
+	InUse         = (*workerPool).inUse
+)
+
+var (
+	GetRenderTimeout = (*YttSourceReconciler).getRenderTimeout
+)
+
+// SetEvaluationPool bounds, as SetupWithManager does, the number of ytt evaluations
+// reconciler runs concurrently and returns the pool
+func SetEvaluationPool(reconciler *YttSourceReconciler, size int) *workerPool {
+	reconciler.evaluationPool = newWorkerPool(evaluationPoolName, size)
+	return reconciler.evaluationPool
+}
+
+var (
+	GetReferenceAPIVersion = getReferenceAPIVersion
+	GetReferenceKey        = getReferenceKey
+)
+
+var (
+	GetSource = getSource
+)
+
+// SelectFluxSourceVersions selects the versions Flux sources are fetched with, as
+// WatchForFlux does, and returns them by kind
+func SelectFluxSourceVersions(ctx context.Context, c client.Reader) (map[string]string, error) {
+	versions, err := discoverFluxSourceVersions(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	setFluxSourceVersions(versions)
+
+	result := make(map[string]string, len(versions))
+	for kind, v := range versions {
+		if v != nil {
+			result[kind] = v.version
+		}
+	}
+	return result, nil
+}
+
+func ResetFluxSourceVersions() {
+	setFluxSourceVersions(nil)
+}
@@ -0,0 +1,252 @@
+/*
+Copyright 2024. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	extensionv1beta1 "github.com/gianlucam76/ytt-controller/api/v1beta1"
+)
+
+const (
+	metricsNamespace = "ytt_controller"
+
+	// mixedSourceKind is the kind label of YttSources merging sources of different kinds
+	mixedSourceKind = "Mixed"
+	// Duration buckets go from 10ms to ~20s
+	durationBucketStart  = 0.01
+	durationBucketFactor = 2
+	durationBucketCount  = 12
+)
+
+var (
+	artifactCacheHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "artifact_cache_hits_total",
+			Help:      "Number of times a Flux artifact was found in the artifact cache",
+		},
+	)
+
+	artifactCacheMisses = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "artifact_cache_misses_total",
+			Help:      "Number of times a Flux artifact had to be downloaded",
+		},
+	)
+
+	artifactCacheEvictions = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "artifact_cache_evictions_total",
+			Help:      "Number of artifacts evicted from the artifact cache",
+		},
+	)
+
+	fetchDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "fetch_duration_seconds",
+			Help:      "Time spent fetching the content of the source referenced by a YttSource",
+			Buckets:   prometheus.ExponentialBuckets(durationBucketStart, durationBucketFactor, durationBucketCount),
+		},
+		[]string{"namespace", "name"},
+	)
+
+	evaluationDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "evaluation_duration_seconds",
+			Help:      "Time spent by ytt evaluating the templates of a YttSource",
+			Buckets:   prometheus.ExponentialBuckets(durationBucketStart, durationBucketFactor, durationBucketCount),
+		},
+		[]string{"namespace", "name"},
+	)
+
+	workerPoolWait = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "worker_pool_wait_seconds",
+			Help:      "Time spent waiting for a free slot in a worker pool, by pool (evaluation or download)",
+			Buckets:   prometheus.ExponentialBuckets(durationBucketStart, durationBucketFactor, durationBucketCount),
+		},
+		[]string{"pool"},
+	)
+
+	renderSuccesses = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "render_successes_total",
+			Help:      "Number of successful renderings, by source kind",
+		},
+		[]string{"kind"},
+	)
+
+	renderFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "render_failures_total",
+			Help:      "Number of failed renderings, by source kind and failure reason",
+		},
+		[]string{"kind", "reason"},
+	)
+
+	outputBytes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "output_bytes",
+			Help:      "Size, in bytes, of the last output rendered for a YttSource",
+		},
+		[]string{"namespace", "name"},
+	)
+
+	outputObjects = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "output_objects",
+			Help:      "Number of YAML documents in the last output rendered for a YttSource",
+		},
+		[]string{"namespace", "name"},
+	)
+
+	abandonedEvaluations = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "abandoned_evaluations",
+			Help:      "Number of ytt evaluations abandoned on timeout which are still running",
+		},
+	)
+
+	yttSources = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "yttsources",
+			Help:      "Number of YttSources, by status of the Ready condition",
+		},
+		[]string{"ready"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		artifactCacheHits,
+		artifactCacheMisses,
+		artifactCacheEvictions,
+		fetchDuration,
+		evaluationDuration,
+		workerPoolWait,
+		renderSuccesses,
+		renderFailures,
+		outputBytes,
+		outputObjects,
+		abandonedEvaluations,
+		yttSources,
+	)
+}
+
+// readyStates keeps the last known status of the Ready condition of each YttSource,
+// so that yttSources gauge can be updated without listing YttSources.
+var readyStates = struct {
+	mu     sync.Mutex
+	states map[types.NamespacedName]metav1.ConditionStatus
+}{
+	states: make(map[types.NamespacedName]metav1.ConditionStatus),
+}
+
+func observeFetchDuration(yttSource *extensionv1beta1.YttSource, start time.Time) {
+	fetchDuration.WithLabelValues(yttSource.Namespace, yttSource.Name).Observe(time.Since(start).Seconds())
+}
+
+func observeEvaluationDuration(yttSource *extensionv1beta1.YttSource, start time.Time) {
+	evaluationDuration.WithLabelValues(yttSource.Namespace, yttSource.Name).Observe(time.Since(start).Seconds())
+}
+
+func observeWorkerPoolWait(pool string, start time.Time) {
+	workerPoolWait.WithLabelValues(pool).Observe(time.Since(start).Seconds())
+}
+
+func recordRenderSuccess(yttSource *extensionv1beta1.YttSource, output []byte, objects int) {
+	renderSuccesses.WithLabelValues(getSourceKind(yttSource)).Inc()
+	outputBytes.WithLabelValues(yttSource.Namespace, yttSource.Name).Set(float64(len(output)))
+	outputObjects.WithLabelValues(yttSource.Namespace, yttSource.Name).Set(float64(objects))
+}
+
+func recordRenderFailure(yttSource *extensionv1beta1.YttSource, err error) {
+	renderFailures.WithLabelValues(getSourceKind(yttSource), getFailureReason(err)).Inc()
+}
+
+// getSourceKind returns the kind of the sources referenced by YttSource, or mixedSourceKind
+// when those are of different kinds. Inline files count as a source of kind inlineSourceKind.
+func getSourceKind(yttSource *extensionv1beta1.YttSource) string {
+	kind := ""
+	if len(yttSource.Spec.Files) != 0 {
+		kind = inlineSourceKind
+	}
+	sources := getSources(yttSource)
+	for i := range sources {
+		if kind != "" && kind != sources[i].Kind {
+			return mixedSourceKind
+		}
+		kind = sources[i].Kind
+	}
+	return kind
+}
+
+// trackReadyState updates yttSources gauge with the current status of YttSource
+// Ready condition.
+func trackReadyState(yttSource *extensionv1beta1.YttSource) {
+	status := metav1.ConditionUnknown
+	if c := apimeta.FindStatusCondition(yttSource.Status.Conditions, extensionv1beta1.ReadyCondition); c != nil {
+		status = c.Status
+	}
+
+	readyStates.mu.Lock()
+	defer readyStates.mu.Unlock()
+
+	name := types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name}
+	if previous, ok := readyStates.states[name]; ok {
+		if previous == status {
+			return
+		}
+		yttSources.WithLabelValues(string(previous)).Dec()
+	}
+	readyStates.states[name] = status
+	yttSources.WithLabelValues(string(status)).Inc()
+}
+
+// forgetYttSource removes all metrics about a YttSource which does not exist anymore.
+func forgetYttSource(name types.NamespacedName) {
+	readyStates.mu.Lock()
+	if previous, ok := readyStates.states[name]; ok {
+		yttSources.WithLabelValues(string(previous)).Dec()
+		delete(readyStates.states, name)
+	}
+	readyStates.mu.Unlock()
+
+	fetchDuration.DeleteLabelValues(name.Namespace, name.Name)
+	evaluationDuration.DeleteLabelValues(name.Namespace, name.Name)
+	outputBytes.DeleteLabelValues(name.Namespace, name.Name)
+	outputObjects.DeleteLabelValues(name.Namespace, name.Name)
+}
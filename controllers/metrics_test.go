@@ -0,0 +1,94 @@
+/*
+Copyright 2024. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	extensionv1beta1 "github.com/gianlucam76/ytt-controller/api/v1beta1"
+	"github.com/gianlucam76/ytt-controller/controllers"
+
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+)
+
+var _ = Describe("Metrics", func() {
+	It("Reconcile records output size, object count and success", func() {
+		configMap := getYttConfigMap()
+		yttSource := getYttSourceForConfigMap(configMap, "./")
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource).
+			WithObjects(configMap, yttSource).Build()
+
+		kind := string(libsveltosv1beta1.ConfigMapReferencedResourceKind)
+		successes := testutil.ToFloat64(controllers.RenderSuccesses.WithLabelValues(kind))
+		ready := testutil.ToFloat64(controllers.YttSources.WithLabelValues(string(metav1.ConditionTrue)))
+
+		reconciler := getYttSourceReconciler(c)
+		req := reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+		}
+		_, err := reconciler.Reconcile(context.TODO(), req)
+		Expect(err).To(BeNil())
+
+		Expect(testutil.ToFloat64(controllers.RenderSuccesses.WithLabelValues(kind))).To(Equal(successes + 1))
+		Expect(testutil.ToFloat64(controllers.OutputBytes.WithLabelValues(yttSource.Namespace,
+			yttSource.Name))).To(BeNumerically(">", 0))
+		Expect(testutil.ToFloat64(controllers.OutputObjects.WithLabelValues(yttSource.Namespace,
+			yttSource.Name))).To(BeNumerically(">", 0))
+		Expect(testutil.ToFloat64(controllers.YttSources.WithLabelValues(string(metav1.ConditionTrue)))).
+			To(Equal(ready + 1))
+
+		// Once YttSource is gone, it is not counted anymore
+		currentYttSource := &extensionv1beta1.YttSource{}
+		Expect(c.Get(context.TODO(), req.NamespacedName, currentYttSource)).To(Succeed())
+		Expect(c.Delete(context.TODO(), currentYttSource)).To(Succeed())
+		_, err = reconciler.Reconcile(context.TODO(), req)
+		Expect(err).To(BeNil())
+		Expect(testutil.ToFloat64(controllers.YttSources.WithLabelValues(string(metav1.ConditionTrue)))).
+			To(Equal(ready))
+	})
+
+	It("Reconcile records failures by source kind and reason", func() {
+		configMap := getYttConfigMap()
+		yttSource := getYttSourceForConfigMap(configMap, randomString())
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource).
+			WithObjects(configMap, yttSource).Build()
+
+		kind := string(libsveltosv1beta1.ConfigMapReferencedResourceKind)
+		failures := testutil.ToFloat64(controllers.RenderFailures.WithLabelValues(kind,
+			extensionv1beta1.PathNotFoundReason))
+
+		reconciler := getYttSourceReconciler(c)
+		_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+		})
+		Expect(err).To(BeNil())
+
+		Expect(testutil.ToFloat64(controllers.RenderFailures.WithLabelValues(kind,
+			extensionv1beta1.PathNotFoundReason))).To(Equal(failures + 1))
+	})
+})
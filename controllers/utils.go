@@ -18,6 +18,8 @@ package controllers
 
 import (
 	archivetar "archive/tar"
+	"archive/zip"
+	"bytes"
 	"compress/gzip"
 	"context"
 	"errors"
@@ -25,6 +27,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	sourcev1 "github.com/fluxcd/source-controller/api/v1"
@@ -36,9 +39,8 @@ import (
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
+	extensionv1alpha1 "github.com/gianlucam76/ytt-controller/api/v1alpha1"
 	extensionv1beta1 "github.com/gianlucam76/ytt-controller/api/v1beta1"
-
-	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
 )
 
 //+kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,verbs=get;list;watch
@@ -63,6 +65,9 @@ func InitScheme() (*runtime.Scheme, error) {
 	if err := sourcev1b2.AddToScheme(s); err != nil {
 		return nil, err
 	}
+	if err := extensionv1alpha1.AddToScheme(s); err != nil {
+		return nil, err
+	}
 	if err := extensionv1beta1.AddToScheme(s); err != nil {
 		return nil, err
 	}
@@ -101,22 +106,6 @@ func addTypeInformationToObject(scheme *runtime.Scheme, obj client.Object) {
 	}
 }
 
-func getReferenceAPIVersion(yttSource *extensionv1beta1.YttSource) string {
-	switch yttSource.Spec.Kind {
-	case string(libsveltosv1beta1.ConfigMapReferencedResourceKind):
-		return corev1.SchemeGroupVersion.String()
-	case string(libsveltosv1beta1.SecretReferencedResourceKind):
-		return corev1.SchemeGroupVersion.String()
-	case sourcev1b2.OCIRepositoryKind:
-	case sourcev1b2.BucketKind:
-		return sourcev1b2.GroupVersion.String()
-	case sourcev1.GitRepositoryKind:
-		return sourcev1.GroupVersion.String()
-	}
-
-	return ""
-}
-
 // getConfigMap retrieves any ConfigMap from the given name and namespace.
 func getConfigMap(ctx context.Context, c client.Client, configmapName types.NamespacedName) (*corev1.ConfigMap, error) {
 	configMap := &corev1.ConfigMap{}
@@ -132,7 +121,10 @@ func getConfigMap(ctx context.Context, c client.Client, configmapName types.Name
 }
 
 // getSecret retrieves any Secret from the given secret name and namespace.
-func getSecret(ctx context.Context, c client.Client, secretName types.NamespacedName) (*corev1.Secret, error) {
+// An error is returned if the Secret type is not one of allowedTypes.
+func getSecret(ctx context.Context, c client.Client, secretName types.NamespacedName,
+	allowedTypes []corev1.SecretType) (*corev1.Secret, error) {
+
 	secret := &corev1.Secret{}
 	secretKey := types.NamespacedName{
 		Namespace: secretName.Namespace,
@@ -142,13 +134,22 @@ func getSecret(ctx context.Context, c client.Client, secretName types.Namespaced
 		return nil, err
 	}
 
-	if secret.Type != libsveltosv1beta1.ClusterProfileSecretType {
-		return nil, libsveltosv1beta1.ErrSecretTypeNotSupported
+	if !slices.Contains(allowedTypes, secret.Type) {
+		return nil, fmt.Errorf("%w: Secret %s/%s has type %q, allowed types are %s", errSecretTypeNotAllowed,
+			secret.Namespace, secret.Name, secret.Type, joinSecretTypes(allowedTypes))
 	}
 
 	return secret, nil
 }
 
+func joinSecretTypes(secretTypes []corev1.SecretType) string {
+	types := make([]string, len(secretTypes))
+	for i := range secretTypes {
+		types[i] = fmt.Sprintf("%q", secretTypes[i])
+	}
+	return strings.Join(types, ", ")
+}
+
 func extractTarGz(src, dest string) error {
 	// Open the tarball for reading
 	tarball, err := os.Open(src)
@@ -164,8 +165,33 @@ func extractTarGz(src, dest string) error {
 	}
 	defer gzipReader.Close()
 
+	return extractTar(gzipReader, dest)
+}
+
+// extractArchive extracts archive in dest. Archive format is derived from name suffix:
+// .tar, .tar.gz, .tgz or .zip.
+func extractArchive(name string, archive []byte, dest string) error {
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		gzipReader, err := gzip.NewReader(io.LimitReader(bytes.NewReader(archive), maxSize))
+		if err != nil {
+			return err
+		}
+		defer gzipReader.Close()
+		return extractTar(gzipReader, dest)
+	case strings.HasSuffix(name, ".tar"):
+		return extractTar(io.LimitReader(bytes.NewReader(archive), maxSize), dest)
+	case strings.HasSuffix(name, ".zip"):
+		return extractZip(archive, dest)
+	}
+
+	return fmt.Errorf("archive %s: unsupported format, expected .tar, .tar.gz, .tgz or .zip", name)
+}
+
+// extractTar extracts the tarball read from r in dest
+func extractTar(r io.Reader, dest string) error {
 	// Create a tar reader to read the uncompressed tarball
-	tarReader := archivetar.NewReader(gzipReader)
+	tarReader := archivetar.NewReader(r)
 
 	// Iterate over each file in the tarball and extract it to the destination
 	for {
@@ -178,7 +204,7 @@ func extractTarGz(src, dest string) error {
 		}
 
 		target := filepath.Join(dest, filepath.Clean(header.Name))
-		if !strings.HasPrefix(target, dest) {
+		if !isWithinDir(target, dest) {
 			return fmt.Errorf("tar archive entry %q is outside of destination directory", header.Name)
 		}
 
@@ -188,17 +214,83 @@ func extractTarGz(src, dest string) error {
 				return err
 			}
 		case archivetar.TypeReg:
-			//nolint: gosec // OK
-			file, err := os.OpenFile(target, os.O_CREATE|os.O_RDWR, os.FileMode(header.Mode))
-			if err != nil {
+			// Archives do not always contain entries for parent directories
+			if err := os.MkdirAll(filepath.Dir(target), permission0755); err != nil {
 				return err
 			}
-			if _, err := io.Copy(file, io.LimitReader(tarReader, maxSize)); err != nil {
+			if err := extractTarFile(tarReader, target, os.FileMode(header.Mode)); err != nil {
 				return err
 			}
-			file.Close()
 		}
 	}
 
 	return nil
 }
+
+// extractTarFile writes the current entry of tarReader to target. Several archives can be
+// extracted in the same directory, so an existing file is truncated.
+func extractTarFile(tarReader *archivetar.Reader, target string, mode os.FileMode) error {
+	//nolint: gosec // OK
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_RDWR|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, io.LimitReader(tarReader, maxSize))
+	return err
+}
+
+// isWithinDir returns true if path is dir or is contained in dir
+func isWithinDir(path, dir string) bool {
+	dir = filepath.Clean(dir)
+	return path == dir || strings.HasPrefix(path, dir+string(os.PathSeparator))
+}
+
+// extractZip extracts the zip archive in dest
+func extractZip(archive []byte, dest string) error {
+	zipReader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return err
+	}
+
+	for _, f := range zipReader.File {
+		target := filepath.Join(dest, filepath.Clean(f.Name))
+		if !isWithinDir(target, dest) {
+			return fmt.Errorf("zip archive entry %q is outside of destination directory", f.Name)
+		}
+
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, permission0755); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), permission0755); err != nil {
+			return err
+		}
+		if err := extractZipFile(f, target); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func extractZipFile(f *zip.File, target string) error {
+	src, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_RDWR|os.O_TRUNC, permission0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, io.LimitReader(src, maxSize))
+	return err
+}
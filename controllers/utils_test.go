@@ -17,13 +17,22 @@ limitations under the License.
 package controllers_test
 
 import (
+	"sync"
+
 	sourcev1 "github.com/fluxcd/source-controller/api/v1"
 	sourcev1b2 "github.com/fluxcd/source-controller/api/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	"sigs.k8s.io/cluster-api/util"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	extensionv1beta1 "github.com/gianlucam76/ytt-controller/api/v1beta1"
+	"github.com/gianlucam76/ytt-controller/controllers"
+
+	libsveltosset "github.com/projectsveltos/libsveltos/lib/set"
 )
 
 func randomString() string {
@@ -45,6 +54,21 @@ func setupScheme() (*runtime.Scheme, error) {
 	if err := extensionv1beta1.AddToScheme(s); err != nil {
 		return nil, err
 	}
+	if err := apiextensionsv1.AddToScheme(s); err != nil {
+		return nil, err
+	}
 
 	return s, nil
 }
+
+func getYttSourceReconciler(c client.Client) *controllers.YttSourceReconciler {
+	return &controllers.YttSourceReconciler{
+		Client:       c,
+		Scheme:       scheme,
+		ReferenceMap: make(map[corev1.ObjectReference]*libsveltosset.Set),
+		YttSourceMap: make(map[types.NamespacedName]*libsveltosset.Set),
+		PolicyMux:    sync.Mutex{},
+		// Same as the allow-cross-namespace-references flag default
+		AllowCrossNamespaceReferences: true,
+	}
+}
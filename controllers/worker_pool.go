@@ -0,0 +1,76 @@
+/*
+Copyright 2024. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// evaluationPoolName is the name of the pool bounding concurrent ytt evaluations
+	evaluationPoolName = "evaluation"
+	// downloadPoolName is the name of the pool bounding concurrent artifact downloads
+	downloadPoolName = "download"
+)
+
+// workerPool bounds the number of operations of a given kind running concurrently,
+// independently of the number of concurrent reconciliations. For instance ytt
+// evaluations are CPU heavy while artifact downloads are network heavy.
+// A nil workerPool does not bound anything.
+type workerPool struct {
+	name  string
+	slots chan struct{}
+}
+
+// newWorkerPool returns a workerPool running at most size operations concurrently.
+// Returns nil, so no limit, if size is not positive.
+func newWorkerPool(name string, size int) *workerPool {
+	if size <= 0 {
+		return nil
+	}
+
+	return &workerPool{
+		name:  name,
+		slots: make(chan struct{}, size),
+	}
+}
+
+// acquire blocks until a slot is available or ctx is done. On success, caller
+// must invoke the returned function once the operation is completed.
+func (p *workerPool) acquire(ctx context.Context) (release func(), err error) {
+	if p == nil {
+		return func() {}, nil
+	}
+
+	start := time.Now()
+	select {
+	case p.slots <- struct{}{}:
+		observeWorkerPoolWait(p.name, start)
+		return func() { <-p.slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// inUse returns the number of operations currently running
+func (p *workerPool) inUse() int {
+	if p == nil {
+		return 0
+	}
+	return len(p.slots)
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2024. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/gianlucam76/ytt-controller/controllers"
+)
+
+var _ = Describe("Worker pool", func() {
+	It("acquire blocks when all slots are in use", func() {
+		pool := controllers.NewWorkerPool("test", 2)
+
+		release1, err := controllers.Acquire(pool, context.TODO())
+		Expect(err).To(BeNil())
+		release2, err := controllers.Acquire(pool, context.TODO())
+		Expect(err).To(BeNil())
+		Expect(controllers.InUse(pool)).To(Equal(2))
+
+		ctx, cancel := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+		defer cancel()
+		_, err = controllers.Acquire(pool, ctx)
+		Expect(err).To(MatchError(context.DeadlineExceeded))
+
+		release1()
+		Expect(controllers.InUse(pool)).To(Equal(1))
+
+		release3, err := controllers.Acquire(pool, context.TODO())
+		Expect(err).To(BeNil())
+		Expect(controllers.InUse(pool)).To(Equal(2))
+
+		release2()
+		release3()
+		Expect(controllers.InUse(pool)).To(BeZero())
+	})
+
+	It("acquire waits for a slot to be released", func() {
+		pool := controllers.NewWorkerPool("test", 1)
+
+		release, err := controllers.Acquire(pool, context.TODO())
+		Expect(err).To(BeNil())
+
+		acquired := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			r, err := controllers.Acquire(pool, context.TODO())
+			Expect(err).To(BeNil())
+			close(acquired)
+			r()
+		}()
+
+		Consistently(acquired, 100*time.Millisecond).ShouldNot(BeClosed())
+		release()
+		Eventually(acquired, time.Second).Should(BeClosed())
+	})
+
+	It("a pool with no size does not limit operations", func() {
+		pool := controllers.NewWorkerPool("test", 0)
+		Expect(pool).To(BeNil())
+
+		for i := 0; i < 100; i++ {
+			_, err := controllers.Acquire(pool, context.TODO())
+			Expect(err).To(BeNil())
+		}
+		Expect(controllers.InUse(pool)).To(BeZero())
+	})
+})
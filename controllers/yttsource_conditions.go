@@ -0,0 +1,201 @@
+/*
+Copyright 2024. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	extensionv1beta1 "github.com/gianlucam76/ytt-controller/api/v1beta1"
+)
+
+var (
+	errSourceNotFound   = errors.New("source not found")
+	errArtifactNotReady = errors.New("source is not ready, artifact not found")
+	// errSecretTypeNotAllowed is returned when a referenced Secret has a type which
+	// is not allowed. Retrying cannot help until either the Secret or YttSource changes.
+	errSecretTypeNotAllowed = errors.New("secret type not allowed")
+	// errCrossNamespaceOutput is returned when output must be written to a namespace
+	// different from the YttSource one and the controller does not allow it
+	errCrossNamespaceOutput = errors.New("cross-namespace output is not allowed")
+	// errCrossNamespaceReference is returned when a resource which must be in the YttSource
+	// namespace is referenced in another namespace
+	errCrossNamespaceReference = errors.New("cross-namespace reference is not allowed")
+)
+
+// reconcileError is returned by reconcileNormal. Besides the error, it carries the
+// condition which failed and the reason, so that YttSource conditions can be set.
+type reconcileError struct {
+	conditionType string
+	reason        string
+	// stalled is true when retrying cannot help until either YttSource or
+	// the referenced source changes.
+	stalled bool
+	err     error
+}
+
+func (e *reconcileError) Error() string {
+	return e.err.Error()
+}
+
+func (e *reconcileError) Unwrap() error {
+	return e.err
+}
+
+func newSourceError(err error) *reconcileError {
+	if errors.Is(err, errSecretTypeNotAllowed) {
+		return &reconcileError{
+			conditionType: extensionv1beta1.SourceReadyCondition,
+			reason:        extensionv1beta1.SecretTypeNotAllowedReason,
+			stalled:       true,
+			err:           err,
+		}
+	}
+	if errors.Is(err, errCrossNamespaceReference) {
+		return &reconcileError{
+			conditionType: extensionv1beta1.SourceReadyCondition,
+			reason:        extensionv1beta1.ArtifactFetchFailedReason,
+			stalled:       true,
+			err:           err,
+		}
+	}
+
+	reason := extensionv1beta1.ArtifactFetchFailedReason
+	if apierrors.IsNotFound(err) || errors.Is(err, errSourceNotFound) {
+		reason = extensionv1beta1.SourceNotFoundReason
+	} else if errors.Is(err, errArtifactNotReady) {
+		reason = extensionv1beta1.ArtifactNotReadyReason
+	}
+
+	return &reconcileError{
+		conditionType: extensionv1beta1.SourceReadyCondition,
+		reason:        reason,
+		err:           err,
+	}
+}
+
+// newDataValuesError returns an error for failures collecting data values
+func newDataValuesError(err error) *reconcileError {
+	if errors.Is(err, errSecretTypeNotAllowed) {
+		return newTemplateError(extensionv1beta1.SecretTypeNotAllowedReason, true, err)
+	}
+	if errors.Is(err, errCrossNamespaceReference) {
+		return newTemplateError(extensionv1beta1.DataValuesFailedReason, true, err)
+	}
+	return newTemplateError(extensionv1beta1.DataValuesFailedReason, false, err)
+}
+
+// newDecryptionError returns an error for failures decrypting SOPS-encrypted files.
+// Unless the decryption Secret is missing, retrying cannot help until either the
+// Secret, the source or YttSource changes.
+func newDecryptionError(err error) *reconcileError {
+	if errors.Is(err, errSecretTypeNotAllowed) {
+		return newTemplateError(extensionv1beta1.SecretTypeNotAllowedReason, true, err)
+	}
+	return newTemplateError(extensionv1beta1.DecryptionFailedReason, !apierrors.IsNotFound(err), err)
+}
+
+func newTemplateError(reason string, stalled bool, err error) *reconcileError {
+	return &reconcileError{
+		conditionType: extensionv1beta1.TemplateRenderedCondition,
+		reason:        reason,
+		stalled:       stalled,
+		err:           err,
+	}
+}
+
+// newOutputError returns an error for failures storing the output. Only the
+// Ready condition is affected.
+func newOutputError(err error) *reconcileError {
+	return &reconcileError{
+		reason:  extensionv1beta1.OutputWriteFailedReason,
+		stalled: errors.Is(err, errCrossNamespaceOutput),
+		err:     err,
+	}
+}
+
+// isStalled returns true if err indicates reconciliation cannot make progress
+func isStalled(err error) bool {
+	var reconcileErr *reconcileError
+	if errors.As(err, &reconcileErr) {
+		return reconcileErr.stalled
+	}
+	return false
+}
+
+// getFailureReason returns the condition reason describing err
+func getFailureReason(err error) string {
+	var reconcileErr *reconcileError
+	if errors.As(err, &reconcileErr) {
+		return reconcileErr.reason
+	}
+	return extensionv1beta1.YttEvaluationFailedReason
+}
+
+func setCondition(yttSource *extensionv1beta1.YttSource, conditionType string,
+	status metav1.ConditionStatus, reason, message string) {
+
+	apimeta.SetStatusCondition(&yttSource.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: yttSource.Generation,
+	})
+}
+
+// markSourceReady sets SourceReady condition to true
+func markSourceReady(yttSource *extensionv1beta1.YttSource, revision string) {
+	setCondition(yttSource, extensionv1beta1.SourceReadyCondition, metav1.ConditionTrue,
+		extensionv1beta1.SucceededReason, fmt.Sprintf("fetched revision %s", revision))
+}
+
+// markTemplateRendered sets TemplateRendered condition to true
+func markTemplateRendered(yttSource *extensionv1beta1.YttSource, revision string) {
+	setCondition(yttSource, extensionv1beta1.TemplateRenderedCondition, metav1.ConditionTrue,
+		extensionv1beta1.SucceededReason, fmt.Sprintf("rendered revision %s", revision))
+}
+
+// setReconcileConditions sets Ready and Stalled conditions based on the outcome of
+// reconcileNormal. On failure, the condition which failed is also set to false.
+func setReconcileConditions(yttSource *extensionv1beta1.YttSource, err error) {
+	if err == nil {
+		setCondition(yttSource, extensionv1beta1.ReadyCondition, metav1.ConditionTrue,
+			extensionv1beta1.SucceededReason,
+			fmt.Sprintf("applied revision %s", yttSource.Status.LastAppliedRevision))
+		apimeta.RemoveStatusCondition(&yttSource.Status.Conditions, extensionv1beta1.StalledCondition)
+		return
+	}
+
+	reason := getFailureReason(err)
+	var reconcileErr *reconcileError
+	if errors.As(err, &reconcileErr) && reconcileErr.conditionType != "" {
+		setCondition(yttSource, reconcileErr.conditionType, metav1.ConditionFalse, reason, err.Error())
+	}
+
+	setCondition(yttSource, extensionv1beta1.ReadyCondition, metav1.ConditionFalse, reason, err.Error())
+
+	if isStalled(err) {
+		setCondition(yttSource, extensionv1beta1.StalledCondition, metav1.ConditionTrue, reason, err.Error())
+	} else {
+		apimeta.RemoveStatusCondition(&yttSource.Status.Conditions, extensionv1beta1.StalledCondition)
+	}
+}
@@ -22,22 +22,25 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	yttcmd "carvel.dev/ytt/pkg/cmd/template"
-	yttui "carvel.dev/ytt/pkg/cmd/ui"
-	yttfiles "carvel.dev/ytt/pkg/files"
+	"carvel.dev/ytt/pkg/yamlmeta"
 
 	"github.com/fluxcd/pkg/http/fetch"
 	"github.com/fluxcd/pkg/tar"
 	sourcev1 "github.com/fluxcd/source-controller/api/v1"
-	sourcev1b2 "github.com/fluxcd/source-controller/api/v1beta2"
 	"github.com/go-logr/logr"
 	"github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/cluster-api/util/patch"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
@@ -54,6 +57,12 @@ import (
 	libsveltosset "github.com/projectsveltos/libsveltos/lib/set"
 )
 
+const (
+	defaultArtifactRequeueInterval    = 10 * time.Second
+	defaultArtifactRequeueMaxInterval = 5 * time.Minute
+	defaultConcurrentReconciles       = 10
+)
+
 // YttSourceReconciler reconciles a YttSource object
 type YttSourceReconciler struct {
 	client.Client
@@ -63,19 +72,76 @@ type YttSourceReconciler struct {
 	PolicyMux            sync.Mutex                                    // use a Mutex to update Map as MaxConcurrentReconciles is higher than one
 	ReferenceMap         map[corev1.ObjectReference]*libsveltosset.Set // key: Referenced object; value: set of all YTTSources referencing the resource
 	YttSourceMap         map[types.NamespacedName]*libsveltosset.Set   // key: YTTSource namespace/name; value: set of referenced resources
+
+	// ArtifactRequeueInterval is the initial interval after which a YttSource is requeued
+	// when its Flux source has no artifact yet. Interval grows up to ArtifactRequeueMaxInterval.
+	ArtifactRequeueInterval    time.Duration
+	ArtifactRequeueMaxInterval time.Duration
+
+	// ArtifactCache, when set, is used to share downloaded Flux artifacts across YttSources.
+	ArtifactCache *ArtifactCache
+
+	// EventRecorder, when set, is used to emit Events on YttSources
+	EventRecorder record.EventRecorder
+
+	// AllowedSecretTypes are the types of the Secrets YttSources can read ytt files,
+	// data values and decryption keys from, unless YttSource sets its own. Defaults to
+	// libsveltos ClusterProfileSecretType.
+	AllowedSecretTypes []corev1.SecretType
+
+	// EvaluationWorkers is the maximum number of ytt evaluations running concurrently,
+	// across all reconciliations. Zero means no limit; the manager refuses it, as nothing
+	// would then bound the CPUs used by evaluations.
+	EvaluationWorkers int
+	// DownloadWorkers is the maximum number of Flux artifacts downloaded concurrently,
+	// across all reconciliations. Zero means no limit.
+	DownloadWorkers int
+
+	// MaxRenderTimeout is the maximum time ytt can spend evaluating the templates
+	// of a YttSource. It caps, and defaults, YttSource Spec.Timeout.
+	MaxRenderTimeout time.Duration
+	// MaxOutputSize is the maximum size, in bytes, of ytt output. Zero means no limit.
+	MaxOutputSize int64
+	// MaxDownloadSize is the maximum size, in bytes, of Flux artifacts and URL tarballs,
+	// both downloaded and extracted. Zero means no limit.
+	MaxDownloadSize int64
+	// AllowCrossNamespaceOutput indicates whether YttSource output can be written to
+	// a namespace different from the YttSource one
+	AllowCrossNamespaceOutput bool
+	// AllowCrossNamespaceReferences indicates whether a YttSource can reference sources,
+	// data values and decryption keys in a namespace different from its own
+	AllowCrossNamespaceReferences bool
+
+	evaluationPool *workerPool
+	downloadPool   *workerPool
+
+	// urlSourceStates contains, for each YttSource, the state of the tarball last
+	// downloaded for each of its URL sources, by index in getSources
+	urlSourceStatesMux sync.Mutex
+	urlSourceStates    map[types.NamespacedName]map[int]*urlSourceState
+
+	// runningEvaluations contains the YttSources whose ytt evaluation is running,
+	// abandoned evaluations included. Value is true if the evaluation was abandoned.
+	evaluationsMux     sync.Mutex
+	runningEvaluations map[types.NamespacedName]bool
 }
 
 //+kubebuilder:rbac:groups=extension.projectsveltos.io,resources=yttsources,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=extension.projectsveltos.io,resources=yttsources/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=extension.projectsveltos.io,resources=yttsources/finalizers,verbs=update
-//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
-//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 //+kubebuilder:rbac:groups="source.toolkit.fluxcd.io",resources=gitrepositories,verbs=get;watch;list
 //+kubebuilder:rbac:groups="source.toolkit.fluxcd.io",resources=gitrepositories/status,verbs=get;watch;list
 //+kubebuilder:rbac:groups="source.toolkit.fluxcd.io",resources=ocirepositories,verbs=get;watch;list
 //+kubebuilder:rbac:groups="source.toolkit.fluxcd.io",resources=ocirepositories/status,verbs=get;watch;list
 //+kubebuilder:rbac:groups="source.toolkit.fluxcd.io",resources=buckets,verbs=get;watch;list
 //+kubebuilder:rbac:groups="source.toolkit.fluxcd.io",resources=buckets/status,verbs=get;watch;list
+//+kubebuilder:rbac:groups="source.toolkit.fluxcd.io",resources=helmcharts,verbs=get;watch;list
+//+kubebuilder:rbac:groups="source.toolkit.fluxcd.io",resources=helmcharts/status,verbs=get;watch;list
+//+kubebuilder:rbac:groups="source.toolkit.fluxcd.io",resources=externalartifacts,verbs=get;watch;list
+//+kubebuilder:rbac:groups="source.toolkit.fluxcd.io",resources=externalartifacts/status,verbs=get;watch;list
 
 func (r *YttSourceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (_ ctrl.Result, reterr error) {
 	logger := ctrl.LoggerFrom(ctx)
@@ -85,6 +151,8 @@ func (r *YttSourceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 	yttSource := &extensionv1beta1.YttSource{}
 	if err := r.Get(ctx, req.NamespacedName, yttSource); err != nil {
 		if apierrors.IsNotFound(err) {
+			forgetYttSource(req.NamespacedName)
+			r.clearURLSourceStates(req.NamespacedName)
 			return reconcile.Result{}, nil
 		}
 		logger.Error(err, "Failed to fetch YttSource")
@@ -113,23 +181,94 @@ func (r *YttSourceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 
 	// Handle deleted YttSource
 	if !yttSource.DeletionTimestamp.IsZero() {
-		r.cleanMaps(yttSource)
-		return reconcile.Result{}, nil
+		err = r.reconcileDelete(ctx, yttSource, logger)
+		if err == nil {
+			forgetYttSource(req.NamespacedName)
+		}
+		return reconcile.Result{}, err
 	}
 
 	// Handle non-deleted YttSource
+	// Must be evaluated before conditions are updated
+	requeueAfter := r.getArtifactRequeueAfter(yttSource)
+	previousReady := getReadyCondition(yttSource)
+	previousRevision := yttSource.Status.LastAppliedRevision
+
 	var resources string
 	resources, err = r.reconcileNormal(ctx, yttSource, logger)
+	if errors.Is(err, errArtifactNotReady) {
+		// Source-controller has not produced an artifact yet. Keep last output and
+		// retry later. This is expected on startup.
+		msg := err.Error()
+		yttSource.Status.FailureMessage = &msg
+		setReconcileConditions(yttSource, err)
+		yttSource.Status.ObservedGeneration = yttSource.Generation
+		recordRenderFailure(yttSource, err)
+		trackReadyState(yttSource)
+		r.emitEvent(yttSource, previousReady, previousRevision, err)
+		logger.V(logs.LogInfo).Info(fmt.Sprintf("source not ready. Requeue after %s", requeueAfter))
+		return reconcile.Result{RequeueAfter: requeueAfter}, nil
+	}
+
 	if err != nil {
 		msg := err.Error()
 		yttSource.Status.FailureMessage = &msg
-		yttSource.Status.Resources = ""
+		recordRenderFailure(yttSource, err)
+		if !yttSource.Spec.ShouldKeepLastOutputOnFailure() {
+			r.clearOutput(ctx, yttSource, logger)
+		}
 	} else {
 		yttSource.Status.FailureMessage = nil
 		yttSource.Status.Resources = resources
+		yttSource.Status.LastAppliedRevision = yttSource.Status.LastAttemptedRevision
+	}
+
+	setReconcileConditions(yttSource, err)
+	yttSource.Status.ObservedGeneration = yttSource.Generation
+	trackReadyState(yttSource)
+	r.emitEvent(yttSource, previousReady, previousRevision, err)
+
+	// Changes of remote sources (URL and OCI) cannot be watched. Poll them.
+	pollInterval := getPollInterval(yttSource)
+
+	if isStalled(err) {
+		// Retrying won't help. A new reconciliation is triggered when either
+		// YttSource or the referenced source changes.
+		logger.V(logs.LogInfo).Info(fmt.Sprintf("reconciliation stalled: %v", err))
+		return reconcile.Result{RequeueAfter: pollInterval}, nil
+	}
+
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	return reconcile.Result{RequeueAfter: pollInterval}, nil
+}
+
+// getArtifactRequeueAfter returns after how long a YttSource whose Flux source has no
+// artifact must be requeued. Interval grows with the time the source has been
+// not ready, bounded by ArtifactRequeueMaxInterval.
+func (r *YttSourceReconciler) getArtifactRequeueAfter(yttSource *extensionv1beta1.YttSource) time.Duration {
+	interval := r.ArtifactRequeueInterval
+	if interval <= 0 {
+		interval = defaultArtifactRequeueInterval
+	}
+	maxInterval := r.ArtifactRequeueMaxInterval
+	if maxInterval <= 0 {
+		maxInterval = defaultArtifactRequeueMaxInterval
+	}
+
+	c := apimeta.FindStatusCondition(yttSource.Status.Conditions, extensionv1beta1.SourceReadyCondition)
+	if c != nil && c.Status == metav1.ConditionFalse && c.Reason == extensionv1beta1.ArtifactNotReadyReason {
+		if elapsed := time.Since(c.LastTransitionTime.Time); elapsed > interval {
+			interval = elapsed
+		}
+	}
+
+	if interval > maxInterval {
+		interval = maxInterval
 	}
 
-	return reconcile.Result{}, err
+	return interval
 }
 
 func (r *YttSourceReconciler) reconcileNormal(
@@ -142,76 +281,144 @@ func (r *YttSourceReconciler) reconcileNormal(
 
 	r.updateMaps(yttSource, logger)
 
-	tmpDir, err := r.prepareFileSystem(ctx, yttSource, logger)
+	// Errors are ignored here: same failure is reported, with a proper reason,
+	// when source is fetched.
+	fingerprint, err := r.getInputFingerprint(ctx, yttSource)
 	if err != nil {
-		return "", err
+		logger.V(logs.LogDebug).Info(fmt.Sprintf("failed to compute input fingerprint: %v", err))
+	} else if r.isUpToDate(ctx, yttSource, fingerprint, logger) {
+		logger.V(logs.LogInfo).Info("inputs have not changed. Skip rendering")
+		return yttSource.Status.Resources, nil
 	}
 
-	if tmpDir == "" {
-		return "", nil
+	fetchStart := time.Now()
+	mounts, cleanup, err := r.prepareSources(ctx, yttSource, logger)
+	observeFetchDuration(yttSource, fetchStart)
+	if err != nil {
+		return "", newSourceError(err)
 	}
 
-	defer os.RemoveAll(tmpDir)
+	defer cleanup()
 
-	// check build path exists
-	dirPath := filepath.Join(tmpDir, yttSource.Spec.Path)
-	_, err = os.Stat(dirPath)
-	if err != nil {
-		logger.V(logs.LogInfo).Info(fmt.Sprintf("ytt path not found: %v", err))
-		return "", err
+	revision := getRevision(mounts, yttSource.Spec.Files)
+	yttSource.Status.LastAttemptedRevision = revision
+	markSourceReady(yttSource, revision)
+
+	// check build paths exist
+	if err := verifyPaths(mounts, logger); err != nil {
+		return "", newTemplateError(extensionv1beta1.PathNotFoundReason, true, err)
 	}
 
 	// create and invoke ytt "template" command
 	templatingOptions := yttcmd.NewOptions()
 
-	input, err := templatesAsInput(dirPath, yttSource, logger)
+	decryptor, err := getSOPSDecryptor(ctx, r.Client, yttSource, r.getAllowedSecretTypes(yttSource),
+		r.AllowCrossNamespaceReferences)
 	if err != nil {
-		return "", err
+		logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to get decryption keys: %v", err))
+		return "", newDecryptionError(err)
 	}
 
-	// equivalent to `--data-value-yaml`
-	templatingOptions.DataValuesFlags.KVsFromYAML = []string{}
+	input, err := templatesAsInput(mounts, yttSource.Spec.Files, decryptor, logger)
+	if err != nil {
+		if errors.Is(err, errDecryptionFailed) {
+			return "", newDecryptionError(err)
+		}
+		return "", newSourceError(err)
+	}
 
-	noopUI := yttui.NewCustomWriterTTY(false, noopWriter{}, noopWriter{})
+	err = setDataValues(ctx, r.Client, yttSource, r.getAllowedSecretTypes(yttSource),
+		r.AllowCrossNamespaceReferences, &templatingOptions.DataValuesFlags, logger)
+	if err != nil {
+		logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to get data values: %v", err))
+		return "", newDataValuesError(err)
+	}
 
 	// Evaluate the template given the configured data values...
-	output := templatingOptions.RunWithFiles(input, noopUI)
+	output, err := r.evaluate(ctx, yttSource, templatingOptions, input)
+	if err != nil {
+		logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to evaluate templates: %v", err))
+		if errors.Is(err, errRenderTimeout) {
+			// Same templates would time out again. Wait for inputs to change.
+			return "", newTemplateError(extensionv1beta1.RenderTimeoutReason, true, err)
+		}
+		if errors.Is(err, errEvaluationNotStarted) {
+			// Templates were not evaluated. Retry once evaluations in progress return.
+			return "", newTemplateError(extensionv1beta1.RenderTimeoutReason, false, err)
+		}
+		return "", newTemplateError(extensionv1beta1.YttEvaluationFailedReason, false, err)
+	}
 	if output.Err != nil {
 		logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to execute RunWithFiles: %v", output.Err))
-		return "", output.Err
+		return "", newTemplateError(extensionv1beta1.YttEvaluationFailedReason, true, output.Err)
 	}
 
 	// output.DocSet contains the full set of resulting YAML documents, in order.
 	bs, err := output.DocSet.AsBytes()
 	if err != nil {
 		logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to get result: %v", err))
-		return "", err
+		return "", newTemplateError(extensionv1beta1.YttEvaluationFailedReason, true, err)
+	}
+
+	if err := r.checkOutputSize(bs); err != nil {
+		logger.V(logs.LogInfo).Info(err.Error())
+		return "", newTemplateError(extensionv1beta1.OutputTooLargeReason, true, err)
 	}
 
+	markTemplateRendered(yttSource, revision)
+
+	resources, err := r.handleOutput(ctx, yttSource, bs, logger)
+	if err != nil {
+		return "", newOutputError(err)
+	}
+
+	// Real values of Secrets only go to Spec.Output. Status.Resources gets a redacted copy.
+	if yttSource.Spec.IsSecretRedactionEnabled() {
+		resources, err = getRedactedResources(output.DocSet, yttSource.Spec.SecretRedaction)
+		if err != nil {
+			logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to redact result: %v", err))
+			return "", newTemplateError(extensionv1beta1.YttEvaluationFailedReason, true, err)
+		}
+	}
+
+	yttSource.Status.InputDigest = fingerprint
+	recordRenderSuccess(yttSource, bs, countDocuments(output.DocSet))
+
 	logger.V(logs.LogInfo).Info("Reconciling YttSource success")
-	return string(bs), nil
+	return resources, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *YttSourceReconciler) SetupWithManager(mgr ctrl.Manager,
 ) (controller.Controller, error) {
 
-	c, err := ctrl.NewControllerManagedBy(mgr).
+	concurrentReconciles := r.ConcurrentReconciles
+	if concurrentReconciles <= 0 {
+		concurrentReconciles = defaultConcurrentReconciles
+	}
+
+	r.evaluationPool = newWorkerPool(evaluationPoolName, r.EvaluationWorkers)
+	r.downloadPool = newWorkerPool(downloadPoolName, r.DownloadWorkers)
+
+	b := ctrl.NewControllerManagedBy(mgr).
 		For(&extensionv1beta1.YttSource{}).
 		WithOptions(controller.Options{
-			MaxConcurrentReconciles: 5,
-		}).
-		Watches(&corev1.ConfigMap{},
+			MaxConcurrentReconciles: concurrentReconciles,
+		})
+
+	// Flux sources are watched only once Flux is installed. See WatchForFlux.
+	for i := range sourceKinds {
+		kind := &sourceKinds[i]
+		if kind.isFlux() {
+			continue
+		}
+		b = b.Watches(kind.getVersion().newObject(),
 			handler.EnqueueRequestsFromMapFunc(r.requeueYttSourceForReference),
-			builder.WithPredicates(
-				ConfigMapPredicates(mgr.GetLogger().WithValues("predicate", "configmappredicate")),
-			),
-		).Watches(&corev1.Secret{},
-		handler.EnqueueRequestsFromMapFunc(r.requeueYttSourceForReference),
-		builder.WithPredicates(
-			SecretPredicates(mgr.GetLogger().WithValues("predicate", "secretpredicate")),
-		),
-	).Build(r)
+			builder.WithPredicates(kind.predicates(getPredicateLogger(mgr, kind))),
+		)
+	}
+
+	c, err := b.Build(r)
 	if err != nil {
 		return nil, errors.Wrap(err, "error creating controller")
 	}
@@ -219,43 +426,49 @@ func (r *YttSourceReconciler) SetupWithManager(mgr ctrl.Manager,
 	return c, err
 }
 
-func (r *YttSourceReconciler) WatchForFlux(mgr ctrl.Manager, c controller.Controller) error {
-	// When a Flux source (GitRepository/OCIRepository/Bucket) changes, one or more YttSources
-	// need to be reconciled.
-
-	sourceGitRepository := source.Kind[*sourcev1.GitRepository](
-		mgr.GetCache(),
-		&sourcev1.GitRepository{},
-		handler.TypedEnqueueRequestsFromMapFunc(r.requeueYttSourceForFluxGitRepository),
-		FluxGitRepositoryPredicate{Logger: mgr.GetLogger().WithValues("predicate", "fluxsourcepredicate")},
-	)
-	if err := c.Watch(sourceGitRepository); err != nil {
+// WatchForFlux starts watching Flux sources. For each Flux source kind, the preferred
+// version among the ones served by the cluster is watched. Kinds whose CRD is not
+// installed are not watched.
+func (r *YttSourceReconciler) WatchForFlux(ctx context.Context, mgr ctrl.Manager, c controller.Controller) error {
+	versions, err := discoverFluxSourceVersions(ctx, mgr.GetAPIReader())
+	if err != nil {
 		return err
 	}
+	setFluxSourceVersions(versions)
 
-	sourceOCIRepository := source.Kind[*sourcev1b2.OCIRepository](
-		mgr.GetCache(),
-		&sourcev1b2.OCIRepository{},
-		handler.TypedEnqueueRequestsFromMapFunc(r.requeueYttSourceForFluxOCIRepository),
-		FluxOCIRepositoryPredicate{Logger: mgr.GetLogger().WithValues("predicate", "fluxsourcepredicate")},
-	)
-	if err := c.Watch(sourceOCIRepository); err != nil {
-		return err
-	}
+	// When a Flux source (GitRepository/OCIRepository/Bucket/HelmChart/ExternalArtifact) changes, one or more YttSources
+	// need to be reconciled.
+	for i := range sourceKinds {
+		kind := &sourceKinds[i]
+		if !kind.isFlux() {
+			continue
+		}
 
-	sourceBucket := source.Kind[*sourcev1b2.Bucket](
-		mgr.GetCache(),
-		&sourcev1b2.Bucket{},
-		handler.TypedEnqueueRequestsFromMapFunc(r.requeueYttSourceForFluxBucket),
-		FluxBucketPredicate{Logger: mgr.GetLogger().WithValues("predicate", "fluxsourcepredicate")},
-	)
-	if err := c.Watch(sourceBucket); err != nil {
-		return err
+		version := versions[kind.gvk.Kind]
+		if version == nil {
+			mgr.GetLogger().V(logs.LogInfo).Info(fmt.Sprintf("Flux %s not served. Not watching it", kind.gvk.Kind))
+			continue
+		}
+		mgr.GetLogger().V(logs.LogInfo).Info(fmt.Sprintf("watching Flux %s %s", kind.gvk.Kind, version.version))
+
+		fluxSource := source.Kind(
+			mgr.GetCache(),
+			version.newObject(),
+			handler.EnqueueRequestsFromMapFunc(r.requeueYttSourceForReference),
+			kind.predicates(getPredicateLogger(mgr, kind)),
+		)
+		if err := c.Watch(fluxSource); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+func getPredicateLogger(mgr ctrl.Manager, kind *sourceKind) logr.Logger {
+	return mgr.GetLogger().WithValues("predicate", strings.ToLower(kind.gvk.Kind)+"predicate")
+}
+
 func (r *YttSourceReconciler) getReferenceMapForEntry(entry *corev1.ObjectReference) *libsveltosset.Set {
 	s := r.ReferenceMap[*entry]
 	if s == nil {
@@ -265,21 +478,44 @@ func (r *YttSourceReconciler) getReferenceMapForEntry(entry *corev1.ObjectRefere
 	return s
 }
 
-func (r *YttSourceReconciler) getCurrentReference(yttSource *extensionv1beta1.YttSource) *corev1.ObjectReference {
-	return &corev1.ObjectReference{
-		APIVersion: getReferenceAPIVersion(yttSource),
-		Kind:       yttSource.Spec.Kind,
-		Namespace:  yttSource.Spec.Namespace,
-		Name:       yttSource.Spec.Name,
+// getCurrentReferences returns all resources currently referenced by YttSource: the sources
+// containing ytt files, any ConfigMap/Secret containing data values and the Secret
+// containing decryption keys.
+func (r *YttSourceReconciler) getCurrentReferences(yttSource *extensionv1beta1.YttSource) *libsveltosset.Set {
+	currentReferences := &libsveltosset.Set{}
+	sources := getSources(yttSource)
+	for i := range sources {
+		if isRemoteSource(&sources[i]) {
+			// Remote sources are polled. Only the Secrets they are fetched
+			// with can be watched.
+			secretRefs := getSourceSecretReferences(yttSource.Namespace, &sources[i])
+			for j := range secretRefs {
+				currentReferences.Insert(&secretRefs[j])
+			}
+			continue
+		}
+		// References are only watched here. Nothing is read, so cross-namespace
+		// references are refused when fetching.
+		ref, _ := getSourceReference(yttSource, &sources[i], true)
+		currentReferences.Insert(ref)
+	}
+
+	valuesRefs, _ := getDataValuesReferences(yttSource, true)
+	for i := range valuesRefs {
+		currentReferences.Insert(&valuesRefs[i])
+	}
+
+	if ref, _ := getDecryptionSecretReference(yttSource, true); ref != nil {
+		currentReferences.Insert(ref)
 	}
+
+	return currentReferences
 }
 
 func (r *YttSourceReconciler) updateMaps(yttSource *extensionv1beta1.YttSource, logger logr.Logger) {
 	logger.V(logs.LogDebug).Info("update policy map")
-	ref := r.getCurrentReference(yttSource)
 
-	currentReference := &libsveltosset.Set{}
-	currentReference.Insert(ref)
+	currentReference := r.getCurrentReferences(yttSource)
 
 	r.PolicyMux.Lock()
 	defer r.PolicyMux.Unlock()
@@ -292,8 +528,11 @@ func (r *YttSourceReconciler) updateMaps(yttSource *extensionv1beta1.YttSource,
 	}
 
 	yttSourceInfo := getKeyFromObject(r.Scheme, yttSource)
-	// For currently referenced instance, add YttSource as consumer
-	r.getReferenceMapForEntry(ref).Insert(yttSourceInfo)
+	// For each currently referenced instance, add YttSource as consumer
+	references := currentReference.Items()
+	for i := range references {
+		r.getReferenceMapForEntry(&references[i]).Insert(yttSourceInfo)
+	}
 
 	// For each resource not reference anymore, remove YttSource as consumer
 	for i := range toBeRemoved {
@@ -321,96 +560,201 @@ func (r *YttSourceReconciler) cleanMaps(yttSource *extensionv1beta1.YttSource) {
 	}
 }
 
-func (r *YttSourceReconciler) prepareFileSystem(ctx context.Context,
-	yttSource *extensionv1beta1.YttSource, logger logr.Logger) (string, error) {
+// getAllowedSecretTypes returns the types of the Secrets yttSource can read from
+func (r *YttSourceReconciler) getAllowedSecretTypes(yttSource *extensionv1beta1.YttSource) []corev1.SecretType {
+	if len(yttSource.Spec.AllowedSecretTypes) != 0 {
+		return yttSource.Spec.AllowedSecretTypes
+	}
+	if len(r.AllowedSecretTypes) != 0 {
+		return r.AllowedSecretTypes
+	}
+	return []corev1.SecretType{libsveltosv1beta1.ClusterProfileSecretType}
+}
 
-	ref := r.getCurrentReference(yttSource)
+// prepareFileSystem fetches the content of the referenced source in a directory.
+// It returns the directory, the revision of the source which has been fetched and
+// a function to invoke once done with the directory.
+// The directory must not be modified as it might be shared with other YttSources.
+func (r *YttSourceReconciler) prepareFileSystem(ctx context.Context, ref *corev1.ObjectReference,
+	layout *extensionv1beta1.DataLayout, allowedSecretTypes []corev1.SecretType,
+	logger logr.Logger) (dir, revision string, cleanup func(), err error) {
 
 	if ref.Kind == string(libsveltosv1beta1.ConfigMapReferencedResourceKind) {
-		return prepareFileSystemWithConfigMap(ctx, r.Client, ref, logger)
+		return prepareFileSystemWithConfigMap(ctx, r.Client, ref, layout, logger)
 	} else if ref.Kind == string(libsveltosv1beta1.SecretReferencedResourceKind) {
-		return prepareFileSystemWithSecret(ctx, r.Client, ref, logger)
+		return prepareFileSystemWithSecret(ctx, r.Client, ref, layout, allowedSecretTypes, logger)
 	}
 
-	return prepareFileSystemWithFluxSource(ctx, r.Client, ref, logger)
+	return prepareFileSystemWithFluxSource(ctx, r.Client, r.ArtifactCache, r.downloadPool, r.MaxDownloadSize,
+		ref, logger)
 }
 
-func prepareFileSystemWithConfigMap(ctx context.Context, c client.Client,
-	ref *corev1.ObjectReference, logger logr.Logger) (string, error) {
+func prepareFileSystemWithConfigMap(ctx context.Context, c client.Client, ref *corev1.ObjectReference,
+	layout *extensionv1beta1.DataLayout, logger logr.Logger) (dir, revision string, cleanup func(), err error) {
 
 	configMap, err := getConfigMap(ctx, c, types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name})
 	if err != nil {
-		return "", err
+		return "", "", nil, err
 	}
 
-	return prepareFileSystemWithData(configMap.BinaryData, ref, logger)
+	data := make(map[string][]byte, len(configMap.Data)+len(configMap.BinaryData))
+	for k := range configMap.Data {
+		data[k] = []byte(configMap.Data[k])
+	}
+	for k := range configMap.BinaryData {
+		data[k] = configMap.BinaryData[k]
+	}
+
+	dir, cleanup, err = prepareFileSystemWithData(data, layout, ref, logger)
+	return dir, configMap.ResourceVersion, cleanup, err
 }
 
-func prepareFileSystemWithSecret(ctx context.Context, c client.Client,
-	ref *corev1.ObjectReference, logger logr.Logger) (string, error) {
+func prepareFileSystemWithSecret(ctx context.Context, c client.Client, ref *corev1.ObjectReference,
+	layout *extensionv1beta1.DataLayout, allowedSecretTypes []corev1.SecretType,
+	logger logr.Logger) (dir, revision string, cleanup func(), err error) {
 
-	secret, err := getSecret(ctx, c, types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name})
+	secret, err := getSecret(ctx, c, types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name},
+		allowedSecretTypes)
 	if err != nil {
-		return "", err
+		return "", "", nil, err
 	}
 
-	return prepareFileSystemWithData(secret.Data, ref, logger)
+	dir, cleanup, err = prepareFileSystemWithData(secret.Data, layout, ref, logger)
+	return dir, secret.ResourceVersion, cleanup, err
 }
 
-func prepareFileSystemWithData(binaryData map[string][]byte,
-	ref *corev1.ObjectReference, logger logr.Logger) (string, error) {
-
-	key := "ytt.tar.gz"
-	binaryTarGz, ok := binaryData[key]
-	if !ok {
-		return "", fmt.Errorf("%s missing", key)
-	}
+// prepareFileSystemWithData writes the ytt files contained in data in a new directory,
+// according to layout.
+func prepareFileSystemWithData(data map[string][]byte, layout *extensionv1beta1.DataLayout,
+	ref *corev1.ObjectReference, logger logr.Logger) (string, func(), error) {
 
 	// Create tmp dir.
 	tmpDir, err := os.MkdirTemp("", fmt.Sprintf("ytt-%s-%s",
 		ref.Namespace, ref.Name))
 	if err != nil {
 		err = fmt.Errorf("tmp dir error: %w", err)
-		return "", err
+		return "", nil, err
 	}
+	cleanup := func() { os.RemoveAll(tmpDir) }
 
-	filePath := path.Join(tmpDir, key)
+	extractedDir := path.Join(tmpDir, "extracted")
+	if err := os.MkdirAll(extractedDir, permission0755); err != nil {
+		cleanup()
+		return "", nil, err
+	}
 
-	err = os.WriteFile(filePath, binaryTarGz, permission0600)
-	if err != nil {
-		logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to write file %s: %v", filePath, err))
-		return "", err
+	if layout != nil && layout.Mode == extensionv1beta1.DataLayoutFiles {
+		err = writeDataFiles(data, getPathSeparator(layout), extractedDir)
+		if err != nil {
+			logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to write files: %v", err))
+			cleanup()
+			return "", nil, err
+		}
+		logger.V(logs.LogDebug).Info("wrote files")
+		return extractedDir, cleanup, nil
 	}
 
-	tmpDir = path.Join(tmpDir, "extracted")
+	for _, key := range getArchiveKeys(layout) {
+		archive, ok := data[key]
+		if !ok {
+			cleanup()
+			return "", nil, fmt.Errorf("%s missing", key)
+		}
 
-	err = extractTarGz(filePath, tmpDir)
-	if err != nil {
-		logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to extract tar.gz: %v", err))
-		return "", err
+		err = extractArchive(key, archive, extractedDir)
+		if err != nil {
+			logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to extract %s: %v", key, err))
+			cleanup()
+			return "", nil, err
+		}
 	}
 
-	logger.V(logs.LogDebug).Info("extracted .tar.gz")
-	return tmpDir, nil
+	logger.V(logs.LogDebug).Info("extracted archives")
+	return extractedDir, cleanup, nil
 }
 
-func prepareFileSystemWithFluxSource(ctx context.Context, c client.Client,
-	ref *corev1.ObjectReference, logger logr.Logger) (string, error) {
+// writeDataFiles writes each entry of data as a file in dest. Separator in keys
+// is replaced with '/'.
+func writeDataFiles(data map[string][]byte, separator, dest string) error {
+	for key := range data {
+		name := strings.ReplaceAll(key, separator, "/")
+		target := filepath.Join(dest, filepath.Clean(name))
+		if !strings.HasPrefix(target, dest+string(os.PathSeparator)) {
+			return fmt.Errorf("key %q is outside of destination directory", key)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), permission0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(target, data[key], permission0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getArchiveKeys(layout *extensionv1beta1.DataLayout) []string {
+	if layout == nil || len(layout.ArchiveKeys) == 0 {
+		return []string{extensionv1beta1.DefaultArchiveKey}
+	}
+	return layout.ArchiveKeys
+}
+
+func getPathSeparator(layout *extensionv1beta1.DataLayout) string {
+	if layout.PathSeparator == "" {
+		return extensionv1beta1.DefaultPathSeparator
+	}
+	return layout.PathSeparator
+}
+
+func prepareFileSystemWithFluxSource(ctx context.Context, c client.Client, artifactCache *ArtifactCache,
+	downloadPool *workerPool, maxDownloadSize int64, ref *corev1.ObjectReference, logger logr.Logger,
+) (dir, revision string, cleanup func(), err error) {
 
 	fluxSource, err := getSource(ctx, c, ref)
 	if err != nil {
-		return "", err
+		return "", "", nil, err
 	}
 
 	if fluxSource == nil {
-		return "", fmt.Errorf("source %s %s/%s not found",
+		return "", "", nil, fmt.Errorf("%w: %s %s/%s", errSourceNotFound,
 			ref.Kind, ref.Namespace, ref.Name)
 	}
 
-	if fluxSource.GetArtifact() == nil {
+	artifact := fluxSource.GetArtifact()
+	if artifact == nil {
 		msg := "Source is not ready, artifact not found"
 		logger.V(logs.LogInfo).Info(msg)
-		return "", err
+		return "", "", nil, fmt.Errorf("%w: %s %s/%s", errArtifactNotReady,
+			ref.Kind, ref.Namespace, ref.Name)
+	}
+
+	// Download artifact and extract files to dir.
+	// Downloads are network heavy. Wait for a free slot.
+	fetchArtifact := func(dir string) error {
+		release, err := downloadPool.acquire(ctx)
+		if err != nil {
+			return err
+		}
+		defer release()
+
+		artifactFetcher := fetch.New(
+			fetch.WithRetries(1),
+			fetch.WithMaxDownloadSize(getMaxUntarSize(maxDownloadSize)),
+			fetch.WithUntar(tar.WithMaxUntarSize(getMaxUntarSize(maxDownloadSize))),
+			fetch.WithHostnameOverwrite(os.Getenv("SOURCE_CONTROLLER_LOCALHOST")))
+
+		return artifactFetcher.Fetch(artifact.URL, artifact.Digest, dir)
+	}
+
+	if artifactCache != nil && artifact.Digest != "" {
+		dir, cleanup, err = artifactCache.Acquire(getArtifactCacheKey(ref.Kind, "", artifact.Digest),
+			fetchArtifact)
+		if err != nil {
+			return "", "", nil, err
+		}
+		logger.V(logs.LogDebug).Info(fmt.Sprintf("using cached artifact %s", artifact.Digest))
+		return dir, artifact.Revision, cleanup, nil
 	}
 
 	// Create tmp dir.
@@ -418,96 +762,46 @@ func prepareFileSystemWithFluxSource(ctx context.Context, c client.Client,
 		ref.Namespace, ref.Name))
 	if err != nil {
 		err = fmt.Errorf("tmp dir error: %w", err)
-		return "", err
+		return "", "", nil, err
 	}
+	cleanup = func() { os.RemoveAll(tmpDir) }
 
-	artifactFetcher := fetch.New(
-		fetch.WithRetries(1),
-		fetch.WithMaxDownloadSize(tar.UnlimitedUntarSize),
-		fetch.WithUntar(tar.WithMaxUntarSize(tar.UnlimitedUntarSize)),
-		fetch.WithHostnameOverwrite(os.Getenv("SOURCE_CONTROLLER_LOCALHOST")))
-
-	// Download artifact and extract files to the tmp dir.
-	err = artifactFetcher.Fetch(fluxSource.GetArtifact().URL, fluxSource.GetArtifact().Digest, tmpDir)
+	err = fetchArtifact(tmpDir)
 	if err != nil {
-		return "", err
+		cleanup()
+		return "", "", nil, err
 	}
 
-	return tmpDir, nil
+	return tmpDir, artifact.Revision, cleanup, nil
 }
 
 func getSource(ctx context.Context, c client.Client, ref *corev1.ObjectReference) (sourcev1.Source, error) {
-	var src sourcev1.Source
+	kind := lookupSourceKind(ref.Kind)
+	if kind == nil || !kind.isFlux() {
+		return nil, fmt.Errorf("source `%s` kind '%s' not supported",
+			ref.Name, ref.Kind)
+	}
+
 	namespacedName := types.NamespacedName{
 		Namespace: ref.Namespace,
 		Name:      ref.Name,
 	}
 
-	switch ref.Kind {
-	case sourcev1.GitRepositoryKind:
-		var repository sourcev1.GitRepository
-		err := c.Get(ctx, namespacedName, &repository)
-		if err != nil {
-			if apierrors.IsNotFound(err) {
-				return nil, nil
-			}
-			return nil, fmt.Errorf("unable to get source '%s': %w", namespacedName, err)
-		}
-		src = &repository
-	case sourcev1b2.OCIRepositoryKind:
-		var repository sourcev1b2.OCIRepository
-		err := c.Get(ctx, namespacedName, &repository)
-		if err != nil {
-			if apierrors.IsNotFound(err) {
-				return nil, nil
-			}
-			return src, fmt.Errorf("unable to get source '%s': %w", namespacedName, err)
-		}
-		src = &repository
-	case sourcev1b2.BucketKind:
-		var bucket sourcev1b2.Bucket
-		err := c.Get(ctx, namespacedName, &bucket)
-		if err != nil {
-			if apierrors.IsNotFound(err) {
-				return nil, nil
-			}
-			return src, fmt.Errorf("unable to get source '%s': %w", namespacedName, err)
-		}
-		src = &bucket
-	default:
-		return src, fmt.Errorf("source `%s` kind '%s' not supported",
-			ref.Name, ref.Kind)
-	}
-	return src, nil
-}
-
-// templatesAsInput conveniently wraps one or more strings, each in a files.File, into a template.Input.
-func templatesAsInput(dirPath string, yttSource *extensionv1beta1.YttSource,
-	logger logr.Logger) (yttcmd.Input, error) {
-
-	// Get all files in the directory
-	currentFiles, err := getFilesRecursively(dirPath)
+	obj := kind.getVersion().newObject()
+	err := c.Get(ctx, namespacedName, obj)
 	if err != nil {
-		logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to list files in directory %s: %v", dirPath, err))
-		return yttcmd.Input{}, err
-	}
-
-	var files []*yttfiles.File
-	for i := range currentFiles {
-		content, err := readFileContent(currentFiles[i])
-		if err != nil {
-			logger.V(logs.LogInfo).Info(fmt.Sprintf("Failed to read file %s: %v", currentFiles[i], err))
-			return yttcmd.Input{}, err
-		}
-		file, err := yttfiles.NewFileFromSource(yttfiles.NewBytesSource(fmt.Sprintf("tpl%d-%s-%s/%s", i, yttSource.Namespace, yttSource.Name, currentFiles[i]),
-			[]byte(content)))
-		if err != nil {
-			return yttcmd.Input{}, err
+		if apierrors.IsNotFound(err) {
+			return nil, nil
 		}
-		files = append(files, file)
+		return nil, fmt.Errorf("unable to get source '%s': %w", namespacedName, err)
 	}
 
-	return yttcmd.Input{Files: files}, nil
+	src, ok := obj.(sourcev1.Source)
+	if !ok {
+		return nil, fmt.Errorf("source `%s` kind '%s' does not expose an artifact",
+			ref.Name, ref.Kind)
+	}
+	return src, nil
 }
 
 // getFilesRecursively returns a list of all files in a directory and its subdirectories.
@@ -536,6 +830,17 @@ func readFileContent(file string) (string, error) {
 	return string(content), nil
 }
 
+// countDocuments returns the number of non empty YAML documents in docSet
+func countDocuments(docSet *yamlmeta.DocumentSet) int {
+	count := 0
+	for _, doc := range docSet.Items {
+		if !doc.IsEmpty() {
+			count++
+		}
+	}
+	return count
+}
+
 type noopWriter struct{}
 
 func (w noopWriter) Write(data []byte) (int, error) { return len(data), nil }
@@ -18,15 +18,31 @@ package controllers_test
 
 import (
 	"archive/tar"
+	"archive/zip"
+	"bytes"
 	"compress/gzip"
+	"context"
 	"io"
 	"os"
 	"path/filepath"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	extensionv1beta1 "github.com/gianlucam76/ytt-controller/api/v1beta1"
 	"github.com/gianlucam76/ytt-controller/controllers"
+
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
 )
 
 var _ = Describe("YttSource Controller", func() {
@@ -62,8 +78,612 @@ var _ = Describe("YttSource Controller", func() {
 		_, err = os.Stat(extraFilePath)
 		Expect(os.IsNotExist(err)).To(BeTrue())
 	})
+
+	It("Reconcile sets Ready condition, revisions and ObservedGeneration on success", func() {
+		configMap := getYttConfigMap()
+		yttSource := getYttSourceForConfigMap(configMap, "./")
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource).
+			WithObjects(configMap, yttSource).Build()
+
+		reconciler := getYttSourceReconciler(c)
+		_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+		})
+		Expect(err).To(BeNil())
+
+		currentYttSource := &extensionv1beta1.YttSource{}
+		Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+			currentYttSource)).To(Succeed())
+
+		Expect(currentYttSource.Status.FailureMessage).To(BeNil())
+		Expect(currentYttSource.Status.Resources).ToNot(BeEmpty())
+		Expect(currentYttSource.Status.ObservedGeneration).To(Equal(currentYttSource.Generation))
+		Expect(currentYttSource.Status.LastAttemptedRevision).ToNot(BeEmpty())
+		Expect(currentYttSource.Status.LastAppliedRevision).To(Equal(currentYttSource.Status.LastAttemptedRevision))
+
+		for _, conditionType := range []string{extensionv1beta1.ReadyCondition, extensionv1beta1.SourceReadyCondition,
+			extensionv1beta1.TemplateRenderedCondition} {
+
+			Expect(apimeta.IsStatusConditionTrue(currentYttSource.Status.Conditions, conditionType)).To(BeTrue())
+		}
+		Expect(apimeta.FindStatusCondition(currentYttSource.Status.Conditions,
+			extensionv1beta1.StalledCondition)).To(BeNil())
+	})
+
+	It("Reconcile sets Ready and Stalled conditions when path is missing", func() {
+		configMap := getYttConfigMap()
+		yttSource := getYttSourceForConfigMap(configMap, randomString())
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource).
+			WithObjects(configMap, yttSource).Build()
+
+		reconciler := getYttSourceReconciler(c)
+		_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+		})
+		// Stalled errors are not retried
+		Expect(err).To(BeNil())
+
+		currentYttSource := &extensionv1beta1.YttSource{}
+		Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+			currentYttSource)).To(Succeed())
+
+		Expect(currentYttSource.Status.FailureMessage).ToNot(BeNil())
+		Expect(apimeta.IsStatusConditionTrue(currentYttSource.Status.Conditions,
+			extensionv1beta1.SourceReadyCondition)).To(BeTrue())
+
+		ready := apimeta.FindStatusCondition(currentYttSource.Status.Conditions, extensionv1beta1.ReadyCondition)
+		Expect(ready).ToNot(BeNil())
+		Expect(ready.Status).To(Equal(metav1.ConditionFalse))
+		Expect(ready.Reason).To(Equal(extensionv1beta1.PathNotFoundReason))
+
+		Expect(apimeta.IsStatusConditionTrue(currentYttSource.Status.Conditions,
+			extensionv1beta1.StalledCondition)).To(BeTrue())
+	})
+
+	It("Reconcile sets SourceNotFound reason when referenced ConfigMap does not exist", func() {
+		yttSource := getYttSourceForConfigMap(getYttConfigMap(), "./")
+
+		initObjects := []client.Object{yttSource}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource).
+			WithObjects(initObjects...).Build()
+
+		reconciler := getYttSourceReconciler(c)
+		_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+		})
+		Expect(err).ToNot(BeNil())
+
+		currentYttSource := &extensionv1beta1.YttSource{}
+		Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+			currentYttSource)).To(Succeed())
+
+		sourceReady := apimeta.FindStatusCondition(currentYttSource.Status.Conditions,
+			extensionv1beta1.SourceReadyCondition)
+		Expect(sourceReady).ToNot(BeNil())
+		Expect(sourceReady.Status).To(Equal(metav1.ConditionFalse))
+		Expect(sourceReady.Reason).To(Equal(extensionv1beta1.SourceNotFoundReason))
+		Expect(apimeta.IsStatusConditionFalse(currentYttSource.Status.Conditions,
+			extensionv1beta1.ReadyCondition)).To(BeTrue())
+	})
+	It("Reconcile refuses sources in another namespace when cross-namespace references are not allowed", func() {
+		configMap := getYttConfigMap()
+		yttSource := getYttSourceForConfigMap(configMap, "./")
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource).
+			WithObjects(yttSource, configMap).Build()
+
+		// Webhook enforces the same policy, but it can be disabled
+		reconciler := getYttSourceReconciler(c)
+		reconciler.AllowCrossNamespaceReferences = false
+		_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+		})
+		Expect(err).To(BeNil())
+
+		currentYttSource := &extensionv1beta1.YttSource{}
+		Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+			currentYttSource)).To(Succeed())
+		Expect(currentYttSource.Status.FailureMessage).ToNot(BeNil())
+		Expect(*currentYttSource.Status.FailureMessage).To(ContainSubstring("cross-namespace reference is not allowed"))
+		Expect(currentYttSource.Status.Resources).To(BeEmpty())
+		Expect(apimeta.IsStatusConditionTrue(currentYttSource.Status.Conditions,
+			extensionv1beta1.StalledCondition)).To(BeTrue())
+	})
+})
+
+var _ = Describe("YttSource Controller: keep last output on failure", func() {
+	It("Reconcile keeps last output and revision on failure by default", func() {
+		configMap := getYttConfigMap()
+		yttSource := getYttSourceForConfigMap(configMap, randomString())
+		previousOutput := randomString()
+		previousRevision := randomString()
+		yttSource.Status.Resources = previousOutput
+		yttSource.Status.LastAppliedRevision = previousRevision
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource).
+			WithObjects(configMap, yttSource).Build()
+
+		reconciler := getYttSourceReconciler(c)
+		_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+		})
+		Expect(err).To(BeNil())
+
+		currentYttSource := &extensionv1beta1.YttSource{}
+		Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+			currentYttSource)).To(Succeed())
+		Expect(currentYttSource.Status.FailureMessage).ToNot(BeNil())
+		Expect(currentYttSource.Status.Resources).To(Equal(previousOutput))
+		Expect(currentYttSource.Status.LastAppliedRevision).To(Equal(previousRevision))
+		Expect(apimeta.IsStatusConditionFalse(currentYttSource.Status.Conditions,
+			extensionv1beta1.ReadyCondition)).To(BeTrue())
+	})
+
+	It("Reconcile clears last output on failure when KeepLastOutputOnFailure is false", func() {
+		configMap := getYttConfigMap()
+		yttSource := getYttSourceForConfigMap(configMap, randomString())
+		keep := false
+		yttSource.Spec.KeepLastOutputOnFailure = &keep
+		yttSource.Status.Resources = randomString()
+		yttSource.Status.LastAppliedRevision = randomString()
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource).
+			WithObjects(configMap, yttSource).Build()
+
+		reconciler := getYttSourceReconciler(c)
+		_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+		})
+		Expect(err).To(BeNil())
+
+		currentYttSource := &extensionv1beta1.YttSource{}
+		Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+			currentYttSource)).To(Succeed())
+		Expect(currentYttSource.Status.FailureMessage).ToNot(BeNil())
+		Expect(currentYttSource.Status.Resources).To(BeEmpty())
+		Expect(currentYttSource.Status.LastAppliedRevision).To(BeEmpty())
+	})
 })
 
+var _ = Describe("YttSource Controller: source not ready", func() {
+	It("Reconcile requeues and keeps last output when Flux source has no artifact", func() {
+		gitRepository := &sourcev1.GitRepository{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      randomString(),
+				Namespace: randomString(),
+			},
+		}
+
+		previousOutput := randomString()
+		yttSource := &extensionv1beta1.YttSource{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      randomString(),
+				Namespace: randomString(),
+			},
+			Spec: extensionv1beta1.YttSourceSpec{
+				Namespace: gitRepository.Namespace,
+				Name:      gitRepository.Name,
+				Kind:      sourcev1.GitRepositoryKind,
+			},
+			Status: extensionv1beta1.YttSourceStatus{
+				Resources: previousOutput,
+			},
+		}
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource).
+			WithObjects(gitRepository, yttSource).Build()
+
+		reconciler := getYttSourceReconciler(c)
+		reconciler.ArtifactRequeueInterval = time.Minute
+		result, err := reconciler.Reconcile(context.TODO(), reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+		})
+		Expect(err).To(BeNil())
+		Expect(result.RequeueAfter).To(Equal(time.Minute))
+
+		currentYttSource := &extensionv1beta1.YttSource{}
+		Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+			currentYttSource)).To(Succeed())
+		Expect(currentYttSource.Status.Resources).To(Equal(previousOutput))
+		Expect(currentYttSource.Status.FailureMessage).ToNot(BeNil())
+
+		sourceReady := apimeta.FindStatusCondition(currentYttSource.Status.Conditions,
+			extensionv1beta1.SourceReadyCondition)
+		Expect(sourceReady).ToNot(BeNil())
+		Expect(sourceReady.Status).To(Equal(metav1.ConditionFalse))
+		Expect(sourceReady.Reason).To(Equal(extensionv1beta1.ArtifactNotReadyReason))
+	})
+
+	It("getArtifactRequeueAfter grows with time source has not been ready", func() {
+		reconciler := getYttSourceReconciler(fake.NewClientBuilder().WithScheme(scheme).Build())
+		reconciler.ArtifactRequeueInterval = 10 * time.Second
+		reconciler.ArtifactRequeueMaxInterval = 5 * time.Minute
+
+		yttSource := &extensionv1beta1.YttSource{}
+		Expect(controllers.GetArtifactRequeueAfter(reconciler, yttSource)).To(Equal(10 * time.Second))
+
+		yttSource.Status.Conditions = []metav1.Condition{
+			{
+				Type:               extensionv1beta1.SourceReadyCondition,
+				Status:             metav1.ConditionFalse,
+				Reason:             extensionv1beta1.ArtifactNotReadyReason,
+				LastTransitionTime: metav1.NewTime(time.Now().Add(-time.Minute)),
+			},
+		}
+		requeueAfter := controllers.GetArtifactRequeueAfter(reconciler, yttSource)
+		Expect(requeueAfter).To(BeNumerically(">=", time.Minute))
+		Expect(requeueAfter).To(BeNumerically("<", 2*time.Minute))
+
+		yttSource.Status.Conditions[0].LastTransitionTime = metav1.NewTime(time.Now().Add(-time.Hour))
+		Expect(controllers.GetArtifactRequeueAfter(reconciler, yttSource)).To(Equal(5 * time.Minute))
+	})
+})
+
+var _ = Describe("YttSource Controller: skip rendering", func() {
+	It("Reconcile skips rendering when inputs have not changed", func() {
+		configMap := getYttConfigMap()
+		yttSource := getYttSourceForConfigMap(configMap, "./")
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource).
+			WithObjects(configMap, yttSource).Build()
+
+		reconciler := getYttSourceReconciler(c)
+		req := reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+		}
+		_, err := reconciler.Reconcile(context.TODO(), req)
+		Expect(err).To(BeNil())
+
+		currentYttSource := &extensionv1beta1.YttSource{}
+		Expect(c.Get(context.TODO(), req.NamespacedName, currentYttSource)).To(Succeed())
+		Expect(currentYttSource.Status.InputDigest).ToNot(BeEmpty())
+		rendered := currentYttSource.Status.Resources
+
+		// If rendering is skipped, Status.Resources is not overwritten
+		marker := randomString()
+		currentYttSource.Status.Resources = marker
+		Expect(c.Status().Update(context.TODO(), currentYttSource)).To(Succeed())
+
+		_, err = reconciler.Reconcile(context.TODO(), req)
+		Expect(err).To(BeNil())
+		Expect(c.Get(context.TODO(), req.NamespacedName, currentYttSource)).To(Succeed())
+		Expect(currentYttSource.Status.Resources).To(Equal(marker))
+
+		// Any change to the referenced source causes ytt to run again
+		currentConfigMap := &corev1.ConfigMap{}
+		Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: configMap.Namespace, Name: configMap.Name},
+			currentConfigMap)).To(Succeed())
+		currentConfigMap.Labels = map[string]string{randomString(): randomString()}
+		Expect(c.Update(context.TODO(), currentConfigMap)).To(Succeed())
+
+		_, err = reconciler.Reconcile(context.TODO(), req)
+		Expect(err).To(BeNil())
+		Expect(c.Get(context.TODO(), req.NamespacedName, currentYttSource)).To(Succeed())
+		Expect(currentYttSource.Status.Resources).To(Equal(rendered))
+	})
+
+	It("getInputFingerprint changes when generation, data values or controller settings change", func() {
+		configMap := getYttConfigMap()
+		yttSource := getYttSourceForConfigMap(configMap, "./")
+		values := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      randomString(),
+				Namespace: yttSource.Namespace,
+			},
+			Data: map[string]string{"values.yaml": "replicas: 1"},
+		}
+		yttSource.Spec.DataValues = &extensionv1beta1.DataValues{
+			ValuesFrom: []extensionv1beta1.ValuesReference{
+				{Kind: string(libsveltosv1beta1.ConfigMapReferencedResourceKind), Name: values.Name},
+			},
+		}
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(configMap, values).Build()
+		reconciler := getYttSourceReconciler(c)
+
+		fingerprint, err := controllers.GetInputFingerprint(reconciler, context.TODO(), yttSource)
+		Expect(err).To(BeNil())
+
+		// Same inputs, same fingerprint
+		Expect(controllers.GetInputFingerprint(reconciler, context.TODO(), yttSource)).To(Equal(fingerprint))
+
+		yttSource.Generation++
+		newFingerprint, err := controllers.GetInputFingerprint(reconciler, context.TODO(), yttSource)
+		Expect(err).To(BeNil())
+		Expect(newFingerprint).ToNot(Equal(fingerprint))
+		fingerprint = newFingerprint
+
+		values.Data["values.yaml"] = "replicas: 2"
+		Expect(c.Update(context.TODO(), values)).To(Succeed())
+		newFingerprint, err = controllers.GetInputFingerprint(reconciler, context.TODO(), yttSource)
+		Expect(err).To(BeNil())
+		Expect(newFingerprint).ToNot(Equal(fingerprint))
+		fingerprint = newFingerprint
+
+		// Controller settings limiting output change the fingerprint too
+		reconciler.MaxOutputSize = 1024
+		newFingerprint, err = controllers.GetInputFingerprint(reconciler, context.TODO(), yttSource)
+		Expect(err).To(BeNil())
+		Expect(newFingerprint).ToNot(Equal(fingerprint))
+	})
+})
+
+var _ = Describe("YttSource Controller: data layout", func() {
+	It("Reconcile treats each key as a file in Files mode", func() {
+		configMap := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      randomString(),
+				Namespace: randomString(),
+			},
+			Data: map[string]string{
+				"deployment.yaml": deploymentTemplate,
+			},
+			BinaryData: map[string][]byte{
+				"_ytt_lib__helpers__labels.star": []byte(labelsLibrary),
+			},
+		}
+		yttSource := getYttSourceForConfigMap(configMap, "./")
+		yttSource.Spec.DataLayout = &extensionv1beta1.DataLayout{Mode: extensionv1beta1.DataLayoutFiles}
+
+		currentYttSource := reconcileYttSource(yttSource, configMap)
+		Expect(currentYttSource.Status.FailureMessage).To(BeNil())
+		Expect(currentYttSource.Status.Resources).To(ContainSubstring("app.kubernetes.io/name: app"))
+	})
+
+	It("Reconcile rejects keys outside of the source directory in Files mode", func() {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      randomString(),
+				Namespace: randomString(),
+			},
+			Type: libsveltosv1beta1.ClusterProfileSecretType,
+			Data: map[string][]byte{
+				"..--deployment.yaml": []byte(deploymentTemplate),
+			},
+		}
+		yttSource := getYttSourceForConfigMap(&corev1.ConfigMap{ObjectMeta: secret.ObjectMeta}, "./")
+		yttSource.Spec.Kind = string(libsveltosv1beta1.SecretReferencedResourceKind)
+		yttSource.Spec.DataLayout = &extensionv1beta1.DataLayout{
+			Mode:          extensionv1beta1.DataLayoutFiles,
+			PathSeparator: "--",
+		}
+
+		currentYttSource := reconcileYttSource(yttSource, secret)
+		Expect(currentYttSource.Status.FailureMessage).ToNot(BeNil())
+		Expect(*currentYttSource.Status.FailureMessage).To(ContainSubstring("outside of destination directory"))
+	})
+
+	It("Reconcile extracts .tar and .zip archives from configured keys", func() {
+		configMap := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      randomString(),
+				Namespace: randomString(),
+			},
+			BinaryData: map[string][]byte{
+				"templates.tar": getTar(map[string]string{"deployment.yaml": deploymentTemplate}),
+				"helpers.zip":   getZip(map[string]string{"_ytt_lib/helpers/labels.star": labelsLibrary}),
+			},
+		}
+		yttSource := getYttSourceForConfigMap(configMap, "./")
+		yttSource.Spec.DataLayout = &extensionv1beta1.DataLayout{
+			ArchiveKeys: []string{"templates.tar", "helpers.zip"},
+		}
+
+		currentYttSource := reconcileYttSource(yttSource, configMap)
+		Expect(currentYttSource.Status.FailureMessage).To(BeNil())
+		Expect(currentYttSource.Status.Resources).To(ContainSubstring("app.kubernetes.io/name: app"))
+	})
+
+	It("extractArchive overwrites files extracted by a previous archive", func() {
+		dest := GinkgoT().TempDir()
+		Expect(controllers.ExtractArchive("first.tar", getTar(map[string]string{"app.yaml": "a: a-long-value"}),
+			dest)).To(Succeed())
+		Expect(controllers.ExtractArchive("second.tar", getTar(map[string]string{"app.yaml": "a: b"}),
+			dest)).To(Succeed())
+
+		content, err := os.ReadFile(filepath.Join(dest, "app.yaml"))
+		Expect(err).To(BeNil())
+		Expect(string(content)).To(Equal("a: b"))
+	})
+
+	It("extractArchive rejects entries outside of the destination directory", func() {
+		parent := GinkgoT().TempDir()
+		dest := filepath.Join(parent, "dest")
+		Expect(os.Mkdir(dest, 0o755)).To(Succeed())
+
+		// dest-evil shares dest as prefix but is a sibling directory
+		for _, archive := range []string{"ytt.tar", "ytt.zip"} {
+			files := map[string]string{"../dest-evil/app.yaml": "a: b"}
+			content := getTar(files)
+			if archive == "ytt.zip" {
+				content = getZip(files)
+			}
+			err := controllers.ExtractArchive(archive, content, dest)
+			Expect(err).ToNot(BeNil())
+			Expect(err.Error()).To(ContainSubstring("outside of destination directory"))
+		}
+		_, err := os.Stat(filepath.Join(parent, "dest-evil"))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("extractArchive fails on unsupported formats", func() {
+		err := controllers.ExtractArchive("ytt.rar", []byte(randomString()), GinkgoT().TempDir())
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("unsupported format"))
+	})
+})
+
+var _ = Describe("YttSource Controller: allowed Secret types", func() {
+	It("Reconcile reports SecretTypeNotAllowed when Secret type is not allowed", func() {
+		secret := getYttSecret(corev1.SecretTypeOpaque)
+		yttSource := getYttSourceForConfigMap(&corev1.ConfigMap{ObjectMeta: secret.ObjectMeta}, "./")
+		yttSource.Spec.Kind = string(libsveltosv1beta1.SecretReferencedResourceKind)
+
+		currentYttSource := reconcileYttSource(yttSource, secret)
+		Expect(currentYttSource.Status.FailureMessage).ToNot(BeNil())
+		Expect(*currentYttSource.Status.FailureMessage).To(ContainSubstring(string(corev1.SecretTypeOpaque)))
+
+		sourceReady := apimeta.FindStatusCondition(currentYttSource.Status.Conditions,
+			extensionv1beta1.SourceReadyCondition)
+		Expect(sourceReady).ToNot(BeNil())
+		Expect(sourceReady.Status).To(Equal(metav1.ConditionFalse))
+		Expect(sourceReady.Reason).To(Equal(extensionv1beta1.SecretTypeNotAllowedReason))
+		Expect(apimeta.IsStatusConditionTrue(currentYttSource.Status.Conditions,
+			extensionv1beta1.StalledCondition)).To(BeTrue())
+	})
+
+	It("Reconcile accepts Secret types allowed by YttSource", func() {
+		secret := getYttSecret(corev1.SecretTypeOpaque)
+		yttSource := getYttSourceForConfigMap(&corev1.ConfigMap{ObjectMeta: secret.ObjectMeta}, "./")
+		yttSource.Spec.Kind = string(libsveltosv1beta1.SecretReferencedResourceKind)
+		yttSource.Spec.AllowedSecretTypes = []corev1.SecretType{corev1.SecretTypeOpaque}
+
+		currentYttSource := reconcileYttSource(yttSource, secret)
+		Expect(currentYttSource.Status.FailureMessage).To(BeNil())
+		Expect(currentYttSource.Status.Resources).ToNot(BeEmpty())
+	})
+
+	It("Reconcile accepts Secret types allowed by the controller", func() {
+		secret := getYttSecret(corev1.SecretTypeOpaque)
+		yttSource := getYttSourceForConfigMap(&corev1.ConfigMap{ObjectMeta: secret.ObjectMeta}, "./")
+		yttSource.Spec.Kind = string(libsveltosv1beta1.SecretReferencedResourceKind)
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource).
+			WithObjects(secret, yttSource).Build()
+
+		reconciler := getYttSourceReconciler(c)
+		reconciler.AllowedSecretTypes = []corev1.SecretType{corev1.SecretTypeOpaque}
+		_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+		})
+		Expect(err).To(BeNil())
+
+		currentYttSource := &extensionv1beta1.YttSource{}
+		Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+			currentYttSource)).To(Succeed())
+		Expect(currentYttSource.Status.FailureMessage).To(BeNil())
+	})
+
+	It("Reconcile reports SecretTypeNotAllowed for data values Secrets", func() {
+		configMap := getYttConfigMap()
+		values := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      randomString(),
+				Namespace: randomString(),
+			},
+			Type: corev1.SecretTypeOpaque,
+			Data: map[string][]byte{"values.yaml": []byte("name: from-secret\n")},
+		}
+		yttSource := getYttSourceForConfigMap(configMap, "./")
+		yttSource.Spec.DataValues = &extensionv1beta1.DataValues{
+			ValuesFrom: []extensionv1beta1.ValuesReference{
+				{
+					Kind:      string(libsveltosv1beta1.SecretReferencedResourceKind),
+					Namespace: values.Namespace,
+					Name:      values.Name,
+				},
+			},
+		}
+
+		currentYttSource := reconcileYttSource(yttSource, configMap, values)
+		ready := apimeta.FindStatusCondition(currentYttSource.Status.Conditions, extensionv1beta1.ReadyCondition)
+		Expect(ready).ToNot(BeNil())
+		Expect(ready.Reason).To(Equal(extensionv1beta1.SecretTypeNotAllowedReason))
+	})
+})
+
+// getYttSecret returns a Secret of type secretType containing test/ytt.tar.gz
+func getYttSecret(secretType corev1.SecretType) *corev1.Secret {
+	configMap := getYttConfigMap()
+	return &corev1.Secret{
+		ObjectMeta: configMap.ObjectMeta,
+		Type:       secretType,
+		Data:       configMap.BinaryData,
+	}
+}
+
+// reconcileYttSource reconciles yttSource once and returns its current version
+func reconcileYttSource(yttSource *extensionv1beta1.YttSource, objects ...client.Object) *extensionv1beta1.YttSource {
+	c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource).
+		WithObjects(append(objects, yttSource)...).Build()
+
+	reconciler := getYttSourceReconciler(c)
+	_, _ = reconciler.Reconcile(context.TODO(), reconcile.Request{
+		NamespacedName: types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+	})
+
+	currentYttSource := &extensionv1beta1.YttSource{}
+	Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+		currentYttSource)).To(Succeed())
+	return currentYttSource
+}
+
+// getTar returns a tar archive containing files
+func getTar(files map[string]string) []byte {
+	var buf bytes.Buffer
+	tarWriter := tar.NewWriter(&buf)
+	for name, content := range files {
+		Expect(tarWriter.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0600,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		})).To(Succeed())
+		_, err := tarWriter.Write([]byte(content))
+		Expect(err).To(BeNil())
+	}
+	Expect(tarWriter.Close()).To(Succeed())
+	return buf.Bytes()
+}
+
+// getZip returns a zip archive containing files
+func getZip(files map[string]string) []byte {
+	var buf bytes.Buffer
+	zipWriter := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zipWriter.Create(name)
+		Expect(err).To(BeNil())
+		_, err = w.Write([]byte(content))
+		Expect(err).To(BeNil())
+	}
+	Expect(zipWriter.Close()).To(Succeed())
+	return buf.Bytes()
+}
+
+// getYttConfigMap returns a ConfigMap containing test/ytt.tar.gz
+func getYttConfigMap() *corev1.ConfigMap {
+	content, err := os.ReadFile("../test/ytt.tar.gz")
+	Expect(err).To(BeNil())
+
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      randomString(),
+			Namespace: randomString(),
+		},
+		BinaryData: map[string][]byte{
+			"ytt.tar.gz": content,
+		},
+	}
+}
+
+func getYttSourceForConfigMap(configMap *corev1.ConfigMap, path string) *extensionv1beta1.YttSource {
+	return &extensionv1beta1.YttSource{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       randomString(),
+			Namespace:  randomString(),
+			Generation: 1,
+		},
+		Spec: extensionv1beta1.YttSourceSpec{
+			Namespace: configMap.Namespace,
+			Name:      configMap.Name,
+			Kind:      string(libsveltosv1beta1.ConfigMapReferencedResourceKind),
+			Path:      path,
+		},
+	}
+}
+
 func createTarGz(dest string) {
 	// Create the test directory and some test files.
 	err := os.MkdirAll("testdata/testdir", 0755)
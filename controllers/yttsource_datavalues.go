@@ -0,0 +1,227 @@
+/*
+Copyright 2024. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	yttcmd "carvel.dev/ytt/pkg/cmd/template"
+	yttfiles "carvel.dev/ytt/pkg/files"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	extensionv1beta1 "github.com/gianlucam76/ytt-controller/api/v1beta1"
+
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+	logs "github.com/projectsveltos/libsveltos/lib/logsettings"
+)
+
+const (
+	inlineDataValuesFile = "inline-data-values.yaml"
+)
+
+// getValuesReference returns the ObjectReference for a ConfigMap/Secret
+// referenced in YttSource DataValues. Unless allowCrossNamespace is set, an error
+// is returned if it is in a namespace different from the YttSource one.
+func getValuesReference(yttSource *extensionv1beta1.YttSource, valuesRef *extensionv1beta1.ValuesReference,
+	allowCrossNamespace bool) (*corev1.ObjectReference, error) {
+
+	namespace, err := getReferenceNamespace(yttSource, valuesRef.Namespace, allowCrossNamespace)
+	if err != nil {
+		return nil, fmt.Errorf("data values %s %s: %w", valuesRef.Kind, valuesRef.Name, err)
+	}
+
+	return &corev1.ObjectReference{
+		APIVersion: getReferenceAPIVersion(valuesRef.Kind),
+		Kind:       valuesRef.Kind,
+		Namespace:  namespace,
+		Name:       valuesRef.Name,
+	}, nil
+}
+
+// getDataValuesReferences returns all ConfigMaps/Secrets referenced in YttSource DataValues.
+func getDataValuesReferences(yttSource *extensionv1beta1.YttSource,
+	allowCrossNamespace bool) ([]corev1.ObjectReference, error) {
+
+	if yttSource.Spec.DataValues == nil {
+		return nil, nil
+	}
+
+	refs := make([]corev1.ObjectReference, len(yttSource.Spec.DataValues.ValuesFrom))
+	for i := range yttSource.Spec.DataValues.ValuesFrom {
+		ref, err := getValuesReference(yttSource, &yttSource.Spec.DataValues.ValuesFrom[i], allowCrossNamespace)
+		if err != nil {
+			return nil, err
+		}
+		refs[i] = *ref
+	}
+
+	return refs, nil
+}
+
+// setDataValues configures ytt data values flags based on YttSource DataValues.
+// Data values files (ValuesFrom and Inline) are kept in memory and handed to ytt
+// via a custom ReadFilesFunc, so nothing is written to the filesystem.
+func setDataValues(ctx context.Context, c client.Client, yttSource *extensionv1beta1.YttSource,
+	allowedSecretTypes []corev1.SecretType, allowCrossNamespace bool, dataValuesFlags *yttcmd.DataValuesFlags,
+	logger logr.Logger) error {
+
+	// equivalent to `--data-value-yaml`
+	dataValuesFlags.KVsFromYAML = []string{}
+
+	dataValues := yttSource.Spec.DataValues
+	if dataValues == nil {
+		return nil
+	}
+
+	valuesFiles := make(map[string][]byte)
+	fileNames := make([]string, 0)
+
+	for i := range dataValues.ValuesFrom {
+		ref, err := getValuesReference(yttSource, &dataValues.ValuesFrom[i], allowCrossNamespace)
+		if err != nil {
+			return err
+		}
+		content, err := getValuesFromReference(ctx, c, ref, &dataValues.ValuesFrom[i], allowedSecretTypes, logger)
+		if err != nil {
+			return err
+		}
+
+		keys := sortedKeys(content)
+		for j := range keys {
+			// ytt considers files whose name contains a path separator as implied and
+			// skips those which are not YAML. Names here never contain one.
+			// The index of the reference keeps names unique: namespace, name and key can
+			// all contain '-', so a-b/c and a/b-c would otherwise map to the same file.
+			fileName := fmt.Sprintf("%d_%s_%s_%s_%s", i, ref.Kind, ref.Namespace, ref.Name, keys[j])
+			valuesFiles[fileName] = content[keys[j]]
+			fileNames = append(fileNames, fileName)
+		}
+	}
+
+	if dataValues.Inline != "" {
+		valuesFiles[inlineDataValuesFile] = []byte(dataValues.Inline)
+		fileNames = append(fileNames, inlineDataValuesFile)
+	}
+
+	// equivalent to `--data-values-file`
+	dataValuesFlags.FromFiles = fileNames
+	dataValuesFlags.ReadFilesFunc = func(path string) ([]*yttfiles.File, error) {
+		content, ok := valuesFiles[path]
+		if !ok {
+			return nil, fmt.Errorf("data values file %s not found", path)
+		}
+		file, err := yttfiles.NewFileFromSource(yttfiles.NewBytesSource(path, content))
+		if err != nil {
+			return nil, err
+		}
+		return []*yttfiles.File{file}, nil
+	}
+
+	// equivalent to `--data-value`
+	dataValuesFlags.KVsFromStrings = asKeyValues(dataValues.Values)
+	// equivalent to `--data-value-yaml`
+	dataValuesFlags.KVsFromYAML = asKeyValues(dataValues.YAMLValues)
+
+	return nil
+}
+
+// getValuesFromReference returns the content of the referenced ConfigMap/Secret.
+// If a key is specified, only that entry is returned.
+func getValuesFromReference(ctx context.Context, c client.Client, ref *corev1.ObjectReference,
+	valuesRef *extensionv1beta1.ValuesReference, allowedSecretTypes []corev1.SecretType,
+	logger logr.Logger) (map[string][]byte, error) {
+
+	var content map[string][]byte
+	switch ref.Kind {
+	case string(libsveltosv1beta1.ConfigMapReferencedResourceKind):
+		configMap, err := getConfigMap(ctx, c, types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name})
+		if err != nil {
+			if apierrors.IsNotFound(err) && valuesRef.Optional {
+				logger.V(logs.LogDebug).Info(fmt.Sprintf("optional ConfigMap %s/%s not found", ref.Namespace, ref.Name))
+				return nil, nil
+			}
+			return nil, err
+		}
+		content = make(map[string][]byte, len(configMap.Data)+len(configMap.BinaryData))
+		for k := range configMap.Data {
+			content[k] = []byte(configMap.Data[k])
+		}
+		for k := range configMap.BinaryData {
+			content[k] = configMap.BinaryData[k]
+		}
+	case string(libsveltosv1beta1.SecretReferencedResourceKind):
+		secret, err := getSecret(ctx, c, types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name},
+			allowedSecretTypes)
+		if err != nil {
+			if apierrors.IsNotFound(err) && valuesRef.Optional {
+				logger.V(logs.LogDebug).Info(fmt.Sprintf("optional Secret %s/%s not found", ref.Namespace, ref.Name))
+				return nil, nil
+			}
+			return nil, err
+		}
+		content = secret.Data
+	default:
+		return nil, fmt.Errorf("data values kind %s not supported", ref.Kind)
+	}
+
+	if valuesRef.Key == "" {
+		return content, nil
+	}
+
+	value, ok := content[valuesRef.Key]
+	if !ok {
+		if valuesRef.Optional {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("key %s not found in %s %s/%s", valuesRef.Key, ref.Kind, ref.Namespace, ref.Name)
+	}
+
+	return map[string][]byte{valuesRef.Key: value}, nil
+}
+
+// asKeyValues converts a map to a list of key=value strings, sorted by key.
+func asKeyValues(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	result := make([]string, len(keys))
+	for i := range keys {
+		result[i] = fmt.Sprintf("%s=%s", keys[i], values[keys[i]])
+	}
+
+	return result
+}
+
+// sortedKeys returns the keys of a map in alphabetical order.
+func sortedKeys(content map[string][]byte) []string {
+	keys := make([]string, 0, len(content))
+	for k := range content {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
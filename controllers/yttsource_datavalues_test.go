@@ -0,0 +1,246 @@
+/*
+Copyright 2024. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"context"
+
+	yttcmd "carvel.dev/ytt/pkg/cmd/template"
+	yttui "carvel.dev/ytt/pkg/cmd/ui"
+	yttfiles "carvel.dev/ytt/pkg/files"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/textlogger"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	extensionv1beta1 "github.com/gianlucam76/ytt-controller/api/v1beta1"
+	"github.com/gianlucam76/ytt-controller/controllers"
+
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+)
+
+const (
+	dataValuesSchema = `#@data/values-schema
+---
+app:
+  name: ""
+  replicas: 1
+  env: ""
+  region: ""
+`
+
+	dataValuesTemplate = `#@ load("@ytt:data", "data")
+---
+name: #@ data.values.app.name
+replicas: #@ data.values.app.replicas
+env: #@ data.values.app.env
+region: #@ data.values.app.region
+`
+)
+
+var _ = Describe("YttSource DataValues", func() {
+	It("setDataValues passes inline, key/value and referenced data values to ytt", func() {
+		configMap := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      randomString(),
+				Namespace: randomString(),
+			},
+			Data: map[string]string{
+				"values.yaml": "app:\n  name: from-configmap\n  region: eu-west-1\n",
+			},
+		}
+
+		yttSource := &extensionv1beta1.YttSource{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      randomString(),
+				Namespace: configMap.Namespace,
+			},
+			Spec: extensionv1beta1.YttSourceSpec{
+				Namespace: randomString(),
+				Name:      randomString(),
+				Kind:      string(libsveltosv1beta1.ConfigMapReferencedResourceKind),
+				DataValues: &extensionv1beta1.DataValues{
+					// Inline is applied after ValuesFrom so it overrides app.name
+					Inline: "app:\n  name: from-inline\n",
+					Values: map[string]string{
+						"app.env": "staging",
+					},
+					YAMLValues: map[string]string{
+						"app.replicas": "3",
+					},
+					ValuesFrom: []extensionv1beta1.ValuesReference{
+						{
+							Kind: string(libsveltosv1beta1.ConfigMapReferencedResourceKind),
+							Name: configMap.Name,
+						},
+					},
+				},
+			},
+		}
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(configMap).Build()
+
+		templatingOptions := yttcmd.NewOptions()
+		Expect(controllers.SetDataValues(context.TODO(), c, yttSource, nil, true, &templatingOptions.DataValuesFlags,
+			textlogger.NewLogger(textlogger.NewConfig()))).To(Succeed())
+
+		output := templatingOptions.RunWithFiles(
+			yttcmd.Input{Files: []*yttfiles.File{
+				yttfiles.MustNewFileFromSource(yttfiles.NewBytesSource("schema.yaml", []byte(dataValuesSchema))),
+				yttfiles.MustNewFileFromSource(yttfiles.NewBytesSource("template.yaml", []byte(dataValuesTemplate))),
+			}},
+			yttui.NewCustomWriterTTY(false, GinkgoWriter, GinkgoWriter))
+		Expect(output.Err).To(BeNil())
+
+		bs, err := output.DocSet.AsBytes()
+		Expect(err).To(BeNil())
+		Expect(string(bs)).To(ContainSubstring("name: from-inline"))
+		Expect(string(bs)).To(ContainSubstring("replicas: 3"))
+		Expect(string(bs)).To(ContainSubstring("env: staging"))
+		Expect(string(bs)).To(ContainSubstring("region: eu-west-1"))
+	})
+
+	It("setDataValues fails when a referenced ConfigMap is missing unless optional", func() {
+		yttSource := &extensionv1beta1.YttSource{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      randomString(),
+				Namespace: randomString(),
+			},
+			Spec: extensionv1beta1.YttSourceSpec{
+				DataValues: &extensionv1beta1.DataValues{
+					ValuesFrom: []extensionv1beta1.ValuesReference{
+						{
+							Kind: string(libsveltosv1beta1.ConfigMapReferencedResourceKind),
+							Name: randomString(),
+						},
+					},
+				},
+			},
+		}
+
+		initObjects := []client.Object{}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(initObjects...).Build()
+
+		templatingOptions := yttcmd.NewOptions()
+		Expect(controllers.SetDataValues(context.TODO(), c, yttSource, nil, true, &templatingOptions.DataValuesFlags,
+			textlogger.NewLogger(textlogger.NewConfig()))).ToNot(Succeed())
+
+		yttSource.Spec.DataValues.ValuesFrom[0].Optional = true
+		Expect(controllers.SetDataValues(context.TODO(), c, yttSource, nil, true, &templatingOptions.DataValuesFlags,
+			textlogger.NewLogger(textlogger.NewConfig()))).To(Succeed())
+		Expect(templatingOptions.DataValuesFlags.FromFiles).To(BeEmpty())
+	})
+
+	It("setDataValues passes data values of references with colliding names as distinct files", func() {
+		// a-b/c and a/b-c used to be mapped to the same data values file
+		first := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "a-b", Name: "c"},
+			Data:       map[string]string{"values.yaml": "app:\n  name: first\n  env: prod\n"},
+		}
+		second := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "a", Name: "b-c"},
+			Data:       map[string]string{"values.yaml": "app:\n  name: second\n"},
+		}
+
+		yttSource := &extensionv1beta1.YttSource{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      randomString(),
+				Namespace: randomString(),
+			},
+			Spec: extensionv1beta1.YttSourceSpec{
+				DataValues: &extensionv1beta1.DataValues{
+					ValuesFrom: []extensionv1beta1.ValuesReference{
+						{
+							Kind:      string(libsveltosv1beta1.ConfigMapReferencedResourceKind),
+							Namespace: first.Namespace,
+							Name:      first.Name,
+						},
+						{
+							Kind:      string(libsveltosv1beta1.ConfigMapReferencedResourceKind),
+							Namespace: second.Namespace,
+							Name:      second.Name,
+						},
+					},
+				},
+			},
+		}
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(first, second).Build()
+
+		templatingOptions := yttcmd.NewOptions()
+		Expect(controllers.SetDataValues(context.TODO(), c, yttSource, nil, true, &templatingOptions.DataValuesFlags,
+			textlogger.NewLogger(textlogger.NewConfig()))).To(Succeed())
+		Expect(templatingOptions.DataValuesFlags.FromFiles).To(HaveLen(2))
+		Expect(templatingOptions.DataValuesFlags.FromFiles[0]).ToNot(Equal(templatingOptions.DataValuesFlags.FromFiles[1]))
+
+		output := templatingOptions.RunWithFiles(
+			yttcmd.Input{Files: []*yttfiles.File{
+				yttfiles.MustNewFileFromSource(yttfiles.NewBytesSource("schema.yaml", []byte(dataValuesSchema))),
+				yttfiles.MustNewFileFromSource(yttfiles.NewBytesSource("template.yaml", []byte(dataValuesTemplate))),
+			}},
+			yttui.NewCustomWriterTTY(false, GinkgoWriter, GinkgoWriter))
+		Expect(output.Err).To(BeNil())
+
+		bs, err := output.DocSet.AsBytes()
+		Expect(err).To(BeNil())
+		// Both files are applied, in order
+		Expect(string(bs)).To(ContainSubstring("name: second"))
+		Expect(string(bs)).To(ContainSubstring("env: prod"))
+	})
+
+	It("getDataValuesReferences defaults namespace to YttSource namespace", func() {
+		secretName := randomString()
+		yttSource := &extensionv1beta1.YttSource{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      randomString(),
+				Namespace: randomString(),
+			},
+			Spec: extensionv1beta1.YttSourceSpec{
+				DataValues: &extensionv1beta1.DataValues{
+					ValuesFrom: []extensionv1beta1.ValuesReference{
+						{
+							Kind: string(libsveltosv1beta1.SecretReferencedResourceKind),
+							Name: secretName,
+						},
+					},
+				},
+			},
+		}
+
+		refs, err := controllers.GetDataValuesReferences(yttSource, false)
+		Expect(err).To(BeNil())
+		Expect(refs).To(HaveLen(1))
+		Expect(refs[0]).To(Equal(corev1.ObjectReference{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       string(libsveltosv1beta1.SecretReferencedResourceKind),
+			Namespace:  yttSource.Namespace,
+			Name:       secretName,
+		}))
+
+		// Another namespace is only accepted when cross-namespace references are allowed
+		yttSource.Spec.DataValues.ValuesFrom[0].Namespace = randomString()
+		_, err = controllers.GetDataValuesReferences(yttSource, false)
+		Expect(err).ToNot(BeNil())
+		refs, err = controllers.GetDataValuesReferences(yttSource, true)
+		Expect(err).To(BeNil())
+		Expect(refs[0].Namespace).To(Equal(yttSource.Spec.DataValues.ValuesFrom[0].Namespace))
+	})
+})
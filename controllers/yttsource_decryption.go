@@ -0,0 +1,327 @@
+/*
+Copyright 2024. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/aes"
+	"github.com/getsops/sops/v3/age"
+	"github.com/getsops/sops/v3/config"
+	"github.com/getsops/sops/v3/keyservice"
+	sopsjson "github.com/getsops/sops/v3/stores/json"
+	sopsyaml "github.com/getsops/sops/v3/stores/yaml"
+	"google.golang.org/grpc"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	extensionv1beta1 "github.com/gianlucam76/ytt-controller/api/v1beta1"
+
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+)
+
+var (
+	// errDecryptionFailed is returned when a SOPS-encrypted file cannot be decrypted
+	errDecryptionFailed = errors.New("decryption failed")
+
+	// defaultDecryptionSuffixes are the suffixes of the files decrypted when
+	// neither Suffixes nor Files is set
+	defaultDecryptionSuffixes = []string{".sops.yaml", ".sops.yml", ".sops.json"}
+)
+
+// sopsDecryptor decrypts SOPS-encrypted files with the keys contained in the
+// decryption Secret referenced by a YttSource.
+type sopsDecryptor struct {
+	keyService *sopsKeyService
+	suffixes   []string
+	files      map[string]bool
+}
+
+// sopsKeyService is a keyservice.KeyServiceClient decrypting SOPS data keys with
+// the age identities and PGP keys read from the decryption Secret only. Unlike the
+// SOPS local key service, it never looks for keys in the controller environment.
+type sopsKeyService struct {
+	ageIdentities age.ParsedIdentities
+	pgpKeyRing    openpgp.EntityList
+}
+
+// getDecryptionSecretReference returns the reference to the Secret containing the
+// decryption keys, or nil if YttSource does not require decryption. Unless
+// allowCrossNamespace is set, an error is returned if the Secret is in a namespace
+// different from the YttSource one.
+func getDecryptionSecretReference(yttSource *extensionv1beta1.YttSource,
+	allowCrossNamespace bool) (*corev1.ObjectReference, error) {
+
+	if yttSource.Spec.Decryption == nil {
+		return nil, nil
+	}
+
+	namespace, err := getReferenceNamespace(yttSource, yttSource.Spec.Decryption.SecretRef.Namespace,
+		allowCrossNamespace)
+	if err != nil {
+		return nil, fmt.Errorf("decryption Secret %s: %w", yttSource.Spec.Decryption.SecretRef.Name, err)
+	}
+
+	kind := string(libsveltosv1beta1.SecretReferencedResourceKind)
+	return &corev1.ObjectReference{
+		APIVersion: getReferenceAPIVersion(kind),
+		Kind:       kind,
+		Namespace:  namespace,
+		Name:       yttSource.Spec.Decryption.SecretRef.Name,
+	}, nil
+}
+
+// getSOPSDecryptor returns the decryptor for the files of YttSource, or nil if
+// YttSource does not require decryption. The decryption Secret must be of one of
+// allowedSecretTypes.
+func getSOPSDecryptor(ctx context.Context, c client.Client, yttSource *extensionv1beta1.YttSource,
+	allowedSecretTypes []corev1.SecretType, allowCrossNamespace bool) (*sopsDecryptor, error) {
+
+	ref, err := getDecryptionSecretReference(yttSource, allowCrossNamespace)
+	if err != nil || ref == nil {
+		return nil, err
+	}
+
+	secret, err := getSecret(ctx, c, types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name},
+		allowedSecretTypes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get decryption Secret %s/%s: %w", ref.Namespace, ref.Name, err)
+	}
+
+	keyService, err := newSOPSKeyService(secret)
+	if err != nil {
+		return nil, fmt.Errorf("%w: decryption Secret %s/%s: %w", errDecryptionFailed,
+			ref.Namespace, ref.Name, err)
+	}
+
+	decryption := yttSource.Spec.Decryption
+	decryptor := &sopsDecryptor{
+		keyService: keyService,
+		suffixes:   decryption.Suffixes,
+		files:      make(map[string]bool, len(decryption.Files)),
+	}
+	for i := range decryption.Files {
+		decryptor.files[decryption.Files[i]] = true
+	}
+	if len(decryption.Suffixes) == 0 && len(decryption.Files) == 0 {
+		decryptor.suffixes = defaultDecryptionSuffixes
+	}
+
+	return decryptor, nil
+}
+
+// newSOPSKeyService parses the age identities and PGP keys contained in secret
+func newSOPSKeyService(secret *corev1.Secret) (*sopsKeyService, error) {
+	keyService := &sopsKeyService{}
+
+	// Sort keys so errors are reported consistently
+	keys := make([]string, 0, len(secret.Data))
+	for key := range secret.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		switch {
+		case strings.HasSuffix(key, extensionv1beta1.AgeKeySuffix):
+			if err := keyService.ageIdentities.Import(string(secret.Data[key])); err != nil {
+				return nil, fmt.Errorf("key %s: %w", key, err)
+			}
+		case strings.HasSuffix(key, extensionv1beta1.PGPKeySuffix):
+			entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(secret.Data[key]))
+			if err != nil {
+				return nil, fmt.Errorf("key %s: failed to read PGP key: %w", key, err)
+			}
+			keyService.pgpKeyRing = append(keyService.pgpKeyRing, entities...)
+		}
+	}
+
+	if len(keyService.ageIdentities) == 0 && len(keyService.pgpKeyRing) == 0 {
+		return nil, fmt.Errorf("no key ending with %s or %s found", extensionv1beta1.AgeKeySuffix,
+			extensionv1beta1.PGPKeySuffix)
+	}
+
+	return keyService, nil
+}
+
+// Decrypt decrypts a SOPS data key encrypted with either an age or a PGP key
+func (s *sopsKeyService) Decrypt(_ context.Context, req *keyservice.DecryptRequest,
+	_ ...grpc.CallOption) (*keyservice.DecryptResponse, error) {
+
+	var plaintext []byte
+	var err error
+	switch k := req.Key.KeyType.(type) {
+	case *keyservice.Key_AgeKey:
+		plaintext, err = s.decryptWithAge(k.AgeKey, req.Ciphertext)
+	case *keyservice.Key_PgpKey:
+		plaintext, err = s.decryptWithPGP(req.Ciphertext)
+	default:
+		err = fmt.Errorf("unsupported key type %T", k)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &keyservice.DecryptResponse{Plaintext: plaintext}, nil
+}
+
+// Encrypt is not supported: the controller only decrypts files
+func (s *sopsKeyService) Encrypt(_ context.Context, _ *keyservice.EncryptRequest,
+	_ ...grpc.CallOption) (*keyservice.EncryptResponse, error) {
+
+	return nil, errors.New("encryption is not supported")
+}
+
+func (s *sopsKeyService) decryptWithAge(key *keyservice.AgeKey, ciphertext []byte) ([]byte, error) {
+	// Without identities, age would look for keys in the controller environment
+	if len(s.ageIdentities) == 0 {
+		return nil, errors.New("no age identity available")
+	}
+
+	masterKey := &age.MasterKey{
+		Recipient:    key.Recipient,
+		EncryptedKey: string(ciphertext),
+	}
+	s.ageIdentities.ApplyToMasterKey(masterKey)
+	return masterKey.Decrypt()
+}
+
+func (s *sopsKeyService) decryptWithPGP(ciphertext []byte) ([]byte, error) {
+	if len(s.pgpKeyRing) == 0 {
+		return nil, errors.New("no PGP key available")
+	}
+
+	block, err := armor.Decode(bytes.NewReader(ciphertext))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode PGP message: %w", err)
+	}
+	md, err := openpgp.ReadMessage(block.Body, s.pgpKeyRing, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PGP message: %w", err)
+	}
+	return io.ReadAll(md.UnverifiedBody)
+}
+
+// matches returns true if the file with given name, relative to the root of
+// the ytt input, must be decrypted
+func (d *sopsDecryptor) matches(name string) bool {
+	if d.files[name] {
+		return true
+	}
+
+	for i := range d.suffixes {
+		if strings.HasSuffix(name, d.suffixes[i]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// decrypt returns the plaintext of the SOPS-encrypted file with given name.
+// JSON files are emitted as JSON, any other file as YAML.
+func (d *sopsDecryptor) decrypt(name string, content []byte) ([]byte, error) {
+	plaintext, err := d.decryptFile(name, content)
+	if err != nil {
+		return nil, fmt.Errorf("%w: file %s: %w", errDecryptionFailed, name, err)
+	}
+	return plaintext, nil
+}
+
+func (d *sopsDecryptor) decryptFile(name string, content []byte) ([]byte, error) {
+	if strings.HasSuffix(name, ".json") {
+		store := sopsjson.NewStore(&config.JSONStoreConfig{})
+		branches, err := d.decryptWithStore(store, content)
+		if err != nil {
+			return nil, err
+		}
+		return store.EmitPlainFile(branches)
+	}
+
+	store := sopsyaml.NewStore(&config.YAMLStoreConfig{})
+	branches, err := d.decryptWithStore(store, content)
+	if err != nil {
+		return nil, err
+	}
+	return emitYAML(store, branches)
+}
+
+func (d *sopsDecryptor) decryptWithStore(store sops.Store, content []byte) (sops.TreeBranches, error) {
+	tree, err := store.LoadEncryptedFile(content)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := tree.Metadata.GetDataKeyWithKeyServices([]keyservice.KeyServiceClient{d.keyService}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	cipher := aes.NewCipher()
+	mac, err := tree.Decrypt(key, cipher)
+	if err != nil {
+		return nil, err
+	}
+
+	originalMac, err := cipher.Decrypt(tree.Metadata.MessageAuthenticationCode, key,
+		tree.Metadata.LastModified.Format(time.RFC3339))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt original mac: %w", err)
+	}
+	if originalMac != mac {
+		return nil, errors.New("failed to verify data integrity: mac mismatch")
+	}
+
+	return tree.Branches, nil
+}
+
+// emitYAML returns the YAML documents contained in branches. SOPS attaches comments
+// preceding a document to its first item: those comments are placed back before the
+// document start, so ytt annotations such as #@data/values keep applying to the document.
+func emitYAML(store *sopsyaml.Store, branches sops.TreeBranches) ([]byte, error) {
+	var b bytes.Buffer
+	for _, branch := range branches {
+		i := 0
+		for ; i < len(branch); i++ {
+			comment, ok := branch[i].Key.(sops.Comment)
+			if !ok || comment.Inline {
+				break
+			}
+			fmt.Fprintf(&b, "#%s\n", comment.Value)
+		}
+
+		document, err := store.EmitPlainFile(sops.TreeBranches{branch[i:]})
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString("---\n")
+		b.Write(document)
+	}
+
+	return b.Bytes(), nil
+}
@@ -0,0 +1,315 @@
+/*
+Copyright 2024. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"time"
+
+	"filippo.io/age"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/aes"
+	sopsage "github.com/getsops/sops/v3/age"
+	"github.com/getsops/sops/v3/config"
+	"github.com/getsops/sops/v3/keys"
+	"github.com/getsops/sops/v3/pgp"
+	sopsyaml "github.com/getsops/sops/v3/stores/yaml"
+	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	extensionv1beta1 "github.com/gianlucam76/ytt-controller/api/v1beta1"
+
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+)
+
+const (
+	secretTemplate = `#@ load("@ytt:data", "data")
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: credentials
+stringData:
+  password: #@ data.values.password
+`
+
+	secretValues = `#@data/values
+---
+password: s3cr3t
+`
+)
+
+var _ = Describe("YttSource Controller: decryption", func() {
+	It("Reconcile decrypts files encrypted with age", func() {
+		identity, err := age.GenerateX25519Identity()
+		Expect(err).To(BeNil())
+
+		encrypted := encryptWithSOPS(secretValues, getAgeMasterKey(identity.Recipient().String()))
+		yttSource, objects := getYttSourceWithEncryptedValues("values.sops.yaml", encrypted,
+			"age.agekey", []byte(identity.String()))
+
+		currentYttSource := reconcileYttSource(yttSource, objects...)
+		Expect(currentYttSource.Status.FailureMessage).To(BeNil())
+		Expect(currentYttSource.Status.Resources).To(ContainSubstring("password: s3cr3t"))
+	})
+
+	It("Reconcile decrypts files encrypted with PGP", func() {
+		entity, err := openpgp.NewEntity("ytt", "", "ytt@projectsveltos.io", nil)
+		Expect(err).To(BeNil())
+
+		var privateKey bytes.Buffer
+		w, err := armor.Encode(&privateKey, openpgp.PrivateKeyType, nil)
+		Expect(err).To(BeNil())
+		Expect(entity.SerializePrivate(w, nil)).To(Succeed())
+		Expect(w.Close()).To(Succeed())
+
+		encrypted := encryptWithSOPS(secretValues, getPGPMasterKey(entity))
+		yttSource, objects := getYttSourceWithEncryptedValues("values.sops.yaml", encrypted,
+			"pgp.asc", privateKey.Bytes())
+
+		currentYttSource := reconcileYttSource(yttSource, objects...)
+		Expect(currentYttSource.Status.FailureMessage).To(BeNil())
+		Expect(currentYttSource.Status.Resources).To(ContainSubstring("password: s3cr3t"))
+	})
+
+	It("Reconcile decrypts files listed in Files", func() {
+		identity, err := age.GenerateX25519Identity()
+		Expect(err).To(BeNil())
+
+		encrypted := encryptWithSOPS(secretValues, getAgeMasterKey(identity.Recipient().String()))
+		yttSource, objects := getYttSourceWithEncryptedValues("values.yaml", encrypted,
+			"age.agekey", []byte(identity.String()))
+		yttSource.Spec.Decryption.Files = []string{"values.yaml"}
+
+		currentYttSource := reconcileYttSource(yttSource, objects...)
+		Expect(currentYttSource.Status.FailureMessage).To(BeNil())
+		Expect(currentYttSource.Status.Resources).To(ContainSubstring("password: s3cr3t"))
+	})
+
+	It("Reconcile reports DecryptionFailed when keys do not match", func() {
+		identity, err := age.GenerateX25519Identity()
+		Expect(err).To(BeNil())
+		otherIdentity, err := age.GenerateX25519Identity()
+		Expect(err).To(BeNil())
+
+		encrypted := encryptWithSOPS(secretValues, getAgeMasterKey(identity.Recipient().String()))
+		yttSource, objects := getYttSourceWithEncryptedValues("values.sops.yaml", encrypted,
+			"age.agekey", []byte(otherIdentity.String()))
+
+		currentYttSource := reconcileYttSource(yttSource, objects...)
+		Expect(currentYttSource.Status.FailureMessage).ToNot(BeNil())
+		Expect(currentYttSource.Status.Resources).To(BeEmpty())
+
+		templateRendered := apimeta.FindStatusCondition(currentYttSource.Status.Conditions,
+			extensionv1beta1.TemplateRenderedCondition)
+		Expect(templateRendered).ToNot(BeNil())
+		Expect(templateRendered.Reason).To(Equal(extensionv1beta1.DecryptionFailedReason))
+		Expect(apimeta.IsStatusConditionTrue(currentYttSource.Status.Conditions,
+			extensionv1beta1.StalledCondition)).To(BeTrue())
+	})
+
+	It("Reconcile refuses decryption Secrets with a type which is not allowed", func() {
+		identity, err := age.GenerateX25519Identity()
+		Expect(err).To(BeNil())
+
+		encrypted := encryptWithSOPS(secretValues, getAgeMasterKey(identity.Recipient().String()))
+		yttSource, objects := getYttSourceWithEncryptedValues("values.sops.yaml", encrypted,
+			"age.agekey", []byte(identity.String()))
+		objects[1].(*corev1.Secret).Type = corev1.SecretTypeOpaque
+
+		currentYttSource := reconcileYttSource(yttSource, objects...)
+		Expect(currentYttSource.Status.FailureMessage).ToNot(BeNil())
+		Expect(currentYttSource.Status.Resources).To(BeEmpty())
+
+		templateRendered := apimeta.FindStatusCondition(currentYttSource.Status.Conditions,
+			extensionv1beta1.TemplateRenderedCondition)
+		Expect(templateRendered).ToNot(BeNil())
+		Expect(templateRendered.Reason).To(Equal(extensionv1beta1.SecretTypeNotAllowedReason))
+	})
+
+	It("Reconcile refuses decryption Secrets in another namespace when cross-namespace references are not allowed",
+		func() {
+			identity, err := age.GenerateX25519Identity()
+			Expect(err).To(BeNil())
+
+			encrypted := encryptWithSOPS(secretValues, getAgeMasterKey(identity.Recipient().String()))
+			yttSource, objects := getYttSourceWithEncryptedValues("values.sops.yaml", encrypted,
+				"age.agekey", []byte(identity.String()))
+			// Source is in the YttSource namespace, decryption Secret is not
+			yttSource.Namespace = objects[0].GetNamespace()
+			secret := objects[1].(*corev1.Secret)
+			secret.Namespace = randomString()
+			yttSource.Spec.Decryption.SecretRef.Namespace = secret.Namespace
+
+			c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource).
+				WithObjects(append(objects, yttSource)...).Build()
+			reconciler := getYttSourceReconciler(c)
+			reconciler.AllowCrossNamespaceReferences = false
+
+			_, currentYttSource := reconcileYttSourceWithClient(c, reconciler, yttSource)
+			Expect(currentYttSource.Status.FailureMessage).ToNot(BeNil())
+			Expect(*currentYttSource.Status.FailureMessage).To(ContainSubstring("decryption Secret"))
+			Expect(*currentYttSource.Status.FailureMessage).To(ContainSubstring("cross-namespace reference is not allowed"))
+
+			// Same YttSource is accepted when cross-namespace references are allowed
+			reconciler.AllowCrossNamespaceReferences = true
+			_, currentYttSource = reconcileYttSourceWithClient(c, reconciler, currentYttSource)
+			Expect(currentYttSource.Status.FailureMessage).To(BeNil())
+			Expect(currentYttSource.Status.Resources).To(ContainSubstring("password: s3cr3t"))
+		})
+
+	It("Reconcile reports DecryptionFailed when decryption Secret does not exist", func() {
+		identity, err := age.GenerateX25519Identity()
+		Expect(err).To(BeNil())
+
+		encrypted := encryptWithSOPS(secretValues, getAgeMasterKey(identity.Recipient().String()))
+		yttSource, objects := getYttSourceWithEncryptedValues("values.sops.yaml", encrypted,
+			"age.agekey", []byte(identity.String()))
+
+		// Only pass the ConfigMap containing ytt files
+		currentYttSource := reconcileYttSource(yttSource, objects[0])
+		ready := apimeta.FindStatusCondition(currentYttSource.Status.Conditions, extensionv1beta1.ReadyCondition)
+		Expect(ready).ToNot(BeNil())
+		Expect(ready.Reason).To(Equal(extensionv1beta1.DecryptionFailedReason))
+		Expect(apimeta.IsStatusConditionTrue(currentYttSource.Status.Conditions,
+			extensionv1beta1.StalledCondition)).To(BeFalse())
+	})
+})
+
+// getAgeMasterKey returns an age master key for recipient
+func getAgeMasterKey(recipient string) keys.MasterKey {
+	masterKey, err := sopsage.MasterKeyFromRecipient(recipient)
+	Expect(err).To(BeNil())
+	return masterKey
+}
+
+// getPGPMasterKey returns a PGP master key for entity. Data key is encrypted
+// with the in-memory entity, so tests do not depend on a GnuPG keyring.
+func getPGPMasterKey(entity *openpgp.Entity) keys.MasterKey {
+	return &pgpMasterKey{
+		MasterKey: pgp.NewMasterKeyFromFingerprint(fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint)),
+		entity:    entity,
+	}
+}
+
+// pgpMasterKey encrypts the data key with an in-memory PGP entity
+type pgpMasterKey struct {
+	*pgp.MasterKey
+	entity *openpgp.Entity
+}
+
+func (k *pgpMasterKey) Encrypt(dataKey []byte) error {
+	var encryptedKey bytes.Buffer
+	armorWriter, err := armor.Encode(&encryptedKey, "PGP MESSAGE", nil)
+	if err != nil {
+		return err
+	}
+	w, err := openpgp.Encrypt(armorWriter, []*openpgp.Entity{k.entity}, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(dataKey); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := armorWriter.Close(); err != nil {
+		return err
+	}
+
+	k.EncryptedKey = encryptedKey.String()
+	return nil
+}
+
+// encryptWithSOPS returns plaintext YAML encrypted by SOPS with masterKey
+func encryptWithSOPS(plaintext string, masterKey keys.MasterKey) string {
+	store := sopsyaml.NewStore(&config.YAMLStoreConfig{})
+	branches, err := store.LoadPlainFile([]byte(plaintext))
+	Expect(err).To(BeNil())
+
+	dataKey := make([]byte, 32)
+	_, err = rand.Read(dataKey)
+	Expect(err).To(BeNil())
+	Expect(masterKey.Encrypt(dataKey)).To(Succeed())
+
+	// SOPS stores only serialize *pgp.MasterKey
+	if pgpKey, ok := masterKey.(*pgpMasterKey); ok {
+		masterKey = pgpKey.MasterKey
+	}
+
+	tree := sops.Tree{
+		Branches: branches,
+		Metadata: sops.Metadata{
+			KeyGroups: []sops.KeyGroup{{masterKey}},
+			Version:   "3.13.3",
+		},
+	}
+
+	cipher := aes.NewCipher()
+	mac, err := tree.Encrypt(dataKey, cipher)
+	Expect(err).To(BeNil())
+	tree.Metadata.LastModified = time.Now().UTC()
+	tree.Metadata.MessageAuthenticationCode, err = cipher.Encrypt(mac, dataKey,
+		tree.Metadata.LastModified.Format(time.RFC3339))
+	Expect(err).To(BeNil())
+
+	encrypted, err := store.EmitEncryptedFile(tree)
+	Expect(err).To(BeNil())
+	return string(encrypted)
+}
+
+// getYttSourceWithEncryptedValues returns a YttSource rendering secretTemplate with the
+// encrypted data values stored in valuesFile, and the objects it references: the ConfigMap
+// containing ytt files and the decryption Secret containing keyData at key.
+func getYttSourceWithEncryptedValues(valuesFile, encrypted, key string, keyData []byte,
+) (*extensionv1beta1.YttSource, []client.Object) {
+
+	configMap := getConfigMapWithFiles(randomString(), map[string]string{
+		"template.yaml": secretTemplate,
+		valuesFile:      encrypted,
+	})
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      randomString(),
+			Namespace: configMap.Namespace,
+		},
+		Type: libsveltosv1beta1.ClusterProfileSecretType,
+		Data: map[string][]byte{key: keyData},
+	}
+
+	yttSource := getYttSourceForConfigMap(configMap, "./")
+	yttSource.Spec.Decryption = &extensionv1beta1.Decryption{
+		SecretRef: corev1.SecretReference{
+			Namespace: secret.Namespace,
+			Name:      secret.Name,
+		},
+	}
+
+	return yttSource, []client.Object{configMap, secret}
+}
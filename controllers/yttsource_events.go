@@ -0,0 +1,68 @@
+/*
+Copyright 2024. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	extensionv1beta1 "github.com/gianlucam76/ytt-controller/api/v1beta1"
+)
+
+// getReadyCondition returns a copy of YttSource Ready condition, nil if not set
+func getReadyCondition(yttSource *extensionv1beta1.YttSource) *metav1.Condition {
+	c := apimeta.FindStatusCondition(yttSource.Status.Conditions, extensionv1beta1.ReadyCondition)
+	if c == nil {
+		return nil
+	}
+	ready := *c
+	return &ready
+}
+
+// emitEvent records an Event on YttSource describing the outcome of the reconciliation.
+// To avoid spamming on resyncs and retries, an Event is emitted only when:
+// - on success, the rendered revision changed or YttSource was previously not ready;
+// - on failure, the failure reason changed or YttSource was previously ready.
+func (r *YttSourceReconciler) emitEvent(yttSource *extensionv1beta1.YttSource,
+	previousReady *metav1.Condition, previousRevision string, err error) {
+
+	if r.EventRecorder == nil {
+		return
+	}
+
+	if err == nil {
+		if previousReady != nil && previousReady.Status == metav1.ConditionTrue &&
+			previousRevision == yttSource.Status.LastAppliedRevision {
+
+			return
+		}
+		r.EventRecorder.Event(yttSource, corev1.EventTypeNormal, extensionv1beta1.SucceededReason,
+			fmt.Sprintf("rendered revision %s", yttSource.Status.LastAppliedRevision))
+		return
+	}
+
+	reason := getFailureReason(err)
+	if previousReady != nil && previousReady.Status == metav1.ConditionFalse &&
+		previousReady.Reason == reason {
+
+		return
+	}
+	r.EventRecorder.Event(yttSource, corev1.EventTypeWarning, reason, err.Error())
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2024. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	extensionv1beta1 "github.com/gianlucam76/ytt-controller/api/v1beta1"
+)
+
+var _ = Describe("YttSource Controller: events", func() {
+	It("Reconcile emits a Normal event on successful render only once per revision", func() {
+		configMap := getYttConfigMap()
+		yttSource := getYttSourceForConfigMap(configMap, "./")
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource).
+			WithObjects(configMap, yttSource).Build()
+
+		recorder := record.NewFakeRecorder(10)
+		reconciler := getYttSourceReconciler(c)
+		reconciler.EventRecorder = recorder
+
+		req := reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+		}
+		_, err := reconciler.Reconcile(context.TODO(), req)
+		Expect(err).To(BeNil())
+
+		Expect(recorder.Events).To(HaveLen(1))
+		event := <-recorder.Events
+		Expect(event).To(HavePrefix("Normal " + extensionv1beta1.SucceededReason))
+
+		currentYttSource := &extensionv1beta1.YttSource{}
+		Expect(c.Get(context.TODO(), req.NamespacedName, currentYttSource)).To(Succeed())
+		Expect(currentYttSource.Status.LastAppliedRevision).ToNot(BeEmpty())
+		Expect(event).To(ContainSubstring(currentYttSource.Status.LastAppliedRevision))
+
+		// Resync does not emit any new event
+		_, err = reconciler.Reconcile(context.TODO(), req)
+		Expect(err).To(BeNil())
+		Expect(recorder.Events).To(BeEmpty())
+	})
+
+	It("Reconcile emits a Warning event on failure only when reason changes", func() {
+		configMap := getYttConfigMap()
+		yttSource := getYttSourceForConfigMap(configMap, randomString())
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource).
+			WithObjects(configMap, yttSource).Build()
+
+		recorder := record.NewFakeRecorder(10)
+		reconciler := getYttSourceReconciler(c)
+		reconciler.EventRecorder = recorder
+
+		req := reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+		}
+		_, err := reconciler.Reconcile(context.TODO(), req)
+		Expect(err).To(BeNil())
+
+		Expect(recorder.Events).To(HaveLen(1))
+		Expect(<-recorder.Events).To(HavePrefix("Warning " + extensionv1beta1.PathNotFoundReason))
+
+		_, err = reconciler.Reconcile(context.TODO(), req)
+		Expect(err).To(BeNil())
+		Expect(recorder.Events).To(BeEmpty())
+	})
+})
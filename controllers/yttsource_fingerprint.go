@@ -0,0 +1,224 @@
+/*
+Copyright 2024. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	extensionv1beta1 "github.com/gianlucam76/ytt-controller/api/v1beta1"
+
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+	logs "github.com/projectsveltos/libsveltos/lib/logsettings"
+)
+
+// getSourceRevision returns a value identifying the current content of the referenced
+// source without fetching it: resourceVersion for ConfigMaps/Secrets, artifact digest
+// for Flux sources.
+func getSourceRevision(ctx context.Context, c client.Client, ref *corev1.ObjectReference,
+	allowedSecretTypes []corev1.SecretType) (string, error) {
+
+	namespacedName := types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}
+
+	switch ref.Kind {
+	case string(libsveltosv1beta1.ConfigMapReferencedResourceKind):
+		configMap, err := getConfigMap(ctx, c, namespacedName)
+		if err != nil {
+			return "", err
+		}
+		return configMap.ResourceVersion, nil
+	case string(libsveltosv1beta1.SecretReferencedResourceKind):
+		secret, err := getSecret(ctx, c, namespacedName, allowedSecretTypes)
+		if err != nil {
+			return "", err
+		}
+		return secret.ResourceVersion, nil
+	}
+
+	fluxSource, err := getSource(ctx, c, ref)
+	if err != nil {
+		return "", err
+	}
+	if fluxSource == nil {
+		return "", fmt.Errorf("%w: %s %s/%s", errSourceNotFound, ref.Kind, ref.Namespace, ref.Name)
+	}
+	artifact := fluxSource.GetArtifact()
+	if artifact == nil {
+		return "", fmt.Errorf("%w: %s %s/%s", errArtifactNotReady, ref.Kind, ref.Namespace, ref.Name)
+	}
+	if artifact.Digest != "" {
+		return artifact.Digest, nil
+	}
+	return artifact.Revision, nil
+}
+
+// getResourceVersion returns the resourceVersion of the referenced ConfigMap/Secret.
+// An empty string is returned if the resource does not exist.
+func getResourceVersion(ctx context.Context, c client.Client, ref *corev1.ObjectReference) (string, error) {
+	var obj client.Object
+	switch ref.Kind {
+	case string(libsveltosv1beta1.ConfigMapReferencedResourceKind):
+		obj = &corev1.ConfigMap{}
+	case string(libsveltosv1beta1.SecretReferencedResourceKind):
+		obj = &corev1.Secret{}
+	default:
+		return "", fmt.Errorf("kind %s not supported", ref.Kind)
+	}
+
+	err := c.Get(ctx, types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, obj)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return obj.GetResourceVersion(), nil
+}
+
+// getInputFingerprint returns a digest of everything ytt output depends on:
+// YttSource generation (which covers paths, inline data values and output settings),
+// the controller settings limiting rendering and output, the allowed Secret types, the
+// revision of the referenced sources and the resourceVersion of any ConfigMap/Secret
+// containing data values, decryption keys or the credentials remote sources are fetched with.
+func (r *YttSourceReconciler) getInputFingerprint(ctx context.Context, yttSource *extensionv1beta1.YttSource,
+) (string, error) {
+
+	c := r.Client
+	allowedSecretTypes := r.getAllowedSecretTypes(yttSource)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "generation=%d\n", yttSource.Generation)
+	// A more restrictive controller must not keep output rendered with looser settings
+	fmt.Fprintf(h, "renderTimeout=%s\n", r.getRenderTimeout(yttSource))
+	fmt.Fprintf(h, "maxOutputSize=%d\n", r.MaxOutputSize)
+	fmt.Fprintf(h, "allowCrossNamespaceOutput=%t\n", r.AllowCrossNamespaceOutput)
+	fmt.Fprintf(h, "allowCrossNamespaceReferences=%t\n", r.AllowCrossNamespaceReferences)
+	fmt.Fprintf(h, "allowedSecretTypes=%s\n", joinSecretTypes(allowedSecretTypes))
+
+	sources := getSources(yttSource)
+	for i := range sources {
+		ref, err := getSourceReference(yttSource, &sources[i], r.AllowCrossNamespaceReferences)
+		if err != nil {
+			return "", err
+		}
+		var revision string
+		switch sources[i].Kind {
+		case extensionv1beta1.URLSourceKind:
+			revision, err = r.getURLSourceRevision(ctx, yttSource, i, sources[i].URL, allowedSecretTypes)
+		case extensionv1beta1.OCISourceKind:
+			revision, err = getOCISourceRevision(ctx, c, yttSource.Namespace, sources[i].OCI, allowedSecretTypes)
+		default:
+			revision, err = getSourceRevision(ctx, c, ref, allowedSecretTypes)
+		}
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "source=%s/%s/%s@%s\n", ref.Kind, ref.Namespace, ref.Name, revision)
+		fmt.Fprintf(h, "path=%s\n", sources[i].Path)
+		fmt.Fprintf(h, "mountPrefix=%s\n", getMountPrefix(&sources[i]))
+		fmt.Fprintf(h, "type=%s\n", sources[i].Type)
+
+		secretRefs := getSourceSecretReferences(yttSource.Namespace, &sources[i])
+		for j := range secretRefs {
+			resourceVersion, err := getResourceVersion(ctx, c, &secretRefs[j])
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(h, "sourceSecret=%s/%s@%s\n", secretRefs[j].Namespace, secretRefs[j].Name, resourceVersion)
+		}
+	}
+
+	valuesRefs, err := getDataValuesReferences(yttSource, r.AllowCrossNamespaceReferences)
+	if err != nil {
+		return "", err
+	}
+	for i := range valuesRefs {
+		resourceVersion, err := getResourceVersion(ctx, c, &valuesRefs[i])
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "values=%s/%s/%s@%s\n", valuesRefs[i].Kind, valuesRefs[i].Namespace,
+			valuesRefs[i].Name, resourceVersion)
+	}
+
+	decryptionRef, err := getDecryptionSecretReference(yttSource, r.AllowCrossNamespaceReferences)
+	if err != nil {
+		return "", err
+	}
+	if decryptionRef != nil {
+		resourceVersion, err := getResourceVersion(ctx, c, decryptionRef)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "decryption=%s/%s@%s\n", decryptionRef.Namespace, decryptionRef.Name, resourceVersion)
+	}
+
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), nil
+}
+
+// isUpToDate returns true if the last reconciliation succeeded with the same inputs
+// and its output is still in place, so rendering can be skipped.
+func (r *YttSourceReconciler) isUpToDate(ctx context.Context, yttSource *extensionv1beta1.YttSource,
+	fingerprint string, logger logr.Logger) bool {
+
+	if fingerprint == "" || yttSource.Status.InputDigest != fingerprint {
+		return false
+	}
+
+	ready := apimeta.FindStatusCondition(yttSource.Status.Conditions, extensionv1beta1.ReadyCondition)
+	if ready == nil || ready.Status != metav1.ConditionTrue || ready.ObservedGeneration != yttSource.Generation {
+		return false
+	}
+
+	ref := yttSource.Status.OutputRef
+	if ref == nil {
+		return getOutputReference(yttSource) == nil
+	}
+
+	// Output ConfigMap/Secret might have been deleted or modified by someone else
+	obj, err := newOutputObject(ref)
+	if err != nil {
+		return false
+	}
+	err = r.Get(ctx, types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, obj)
+	if err != nil {
+		logger.V(logs.LogDebug).Info(fmt.Sprintf("failed to get output %s %s/%s: %v",
+			ref.Kind, ref.Namespace, ref.Name, err))
+		return false
+	}
+
+	if !isOutputOwnedBy(obj, yttSource) || yttSource.Spec.Output == nil {
+		return false
+	}
+
+	content, err := getOutputData(obj, getOutputKey(yttSource.Spec.Output), yttSource.Spec.Output.Gzip)
+	if err != nil {
+		logger.V(logs.LogDebug).Info(fmt.Sprintf("failed to read output %s %s/%s: %v",
+			ref.Kind, ref.Namespace, ref.Name, err))
+		return false
+	}
+	return getDigest(content) == yttSource.Status.OutputDigest
+}
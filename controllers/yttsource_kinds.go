@@ -0,0 +1,276 @@
+/*
+Copyright 2024. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"sync"
+
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	sourcev1b2 "github.com/fluxcd/source-controller/api/v1beta2"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+)
+
+// sourceKind describes a kind of resource YttSource can read ytt files from.
+// It is the single place defining how resources of a kind are referenced, fetched
+// and watched, so keys stored in ReferenceMap when a YttSource is reconciled always
+// match keys built when a referenced resource changes.
+type sourceKind struct {
+	// gvk identifies resources of this kind in reference keys. For Flux sources,
+	// it is the preferred version, whatever version is actually served.
+	gvk schema.GroupVersionKind
+	// versions lists, by order of preference, the versions resources of this kind
+	// can be fetched and watched with
+	versions []sourceKindVersion
+	// predicates filter the events requiring YttSources referencing a resource
+	// of this kind to be reconciled
+	predicates func(logger logr.Logger) predicate.Funcs
+	// crd is the name of the CustomResourceDefinition of Flux sources. Empty
+	// for any other kind.
+	crd string
+}
+
+// sourceKindVersion is a version resources of a sourceKind can be fetched and watched with
+type sourceKindVersion struct {
+	version string
+	// newObject returns an empty resource of this kind and version
+	newObject func() client.Object
+}
+
+// sourceKinds contains all kinds of resources YttSource can read ytt files from
+var sourceKinds = []sourceKind{
+	{
+		gvk: corev1.SchemeGroupVersion.WithKind(string(libsveltosv1beta1.ConfigMapReferencedResourceKind)),
+		versions: []sourceKindVersion{
+			{version: corev1.SchemeGroupVersion.Version, newObject: func() client.Object { return &corev1.ConfigMap{} }},
+		},
+		predicates: ConfigMapPredicates,
+	},
+	{
+		gvk: corev1.SchemeGroupVersion.WithKind(string(libsveltosv1beta1.SecretReferencedResourceKind)),
+		versions: []sourceKindVersion{
+			{version: corev1.SchemeGroupVersion.Version, newObject: func() client.Object { return &corev1.Secret{} }},
+		},
+		predicates: SecretPredicates,
+	},
+	{
+		gvk: sourcev1.GroupVersion.WithKind(sourcev1.GitRepositoryKind),
+		versions: []sourceKindVersion{
+			{version: sourcev1.GroupVersion.Version, newObject: func() client.Object { return &sourcev1.GitRepository{} }},
+		},
+		predicates: FluxSourcePredicates,
+		crd:        "gitrepositories.source.toolkit.fluxcd.io",
+	},
+	{
+		gvk: sourcev1.GroupVersion.WithKind(sourcev1.OCIRepositoryKind),
+		versions: []sourceKindVersion{
+			{version: sourcev1.GroupVersion.Version, newObject: func() client.Object { return &sourcev1.OCIRepository{} }},
+			{version: sourcev1b2.GroupVersion.Version, newObject: func() client.Object { return &sourcev1b2.OCIRepository{} }},
+		},
+		predicates: FluxSourcePredicates,
+		crd:        "ocirepositories.source.toolkit.fluxcd.io",
+	},
+	{
+		gvk: sourcev1.GroupVersion.WithKind(sourcev1.BucketKind),
+		versions: []sourceKindVersion{
+			{version: sourcev1.GroupVersion.Version, newObject: func() client.Object { return &sourcev1.Bucket{} }},
+			{version: sourcev1b2.GroupVersion.Version, newObject: func() client.Object { return &sourcev1b2.Bucket{} }},
+		},
+		predicates: FluxSourcePredicates,
+		crd:        "buckets.source.toolkit.fluxcd.io",
+	},
+	{
+		gvk: sourcev1.GroupVersion.WithKind(sourcev1.HelmChartKind),
+		versions: []sourceKindVersion{
+			{version: sourcev1.GroupVersion.Version, newObject: func() client.Object { return &sourcev1.HelmChart{} }},
+			{version: sourcev1b2.GroupVersion.Version, newObject: func() client.Object { return &sourcev1b2.HelmChart{} }},
+		},
+		predicates: FluxSourcePredicates,
+		crd:        "helmcharts.source.toolkit.fluxcd.io",
+	},
+	{
+		gvk: sourcev1.GroupVersion.WithKind(sourcev1.ExternalArtifactKind),
+		versions: []sourceKindVersion{
+			{version: sourcev1.GroupVersion.Version, newObject: func() client.Object { return &sourcev1.ExternalArtifact{} }},
+		},
+		predicates: FluxSourcePredicates,
+		crd:        "externalartifacts.source.toolkit.fluxcd.io",
+	},
+}
+
+var (
+	// fluxSourceVersions contains, for each Flux source kind, the version selected
+	// among those served by the cluster
+	fluxSourceVersions    map[string]*sourceKindVersion
+	fluxSourceVersionsMux sync.RWMutex
+)
+
+// lookupSourceKind returns the sourceKind with given kind, or nil if kind is not supported
+func lookupSourceKind(kind string) *sourceKind {
+	for i := range sourceKinds {
+		if sourceKinds[i].gvk.Kind == kind {
+			return &sourceKinds[i]
+		}
+	}
+	return nil
+}
+
+// lookupSourceKindForObject returns the sourceKind obj is a resource of, whatever
+// its version, or nil if obj is not a supported source
+func lookupSourceKindForObject(obj client.Object) *sourceKind {
+	objType := reflect.TypeOf(obj)
+	for i := range sourceKinds {
+		for j := range sourceKinds[i].versions {
+			if reflect.TypeOf(sourceKinds[i].versions[j].newObject()) == objType {
+				return &sourceKinds[i]
+			}
+		}
+	}
+	return nil
+}
+
+// isFlux returns true if resources of this kind are Flux sources, whose
+// content is fetched from the artifact they expose
+func (k *sourceKind) isFlux() bool {
+	return k.crd != ""
+}
+
+// getVersion returns the version resources of this kind are fetched with. For Flux
+// sources, that is the version selected by discoverFluxSourceVersions or, till then,
+// the least preferred one (the one served by the oldest Flux releases).
+func (k *sourceKind) getVersion() *sourceKindVersion {
+	if k.isFlux() {
+		fluxSourceVersionsMux.RLock()
+		defer fluxSourceVersionsMux.RUnlock()
+		if v, ok := fluxSourceVersions[k.gvk.Kind]; ok && v != nil {
+			return v
+		}
+	}
+	return &k.versions[len(k.versions)-1]
+}
+
+// discoverFluxSourceVersions returns, for each Flux source kind, the preferred version
+// among the versions served by the cluster, or nil if its CRD is not installed or none
+// of its versions is served.
+func discoverFluxSourceVersions(ctx context.Context, c client.Reader) (map[string]*sourceKindVersion, error) {
+	versions := make(map[string]*sourceKindVersion)
+	for i := range sourceKinds {
+		kind := &sourceKinds[i]
+		if !kind.isFlux() {
+			continue
+		}
+
+		crd := &apiextensionsv1.CustomResourceDefinition{}
+		err := c.Get(ctx, types.NamespacedName{Name: kind.crd}, crd)
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				versions[kind.gvk.Kind] = nil
+				continue
+			}
+			return nil, err
+		}
+
+		versions[kind.gvk.Kind] = selectServedVersion(kind, crd)
+	}
+
+	return versions, nil
+}
+
+// selectServedVersion returns the preferred version of kind served according to crd,
+// or nil if none is served
+func selectServedVersion(kind *sourceKind, crd *apiextensionsv1.CustomResourceDefinition) *sourceKindVersion {
+	for i := range kind.versions {
+		for j := range crd.Spec.Versions {
+			if crd.Spec.Versions[j].Name == kind.versions[i].version && crd.Spec.Versions[j].Served {
+				return &kind.versions[i]
+			}
+		}
+	}
+	return nil
+}
+
+func setFluxSourceVersions(versions map[string]*sourceKindVersion) {
+	fluxSourceVersionsMux.Lock()
+	defer fluxSourceVersionsMux.Unlock()
+	fluxSourceVersions = versions
+}
+
+// FluxSourceVersionsChanged returns true if the versions served for Flux sources
+// differ from the ones selected when the watchers on Flux sources were started.
+// Since watchers cannot be replaced, controller must then be restarted.
+func FluxSourceVersionsChanged(ctx context.Context, c client.Reader) (bool, error) {
+	versions, err := discoverFluxSourceVersions(ctx, c)
+	if err != nil {
+		return false, err
+	}
+
+	fluxSourceVersionsMux.RLock()
+	defer fluxSourceVersionsMux.RUnlock()
+	if fluxSourceVersions == nil {
+		// Watchers have not been started yet
+		return false, nil
+	}
+	for kind, v := range versions {
+		if fluxSourceVersions[kind] != v {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// IsFluxSourceKind returns true if kind is a Flux source kind YttSource can reference
+func IsFluxSourceKind(gvk *schema.GroupVersionKind) bool {
+	kind := lookupSourceKind(gvk.Kind)
+	return kind != nil && kind.isFlux() && kind.gvk.Group == gvk.Group
+}
+
+// getReferenceAPIVersion returns the apiVersion resources of given kind are referenced
+// with, or an empty string if kind is not supported
+func getReferenceAPIVersion(kind string) string {
+	if k := lookupSourceKind(kind); k != nil {
+		return k.gvk.GroupVersion().String()
+	}
+	return ""
+}
+
+// getReferenceKey returns the key, in ReferenceMap, of the YttSources referencing obj.
+// Objects received from the cache have no type information, so for supported sources
+// apiVersion and kind come from sourceKinds.
+func getReferenceKey(obj client.Object) *corev1.ObjectReference {
+	gvk := obj.GetObjectKind().GroupVersionKind()
+	if k := lookupSourceKindForObject(obj); k != nil {
+		gvk = k.gvk
+	}
+
+	apiVersion, kind := gvk.ToAPIVersionAndKind()
+	return &corev1.ObjectReference{
+		APIVersion: apiVersion,
+		Kind:       kind,
+		Namespace:  obj.GetNamespace(),
+		Name:       obj.GetName(),
+	}
+}
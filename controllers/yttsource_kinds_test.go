@@ -0,0 +1,262 @@
+/*
+Copyright 2024. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	sourcev1b2 "github.com/fluxcd/source-controller/api/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2/textlogger"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	extensionv1beta1 "github.com/gianlucam76/ytt-controller/api/v1beta1"
+	"github.com/gianlucam76/ytt-controller/controllers"
+)
+
+var _ = Describe("YttSource source kinds", func() {
+	DescribeTable("GetReferenceAPIVersion returns the apiVersion of every supported kind",
+		func(kind, apiVersion string) {
+			Expect(controllers.GetReferenceAPIVersion(kind)).To(Equal(apiVersion))
+		},
+		Entry("ConfigMap", "ConfigMap", "v1"),
+		Entry("Secret", "Secret", "v1"),
+		Entry("GitRepository", sourcev1.GitRepositoryKind, sourcev1.GroupVersion.String()),
+		Entry("OCIRepository", sourcev1.OCIRepositoryKind, sourcev1.GroupVersion.String()),
+		Entry("Bucket", sourcev1.BucketKind, sourcev1.GroupVersion.String()),
+		Entry("HelmChart", sourcev1.HelmChartKind, sourcev1.GroupVersion.String()),
+		Entry("ExternalArtifact", sourcev1.ExternalArtifactKind, sourcev1.GroupVersion.String()),
+		Entry("unsupported kind", "HelmRepository", ""),
+	)
+
+	DescribeTable("GetReferenceKey returns apiVersion and kind of objects with no type information",
+		func(obj client.Object, kind string) {
+			obj.SetNamespace(randomString())
+			obj.SetName(randomString())
+
+			key := controllers.GetReferenceKey(obj)
+			Expect(key.Kind).To(Equal(kind))
+			Expect(key.APIVersion).To(Equal(controllers.GetReferenceAPIVersion(kind)))
+			Expect(key.Namespace).To(Equal(obj.GetNamespace()))
+			Expect(key.Name).To(Equal(obj.GetName()))
+		},
+		Entry("ConfigMap", &corev1.ConfigMap{}, "ConfigMap"),
+		Entry("Secret", &corev1.Secret{}, "Secret"),
+		Entry("GitRepository", &sourcev1.GitRepository{}, sourcev1.GitRepositoryKind),
+		Entry("OCIRepository v1", &sourcev1.OCIRepository{}, sourcev1.OCIRepositoryKind),
+		Entry("OCIRepository v1beta2", &sourcev1b2.OCIRepository{}, sourcev1b2.OCIRepositoryKind),
+		Entry("Bucket v1", &sourcev1.Bucket{}, sourcev1.BucketKind),
+		Entry("Bucket v1beta2", &sourcev1b2.Bucket{}, sourcev1b2.BucketKind),
+		Entry("HelmChart v1", &sourcev1.HelmChart{}, sourcev1.HelmChartKind),
+		Entry("HelmChart v1beta2", &sourcev1b2.HelmChart{}, sourcev1b2.HelmChartKind),
+		Entry("ExternalArtifact", &sourcev1.ExternalArtifact{}, sourcev1.ExternalArtifactKind),
+	)
+
+	DescribeTable("RequeueYttSourceForReference returns YttSources referencing a source of every supported kind",
+		func(obj client.Object, kind string) {
+			obj.SetNamespace(randomString())
+			obj.SetName(randomString())
+
+			yttSource := &extensionv1beta1.YttSource{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:       randomString(),
+					Namespace:  randomString(),
+					Generation: 1,
+				},
+				Spec: extensionv1beta1.YttSourceSpec{
+					Kind:      kind,
+					Namespace: obj.GetNamespace(),
+					Name:      obj.GetName(),
+				},
+			}
+
+			c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource).
+				WithObjects(yttSource).Build()
+
+			// Reconciling stores the references of the YttSource, even if source does not exist
+			reconciler := getYttSourceReconciler(c)
+			_, _ = reconciler.Reconcile(context.TODO(), reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+			})
+
+			// Objects received from the cache have no type information
+			requests := controllers.RequeueYttSourceForReference(reconciler, context.TODO(), obj)
+			Expect(requests).To(HaveLen(1))
+			Expect(requests[0].Namespace).To(Equal(yttSource.Namespace))
+			Expect(requests[0].Name).To(Equal(yttSource.Name))
+		},
+		Entry("ConfigMap", &corev1.ConfigMap{}, "ConfigMap"),
+		Entry("Secret", &corev1.Secret{}, "Secret"),
+		Entry("GitRepository", &sourcev1.GitRepository{}, sourcev1.GitRepositoryKind),
+		Entry("OCIRepository v1", &sourcev1.OCIRepository{}, sourcev1.OCIRepositoryKind),
+		Entry("OCIRepository v1beta2", &sourcev1b2.OCIRepository{}, sourcev1b2.OCIRepositoryKind),
+		Entry("Bucket v1", &sourcev1.Bucket{}, sourcev1.BucketKind),
+		Entry("Bucket v1beta2", &sourcev1b2.Bucket{}, sourcev1b2.BucketKind),
+		Entry("HelmChart v1", &sourcev1.HelmChart{}, sourcev1.HelmChartKind),
+		Entry("HelmChart v1beta2", &sourcev1b2.HelmChart{}, sourcev1b2.HelmChartKind),
+		Entry("ExternalArtifact", &sourcev1.ExternalArtifact{}, sourcev1.ExternalArtifactKind),
+	)
+
+	DescribeTable("FluxSourcePredicates detect artifact changes of every Flux source kind",
+		func(oldObj, newObj client.Object, setArtifact func(obj client.Object, artifact *meta.Artifact)) {
+			sourcePredicate := controllers.FluxSourcePredicates(textlogger.NewLogger(textlogger.NewConfig()))
+
+			artifact := &meta.Artifact{Revision: randomString(), Digest: randomString()}
+			setArtifact(oldObj, artifact)
+			setArtifact(newObj, artifact)
+			Expect(sourcePredicate.Update(event.UpdateEvent{ObjectOld: oldObj, ObjectNew: newObj})).To(BeFalse())
+
+			setArtifact(newObj, &meta.Artifact{Revision: randomString(), Digest: randomString()})
+			Expect(sourcePredicate.Update(event.UpdateEvent{ObjectOld: oldObj, ObjectNew: newObj})).To(BeTrue())
+		},
+		Entry("GitRepository", &sourcev1.GitRepository{}, &sourcev1.GitRepository{},
+			func(obj client.Object, artifact *meta.Artifact) {
+				obj.(*sourcev1.GitRepository).Status.Artifact = artifact
+			}),
+		Entry("OCIRepository v1", &sourcev1.OCIRepository{}, &sourcev1.OCIRepository{},
+			func(obj client.Object, artifact *meta.Artifact) {
+				obj.(*sourcev1.OCIRepository).Status.Artifact = artifact
+			}),
+		Entry("OCIRepository v1beta2", &sourcev1b2.OCIRepository{}, &sourcev1b2.OCIRepository{},
+			func(obj client.Object, artifact *meta.Artifact) {
+				obj.(*sourcev1b2.OCIRepository).Status.Artifact = artifact
+			}),
+		Entry("Bucket v1", &sourcev1.Bucket{}, &sourcev1.Bucket{},
+			func(obj client.Object, artifact *meta.Artifact) {
+				obj.(*sourcev1.Bucket).Status.Artifact = artifact
+			}),
+		Entry("Bucket v1beta2", &sourcev1b2.Bucket{}, &sourcev1b2.Bucket{},
+			func(obj client.Object, artifact *meta.Artifact) {
+				obj.(*sourcev1b2.Bucket).Status.Artifact = artifact
+			}),
+		Entry("HelmChart v1", &sourcev1.HelmChart{}, &sourcev1.HelmChart{},
+			func(obj client.Object, artifact *meta.Artifact) {
+				obj.(*sourcev1.HelmChart).Status.Artifact = artifact
+			}),
+		Entry("HelmChart v1beta2", &sourcev1b2.HelmChart{}, &sourcev1b2.HelmChart{},
+			func(obj client.Object, artifact *meta.Artifact) {
+				obj.(*sourcev1b2.HelmChart).Status.Artifact = artifact
+			}),
+		Entry("ExternalArtifact", &sourcev1.ExternalArtifact{}, &sourcev1.ExternalArtifact{},
+			func(obj client.Object, artifact *meta.Artifact) {
+				obj.(*sourcev1.ExternalArtifact).Status.Artifact = artifact
+			}),
+	)
+})
+
+var _ = Describe("YttSource source kinds: Flux versions", func() {
+	AfterEach(func() {
+		controllers.ResetFluxSourceVersions()
+	})
+
+	It("SelectFluxSourceVersions selects the preferred served version of every Flux source", func() {
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			getFluxSourceCRD("ocirepositories", "v1", "v1beta2"),
+			getFluxSourceCRD("buckets", "v1beta2"),
+		).Build()
+
+		versions, err := controllers.SelectFluxSourceVersions(context.TODO(), c)
+		Expect(err).To(BeNil())
+		Expect(versions).To(Equal(map[string]string{
+			sourcev1.OCIRepositoryKind: "v1",
+			sourcev1.BucketKind:        "v1beta2",
+		}))
+	})
+
+	It("GetSource fetches Flux sources with the selected version", func() {
+		ociRepository := &sourcev1.OCIRepository{
+			ObjectMeta: metav1.ObjectMeta{Namespace: randomString(), Name: randomString()},
+		}
+		bucket := &sourcev1b2.Bucket{
+			ObjectMeta: metav1.ObjectMeta{Namespace: randomString(), Name: randomString()},
+		}
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			getFluxSourceCRD("ocirepositories", "v1", "v1beta2"),
+			getFluxSourceCRD("buckets", "v1beta2"),
+			ociRepository, bucket,
+		).Build()
+
+		_, err := controllers.SelectFluxSourceVersions(context.TODO(), c)
+		Expect(err).To(BeNil())
+
+		src, err := controllers.GetSource(context.TODO(), c, &corev1.ObjectReference{
+			Kind: sourcev1.OCIRepositoryKind, Namespace: ociRepository.Namespace, Name: ociRepository.Name})
+		Expect(err).To(BeNil())
+		Expect(src).To(BeAssignableToTypeOf(&sourcev1.OCIRepository{}))
+
+		src, err = controllers.GetSource(context.TODO(), c, &corev1.ObjectReference{
+			Kind: sourcev1.BucketKind, Namespace: bucket.Namespace, Name: bucket.Name})
+		Expect(err).To(BeNil())
+		Expect(src).To(BeAssignableToTypeOf(&sourcev1b2.Bucket{}))
+	})
+
+	It("FluxSourceVersionsChanged detects Flux serving a preferred version", func() {
+		bucketCRD := getFluxSourceCRD("buckets", "v1beta2")
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(bucketCRD).Build()
+
+		changed, err := controllers.FluxSourceVersionsChanged(context.TODO(), c)
+		Expect(err).To(BeNil())
+		Expect(changed).To(BeFalse(), "versions have not been selected yet")
+
+		_, err = controllers.SelectFluxSourceVersions(context.TODO(), c)
+		Expect(err).To(BeNil())
+
+		changed, err = controllers.FluxSourceVersionsChanged(context.TODO(), c)
+		Expect(err).To(BeNil())
+		Expect(changed).To(BeFalse())
+
+		Expect(c.Get(context.TODO(), types.NamespacedName{Name: bucketCRD.Name}, bucketCRD)).To(Succeed())
+		bucketCRD.Spec.Versions = append(bucketCRD.Spec.Versions,
+			apiextensionsv1.CustomResourceDefinitionVersion{Name: "v1", Served: true})
+		Expect(c.Update(context.TODO(), bucketCRD)).To(Succeed())
+
+		changed, err = controllers.FluxSourceVersionsChanged(context.TODO(), c)
+		Expect(err).To(BeNil())
+		Expect(changed).To(BeTrue())
+	})
+})
+
+// getFluxSourceCRD returns the CRD of a Flux source serving given versions
+func getFluxSourceCRD(plural string, servedVersions ...string) *apiextensionsv1.CustomResourceDefinition {
+	crd := &apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: plural + "." + sourcev1.GroupVersion.Group,
+		},
+		Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+			Group: sourcev1.GroupVersion.Group,
+		},
+	}
+	for i := range servedVersions {
+		crd.Spec.Versions = append(crd.Spec.Versions, apiextensionsv1.CustomResourceDefinitionVersion{
+			Name:   servedVersions[i],
+			Served: true,
+		})
+	}
+	return crd
+}
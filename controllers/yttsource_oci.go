@@ -0,0 +1,473 @@
+/*
+Copyright 2024. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	extensionv1beta1 "github.com/gianlucam76/ytt-controller/api/v1beta1"
+
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+	logs "github.com/projectsveltos/libsveltos/lib/logsettings"
+)
+
+const (
+	// ociTitleAnnotation is the annotation ORAS sets on layers with the name of the pushed file
+	ociTitleAnnotation = "org.opencontainers.image.title"
+
+	// cosignSignatureAnnotation is the annotation cosign sets on the layers of signature
+	// images with the base64 encoded signature of the layer
+	cosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+)
+
+var (
+	// errSignatureVerificationFailed is returned when an OCI artifact is not signed
+	// with any of the configured cosign public keys
+	errSignatureVerificationFailed = errors.New("signature verification failed")
+)
+
+// ociPuller pulls artifacts from the registry of an OCISource
+type ociPuller struct {
+	ref     name.Reference
+	options []remote.Option
+	// publicKeys, when set, are the cosign public keys artifact must be signed with
+	publicKeys []crypto.PublicKey
+}
+
+// newOCIPuller returns an ociPuller for source. Registry credentials and cosign public
+// keys are read from the Secrets referenced by source, which must be in namespace.
+// Registry credentials must be a dockerconfigjson Secret, cosign public keys a Secret of
+// one of allowedSecretTypes.
+func newOCIPuller(ctx context.Context, c client.Client, namespace string,
+	source *extensionv1beta1.OCISource, allowedSecretTypes []corev1.SecretType) (*ociPuller, error) {
+
+	if source == nil {
+		return nil, fmt.Errorf("oci must be set for kind %s", extensionv1beta1.OCISourceKind)
+	}
+
+	var nameOptions []name.Option
+	if source.Insecure {
+		nameOptions = append(nameOptions, name.Insecure)
+	}
+	ref, err := name.ParseReference(source.Reference, nameOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OCI reference %q: %w", source.Reference, err)
+	}
+
+	puller := &ociPuller{
+		ref:     ref,
+		options: []remote.Option{remote.WithContext(ctx)},
+	}
+
+	if source.SecretRef != nil {
+		secret, err := getSourceSecret(ctx, c, namespace, source.SecretRef,
+			[]corev1.SecretType{corev1.SecretTypeDockerConfigJson})
+		if err != nil {
+			return nil, err
+		}
+		keychain, err := newDockerConfigKeychain(secret)
+		if err != nil {
+			return nil, err
+		}
+		puller.options = append(puller.options, remote.WithAuthFromKeychain(keychain))
+	}
+
+	if source.Verify != nil {
+		secret, err := getSourceSecret(ctx, c, namespace, &source.Verify.SecretRef, allowedSecretTypes)
+		if err != nil {
+			return nil, err
+		}
+		puller.publicKeys, err = getCosignPublicKeys(secret)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return puller, nil
+}
+
+// getOCISourceSecretReferences returns the Secrets source reads registry credentials
+// and cosign public keys from
+func getOCISourceSecretReferences(namespace string, source *extensionv1beta1.OCISource) []corev1.ObjectReference {
+	if source == nil {
+		return nil
+	}
+
+	secretRefs := []*corev1.SecretReference{source.SecretRef}
+	if source.Verify != nil {
+		secretRefs = append(secretRefs, &source.Verify.SecretRef)
+	}
+
+	kind := string(libsveltosv1beta1.SecretReferencedResourceKind)
+	var refs []corev1.ObjectReference
+	for _, ref := range secretRefs {
+		if ref == nil {
+			continue
+		}
+		refs = append(refs, corev1.ObjectReference{
+			APIVersion: getReferenceAPIVersion(kind),
+			Kind:       kind,
+			Namespace:  namespace,
+			Name:       ref.Name,
+		})
+	}
+	return refs
+}
+
+// dockerConfigKeychain resolves registry credentials from a dockerconfigjson Secret
+type dockerConfigKeychain struct {
+	auths map[string]authn.AuthConfig
+}
+
+func newDockerConfigKeychain(secret *corev1.Secret) (*dockerConfigKeychain, error) {
+	data, ok := secret.Data[corev1.DockerConfigJsonKey]
+	if !ok {
+		return nil, fmt.Errorf("secret %s/%s does not contain key %s", secret.Namespace, secret.Name,
+			corev1.DockerConfigJsonKey)
+	}
+
+	config := struct {
+		Auths map[string]authn.AuthConfig `json:"auths"`
+	}{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("secret %s/%s: invalid %s: %w", secret.Namespace, secret.Name,
+			corev1.DockerConfigJsonKey, err)
+	}
+
+	keychain := &dockerConfigKeychain{auths: make(map[string]authn.AuthConfig, len(config.Auths))}
+	for host, auth := range config.Auths {
+		keychain.auths[normalizeRegistry(host)] = auth
+	}
+	return keychain, nil
+}
+
+// Resolve implements authn.Keychain
+func (k *dockerConfigKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	if auth, ok := k.auths[normalizeRegistry(target.RegistryStr())]; ok {
+		return authn.FromConfig(auth), nil
+	}
+	return authn.Anonymous, nil
+}
+
+// normalizeRegistry returns the registry host of a dockerconfigjson entry, which
+// can be an URL (for instance https://index.docker.io/v1/)
+func normalizeRegistry(host string) string {
+	host = strings.TrimPrefix(host, "https://")
+	host = strings.TrimPrefix(host, "http://")
+	host, _, _ = strings.Cut(host, "/")
+	switch host {
+	case "docker.io", "registry-1.docker.io":
+		return name.DefaultRegistry
+	}
+	return host
+}
+
+// getCosignPublicKeys returns the public keys contained in the keys of secret ending with .pub
+func getCosignPublicKeys(secret *corev1.Secret) ([]crypto.PublicKey, error) {
+	names := make([]string, 0, len(secret.Data))
+	for key := range secret.Data {
+		if strings.HasSuffix(key, extensionv1beta1.CosignPublicKeySuffix) {
+			names = append(names, key)
+		}
+	}
+	sort.Strings(names)
+
+	publicKeys := make([]crypto.PublicKey, 0, len(names))
+	for _, key := range names {
+		block, _ := pem.Decode(secret.Data[key])
+		if block == nil {
+			return nil, fmt.Errorf("secret %s/%s: key %s does not contain a PEM-encoded public key",
+				secret.Namespace, secret.Name, key)
+		}
+		publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("secret %s/%s: key %s: %w", secret.Namespace, secret.Name, key, err)
+		}
+		publicKeys = append(publicKeys, publicKey)
+	}
+
+	if len(publicKeys) == 0 {
+		return nil, fmt.Errorf("secret %s/%s does not contain any key ending with %s", secret.Namespace,
+			secret.Name, extensionv1beta1.CosignPublicKeySuffix)
+	}
+	return publicKeys, nil
+}
+
+// resolve returns the digest the reference currently points to. References are resolved
+// with a HEAD request, so the artifact is not downloaded. References by digest are sent
+// to the registry too: the registry then authenticates this puller, so that an artifact
+// is never served from the artifact cache to a puller which is not allowed to pull it.
+func (p *ociPuller) resolve() (v1.Hash, error) {
+	desc, err := remote.Head(p.ref, p.options...)
+	if err != nil {
+		return v1.Hash{}, fmt.Errorf("failed to resolve %s: %w", p.ref, err)
+	}
+
+	if digest, ok := p.ref.(name.Digest); ok && desc.Digest.String() != digest.DigestStr() {
+		return v1.Hash{}, fmt.Errorf("registry returned digest %s for %s", desc.Digest, p.ref)
+	}
+	return desc.Digest, nil
+}
+
+// revision returns the revision of the artifact with given digest: <tag>@<digest> for
+// references by tag, the digest otherwise
+func (p *ociPuller) revision(digest v1.Hash) string {
+	if tag, ok := p.ref.(name.Tag); ok {
+		return fmt.Sprintf("%s@%s", tag.TagStr(), digest)
+	}
+	return digest.String()
+}
+
+// verify returns an error if the artifact with given digest is not signed with any of the
+// cosign public keys. Signatures are read from the <digest algorithm>-<digest hex>.sig tag
+// of the artifact repository, where cosign pushes them.
+func (p *ociPuller) verify(digest v1.Hash) error {
+	if len(p.publicKeys) == 0 {
+		return nil
+	}
+
+	sigTag := p.ref.Context().Tag(fmt.Sprintf("%s-%s.sig", digest.Algorithm, digest.Hex))
+	sigImage, err := remote.Image(sigTag, p.options...)
+	if err != nil {
+		return fmt.Errorf("%w: no cosign signature found for %s@%s: %w", errSignatureVerificationFailed,
+			p.ref.Context(), digest, err)
+	}
+	manifest, err := sigImage.Manifest()
+	if err != nil {
+		return err
+	}
+	layers, err := sigImage.Layers()
+	if err != nil {
+		return err
+	}
+
+	for i := range manifest.Layers {
+		signature, err := base64.StdEncoding.DecodeString(manifest.Layers[i].Annotations[cosignSignatureAnnotation])
+		if err != nil || len(signature) == 0 {
+			continue
+		}
+		payload, err := readLayer(layers[i])
+		if err != nil {
+			return err
+		}
+		for j := range p.publicKeys {
+			if verifySignature(p.publicKeys[j], payload, signature) && isPayloadFor(payload, digest) {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("%w: %s@%s is not signed with any of the configured keys", errSignatureVerificationFailed,
+		p.ref.Context(), digest)
+}
+
+func readLayer(layer v1.Layer) ([]byte, error) {
+	rc, err := layer.Compressed()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(io.LimitReader(rc, maxSize))
+}
+
+// verifySignature returns true if signature is a valid signature of payload with publicKey
+func verifySignature(publicKey crypto.PublicKey, payload, signature []byte) bool {
+	hash := sha256.Sum256(payload)
+	switch key := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(key, hash[:], signature)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, payload, signature)
+	}
+	return false
+}
+
+// isPayloadFor returns true if the cosign simple signing payload refers to digest
+func isPayloadFor(payload []byte, digest v1.Hash) bool {
+	simpleSigning := struct {
+		Critical struct {
+			Image struct {
+				DockerManifestDigest string `json:"docker-manifest-digest"`
+			} `json:"image"`
+		} `json:"critical"`
+	}{}
+	if err := json.Unmarshal(payload, &simpleSigning); err != nil {
+		return false
+	}
+	return simpleSigning.Critical.Image.DockerManifestDigest == digest.String()
+}
+
+// pull downloads the artifact with given digest and extracts its layers, in order, to dir.
+// Tar layers (imgpkg bundles, Flux artifacts, ORAS directories) are extracted; any other
+// layer is written to the file named after its title annotation (ORAS files).
+func (p *ociPuller) pull(digest v1.Hash, dir string) error {
+	image, err := remote.Image(p.ref.Context().Digest(digest.String()), p.options...)
+	if err != nil {
+		return fmt.Errorf("failed to pull %s@%s: %w", p.ref.Context(), digest, err)
+	}
+	manifest, err := image.Manifest()
+	if err != nil {
+		return err
+	}
+	layers, err := image.Layers()
+	if err != nil {
+		return err
+	}
+
+	for i := range layers {
+		if err := extractLayer(layers[i], &manifest.Layers[i], dir); err != nil {
+			return fmt.Errorf("failed to extract layer %s of %s@%s: %w", manifest.Layers[i].Digest,
+				p.ref.Context(), digest, err)
+		}
+	}
+	return nil
+}
+
+func extractLayer(layer v1.Layer, desc *v1.Descriptor, dir string) error {
+	title := desc.Annotations[ociTitleAnnotation]
+	if title != "" && !strings.Contains(string(desc.MediaType), "tar") {
+		target := filepath.Join(dir, filepath.Clean(title))
+		if !isWithinDir(target, dir) {
+			return fmt.Errorf("layer title %q is outside of destination directory", title)
+		}
+		content, err := readUncompressedLayer(layer)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), permission0755); err != nil {
+			return err
+		}
+		return os.WriteFile(target, content, permission0600)
+	}
+
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return extractTar(rc, dir)
+}
+
+func readUncompressedLayer(layer v1.Layer) ([]byte, error) {
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(io.LimitReader(rc, maxSize))
+}
+
+// getOCISourceRevision returns the revision of the artifact source currently points to,
+// without downloading it
+func getOCISourceRevision(ctx context.Context, c client.Client, namespace string,
+	source *extensionv1beta1.OCISource, allowedSecretTypes []corev1.SecretType) (string, error) {
+
+	puller, err := newOCIPuller(ctx, c, namespace, source, allowedSecretTypes)
+	if err != nil {
+		return "", err
+	}
+	digest, err := puller.resolve()
+	if err != nil {
+		return "", err
+	}
+	return puller.revision(digest), nil
+}
+
+// prepareFileSystemWithOCI pulls the artifact described by source and extracts it.
+// References are resolved to a digest first, with the credentials of source: artifacts
+// are stored in the artifact cache keyed by digest, so an artifact already pulled is not
+// downloaded again, but is only served once the registry has authenticated this request.
+func prepareFileSystemWithOCI(ctx context.Context, c client.Client, artifactCache *ArtifactCache,
+	downloadPool *workerPool, namespace string, source *extensionv1beta1.OCISource,
+	allowedSecretTypes []corev1.SecretType, logger logr.Logger,
+) (dir, revision string, cleanup func(), err error) {
+
+	puller, err := newOCIPuller(ctx, c, namespace, source, allowedSecretTypes)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	digest, err := puller.resolve()
+	if err != nil {
+		return "", "", nil, err
+	}
+	revision = puller.revision(digest)
+
+	if err := puller.verify(digest); err != nil {
+		logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to verify %s: %v", source.Reference, err))
+		return "", "", nil, err
+	}
+
+	// Downloads are network heavy. Wait for a free slot.
+	pullArtifact := func(dir string) error {
+		release, err := downloadPool.acquire(ctx)
+		if err != nil {
+			return err
+		}
+		defer release()
+
+		return puller.pull(digest, dir)
+	}
+
+	if artifactCache != nil {
+		dir, cleanup, err = artifactCache.Acquire(
+			getArtifactCacheKey(extensionv1beta1.OCISourceKind, "", digest.String()), pullArtifact)
+		if err != nil {
+			return "", "", nil, err
+		}
+		logger.V(logs.LogDebug).Info(fmt.Sprintf("using cached artifact %s", digest))
+		return dir, revision, cleanup, nil
+	}
+
+	tmpDir, err := os.MkdirTemp("", "oci-source-")
+	if err != nil {
+		return "", "", nil, fmt.Errorf("tmp dir error: %w", err)
+	}
+	cleanup = func() { os.RemoveAll(tmpDir) }
+
+	if err := pullArtifact(tmpDir); err != nil {
+		cleanup()
+		return "", "", nil, err
+	}
+	return tmpDir, revision, cleanup, nil
+}
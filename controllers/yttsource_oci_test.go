@@ -0,0 +1,333 @@
+/*
+Copyright 2024. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	extensionv1beta1 "github.com/gianlucam76/ytt-controller/api/v1beta1"
+	"github.com/gianlucam76/ytt-controller/controllers"
+
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+)
+
+const (
+	ociTemplate = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: from-oci
+data:
+  source: oci
+`
+)
+
+var _ = Describe("YttSource Controller: OCI source", func() {
+	var server *httptest.Server
+	var host string
+
+	BeforeEach(func() {
+		server = httptest.NewServer(registry.New())
+		host = strings.TrimPrefix(server.URL, "http://")
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("Reconcile renders an artifact referenced by tag and reports tag and digest as revision", func() {
+		reference := fmt.Sprintf("%s/%s:v1", host, randomString())
+		digest := pushOCIArtifact(reference, map[string]string{"deploy/app.yaml": ociTemplate})
+
+		yttSource := getYttSourceForOCI(randomString(), &extensionv1beta1.OCISource{
+			Reference: reference,
+			Interval:  &metav1.Duration{Duration: time.Minute},
+		})
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource).
+			WithObjects(yttSource).Build()
+		result, currentYttSource := reconcileYttSourceWithClient(c, getYttSourceReconciler(c), yttSource)
+		Expect(currentYttSource.Status.FailureMessage).To(BeNil())
+		Expect(currentYttSource.Status.Resources).To(ContainSubstring("name: from-oci"))
+		Expect(currentYttSource.Status.LastAppliedRevision).To(Equal("v1@" + digest.String()))
+		// Tags can be moved: YttSource is polled
+		Expect(result.RequeueAfter).To(Equal(time.Minute))
+	})
+
+	It("Reconcile does not poll artifacts referenced by digest", func() {
+		repository := fmt.Sprintf("%s/%s", host, randomString())
+		digest := pushOCIArtifact(repository+":latest", map[string]string{"app.yaml": ociTemplate})
+
+		yttSource := getYttSourceForOCI(randomString(), &extensionv1beta1.OCISource{
+			Reference: repository + "@" + digest.String(),
+		})
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource).
+			WithObjects(yttSource).Build()
+		result, currentYttSource := reconcileYttSourceWithClient(c, getYttSourceReconciler(c), yttSource)
+		Expect(currentYttSource.Status.FailureMessage).To(BeNil())
+		Expect(currentYttSource.Status.LastAppliedRevision).To(Equal(digest.String()))
+		Expect(result.RequeueAfter).To(BeZero())
+	})
+
+	It("Reconcile authenticates with the credentials of a dockerconfigjson Secret", func() {
+		server.Close()
+		registryHandler := registry.New()
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if user, password, ok := r.BasicAuth(); !ok || user != "user" || password != "password" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			registryHandler.ServeHTTP(w, r)
+		}))
+		host = strings.TrimPrefix(server.URL, "http://")
+
+		reference := fmt.Sprintf("%s/%s:v1", host, randomString())
+		pushOCIArtifact(reference, map[string]string{"app.yaml": ociTemplate},
+			remote.WithAuth(&authn.Basic{Username: "user", Password: "password"}))
+
+		credentials := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: randomString(), Name: randomString()},
+			Type:       corev1.SecretTypeDockerConfigJson,
+			Data: map[string][]byte{
+				corev1.DockerConfigJsonKey: []byte(fmt.Sprintf(`{"auths":{%q:{"auth":%q}}}`, host,
+					base64.StdEncoding.EncodeToString([]byte("user:password")))),
+			},
+		}
+		yttSource := getYttSourceForOCI(credentials.Namespace, &extensionv1beta1.OCISource{
+			Reference: reference,
+		})
+
+		// Without credentials, pulling fails
+		currentYttSource := reconcileYttSource(yttSource)
+		Expect(currentYttSource.Status.FailureMessage).ToNot(BeNil())
+
+		yttSource.Spec.OCI.SecretRef = &corev1.SecretReference{Name: credentials.Name}
+		currentYttSource = reconcileYttSource(yttSource, credentials)
+		Expect(currentYttSource.Status.FailureMessage).To(BeNil())
+		Expect(currentYttSource.Status.Resources).To(ContainSubstring("name: from-oci"))
+
+		// Credentials must be a dockerconfigjson Secret, whatever the allowed Secret types
+		credentials.Type = corev1.SecretTypeOpaque
+		yttSource.Spec.AllowedSecretTypes = []corev1.SecretType{corev1.SecretTypeOpaque}
+		currentYttSource = reconcileYttSource(yttSource, credentials)
+		Expect(currentYttSource.Status.FailureMessage).ToNot(BeNil())
+		Expect(*currentYttSource.Status.FailureMessage).To(ContainSubstring("secret type not allowed"))
+	})
+
+	It("Reconcile does not serve a cached artifact referenced by digest to a YttSource without credentials", func() {
+		server.Close()
+		registryHandler := registry.New()
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if user, password, ok := r.BasicAuth(); !ok || user != "user" || password != "password" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			registryHandler.ServeHTTP(w, r)
+		}))
+		host = strings.TrimPrefix(server.URL, "http://")
+
+		repository := fmt.Sprintf("%s/%s", host, randomString())
+		digest := pushOCIArtifact(repository+":v1", map[string]string{"app.yaml": ociTemplate},
+			remote.WithAuth(&authn.Basic{Username: "user", Password: "password"}))
+
+		cacheDir, err := os.MkdirTemp("", "oci-cache-")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(cacheDir)
+		cache, err := controllers.NewArtifactCache(cacheDir, 1024*1024)
+		Expect(err).To(BeNil())
+
+		credentials := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: randomString(), Name: randomString()},
+			Type:       corev1.SecretTypeDockerConfigJson,
+			Data: map[string][]byte{
+				corev1.DockerConfigJsonKey: []byte(fmt.Sprintf(`{"auths":{%q:{"auth":%q}}}`, host,
+					base64.StdEncoding.EncodeToString([]byte("user:password")))),
+			},
+		}
+		yttSource := getYttSourceForOCI(credentials.Namespace, &extensionv1beta1.OCISource{
+			Reference: repository + "@" + digest.String(),
+			SecretRef: &corev1.SecretReference{Name: credentials.Name},
+		})
+		// Same artifact, referenced by the same digest, without credentials
+		other := getYttSourceForOCI(randomString(), &extensionv1beta1.OCISource{
+			Reference: repository + "@" + digest.String(),
+		})
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource, other).
+			WithObjects(yttSource, other, credentials).Build()
+		reconciler := getYttSourceReconciler(c)
+		reconciler.ArtifactCache = cache
+
+		_, currentYttSource := reconcileYttSourceWithClient(c, reconciler, yttSource)
+		Expect(currentYttSource.Status.FailureMessage).To(BeNil())
+		Expect(currentYttSource.Status.Resources).To(ContainSubstring("name: from-oci"))
+
+		_, currentYttSource = reconcileYttSourceWithClient(c, reconciler, other)
+		Expect(currentYttSource.Status.FailureMessage).ToNot(BeNil())
+		Expect(*currentYttSource.Status.FailureMessage).To(ContainSubstring("401 Unauthorized"))
+		Expect(currentYttSource.Status.Resources).To(BeEmpty())
+	})
+
+	It("Reconcile refuses Secrets in another namespace", func() {
+		reference := fmt.Sprintf("%s/%s:v1", host, randomString())
+		pushOCIArtifact(reference, map[string]string{"app.yaml": ociTemplate})
+
+		keys := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: randomString(), Name: randomString()},
+			Type:       libsveltosv1beta1.ClusterProfileSecretType,
+		}
+		yttSource := getYttSourceForOCI(randomString(), &extensionv1beta1.OCISource{
+			Reference: reference,
+			Verify: &extensionv1beta1.OCIVerification{
+				SecretRef: corev1.SecretReference{Namespace: keys.Namespace, Name: keys.Name},
+			},
+		})
+
+		currentYttSource := reconcileYttSource(yttSource, keys)
+		Expect(currentYttSource.Status.FailureMessage).ToNot(BeNil())
+		Expect(*currentYttSource.Status.FailureMessage).To(ContainSubstring("cross-namespace reference is not allowed"))
+	})
+
+	It("Reconcile renders only artifacts signed with the cosign public keys", func() {
+		privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).To(BeNil())
+		publicKey, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+		Expect(err).To(BeNil())
+
+		keys := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: randomString(), Name: randomString()},
+			Type:       libsveltosv1beta1.ClusterProfileSecretType,
+			Data: map[string][]byte{
+				"cosign" + extensionv1beta1.CosignPublicKeySuffix: pem.EncodeToMemory(&pem.Block{
+					Type: "PUBLIC KEY", Bytes: publicKey}),
+			},
+		}
+
+		reference := fmt.Sprintf("%s/%s:v1", host, randomString())
+		digest := pushOCIArtifact(reference, map[string]string{"app.yaml": ociTemplate})
+
+		yttSource := getYttSourceForOCI(keys.Namespace, &extensionv1beta1.OCISource{
+			Reference: reference,
+			Verify:    &extensionv1beta1.OCIVerification{SecretRef: corev1.SecretReference{Name: keys.Name}},
+		})
+
+		// Artifact is not signed
+		currentYttSource := reconcileYttSource(yttSource, keys)
+		Expect(currentYttSource.Status.FailureMessage).ToNot(BeNil())
+		Expect(*currentYttSource.Status.FailureMessage).To(ContainSubstring("signature verification failed"))
+
+		// Artifact is signed with another key
+		otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).To(BeNil())
+		signOCIArtifact(reference, digest, otherKey)
+		currentYttSource = reconcileYttSource(yttSource, keys)
+		Expect(currentYttSource.Status.FailureMessage).ToNot(BeNil())
+		Expect(*currentYttSource.Status.FailureMessage).To(ContainSubstring("signature verification failed"))
+
+		signOCIArtifact(reference, digest, privateKey)
+		currentYttSource = reconcileYttSource(yttSource, keys)
+		Expect(currentYttSource.Status.FailureMessage).To(BeNil())
+		Expect(currentYttSource.Status.Resources).To(ContainSubstring("name: from-oci"))
+	})
+})
+
+// getYttSourceForOCI returns a YttSource rendering the artifact described by oci
+func getYttSourceForOCI(namespace string, oci *extensionv1beta1.OCISource) *extensionv1beta1.YttSource {
+	return &extensionv1beta1.YttSource{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       randomString(),
+			Namespace:  namespace,
+			Generation: 1,
+		},
+		Spec: extensionv1beta1.YttSourceSpec{
+			Kind: extensionv1beta1.OCISourceKind,
+			Name: randomString(),
+			OCI:  oci,
+		},
+	}
+}
+
+// pushOCIArtifact pushes an artifact with a single tar layer containing files and
+// returns its digest
+func pushOCIArtifact(reference string, files map[string]string, options ...remote.Option) v1.Hash {
+	ref, err := name.ParseReference(reference)
+	Expect(err).To(BeNil())
+
+	image, err := mutate.AppendLayers(empty.Image,
+		static.NewLayer(getTar(files), types.OCIUncompressedLayer))
+	Expect(err).To(BeNil())
+	Expect(remote.Write(ref, image, options...)).To(Succeed())
+
+	digest, err := image.Digest()
+	Expect(err).To(BeNil())
+	return digest
+}
+
+// signOCIArtifact pushes, the way cosign does, a signature of the artifact with given
+// digest made with privateKey
+func signOCIArtifact(reference string, digest v1.Hash, privateKey *ecdsa.PrivateKey) {
+	ref, err := name.ParseReference(reference)
+	Expect(err).To(BeNil())
+
+	payload := []byte(fmt.Sprintf(`{"critical":{"identity":{"docker-reference":%q},`+
+		`"image":{"docker-manifest-digest":%q},"type":"cosign container image signature"},"optional":null}`,
+		ref.Context().String(), digest.String()))
+	hash := sha256.Sum256(payload)
+	signature, err := ecdsa.SignASN1(rand.Reader, privateKey, hash[:])
+	Expect(err).To(BeNil())
+
+	image, err := mutate.Append(empty.Image, mutate.Addendum{
+		Layer: static.NewLayer(payload, "application/vnd.dev.cosign.simplesigning.v1+json"),
+		Annotations: map[string]string{
+			"dev.cosignproject.cosign/signature": base64.StdEncoding.EncodeToString(signature),
+		},
+	})
+	Expect(err).To(BeNil())
+
+	sigTag := ref.Context().Tag(fmt.Sprintf("%s-%s.sig", digest.Algorithm, digest.Hex))
+	Expect(remote.Write(sigTag, image)).To(Succeed())
+}
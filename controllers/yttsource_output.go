@@ -0,0 +1,311 @@
+/*
+Copyright 2024. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	extensionv1beta1 "github.com/gianlucam76/ytt-controller/api/v1beta1"
+
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+	logs "github.com/projectsveltos/libsveltos/lib/logsettings"
+)
+
+// getOutputReference returns the ObjectReference for the ConfigMap/Secret defined
+// in YttSource Spec.Output. Returns nil if Spec.Output is not set.
+func getOutputReference(yttSource *extensionv1beta1.YttSource) *corev1.ObjectReference {
+	output := yttSource.Spec.Output
+	if output == nil {
+		return nil
+	}
+
+	namespace := output.Namespace
+	if namespace == "" {
+		namespace = yttSource.Namespace
+	}
+
+	return &corev1.ObjectReference{
+		APIVersion: corev1.SchemeGroupVersion.String(),
+		Kind:       output.Kind,
+		Namespace:  namespace,
+		Name:       output.Name,
+	}
+}
+
+func getOutputKey(output *extensionv1beta1.Output) string {
+	if output.Key == "" {
+		return extensionv1beta1.DefaultOutputKey
+	}
+	return output.Key
+}
+
+func getOutputOwnerValue(yttSource *extensionv1beta1.YttSource) string {
+	return fmt.Sprintf("%s/%s", yttSource.Namespace, yttSource.Name)
+}
+
+// getDigest returns the sha256 digest of the output
+func getDigest(output []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(output))
+}
+
+func gzipOutput(output []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	if _, err := gzipWriter.Write(output); err != nil {
+		return nil, err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// handleOutput stores ytt output. If Spec.Output is set, output is written to
+// the referenced ConfigMap/Secret and an empty string is returned. Otherwise
+// output is returned so that it can be stored in Status.Resources.
+// Any ConfigMap/Secret previously used as output and not referenced anymore is removed.
+func (r *YttSourceReconciler) handleOutput(ctx context.Context, yttSource *extensionv1beta1.YttSource,
+	output []byte, logger logr.Logger) (string, error) {
+
+	yttSource.Status.OutputDigest = getDigest(output)
+
+	currentRef := getOutputReference(yttSource)
+	if yttSource.Status.OutputRef != nil &&
+		(currentRef == nil || *currentRef != *yttSource.Status.OutputRef) {
+
+		err := r.deleteOutput(ctx, yttSource, yttSource.Status.OutputRef, logger)
+		if err != nil {
+			return "", err
+		}
+		yttSource.Status.OutputRef = nil
+	}
+
+	if currentRef == nil {
+		return string(output), nil
+	}
+
+	// Controller can create and update Secrets in every namespace. Unless explicitly
+	// allowed, YttSources must not be able to use it to write to other namespaces.
+	if currentRef.Namespace != yttSource.Namespace && !r.AllowCrossNamespaceOutput {
+		return "", fmt.Errorf("%w: %s %s/%s must be in namespace %s", errCrossNamespaceOutput,
+			currentRef.Kind, currentRef.Namespace, currentRef.Name, yttSource.Namespace)
+	}
+
+	// Output can be in a different namespace, where owner references cannot be used.
+	// Finalizer guarantees output is removed when YttSource is deleted.
+	controllerutil.AddFinalizer(yttSource, extensionv1beta1.YttSourceFinalizer)
+
+	if err := r.writeOutput(ctx, yttSource, currentRef, output, logger); err != nil {
+		return "", err
+	}
+
+	yttSource.Status.OutputRef = currentRef
+	return "", nil
+}
+
+// clearOutput removes the last rendered output, both from Status.Resources and
+// from the ConfigMap/Secret defined in Spec.Output.
+func (r *YttSourceReconciler) clearOutput(ctx context.Context, yttSource *extensionv1beta1.YttSource,
+	logger logr.Logger) {
+
+	yttSource.Status.Resources = ""
+	yttSource.Status.OutputDigest = ""
+	yttSource.Status.LastAppliedRevision = ""
+	yttSource.Status.InputDigest = ""
+
+	if yttSource.Status.OutputRef == nil {
+		return
+	}
+
+	if _, err := r.handleOutput(ctx, yttSource, []byte{}, logger); err != nil {
+		logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to clear output: %v", err))
+	}
+	yttSource.Status.OutputDigest = ""
+}
+
+// writeOutput creates or updates the ConfigMap/Secret containing ytt output.
+func (r *YttSourceReconciler) writeOutput(ctx context.Context, yttSource *extensionv1beta1.YttSource,
+	ref *corev1.ObjectReference, output []byte, logger logr.Logger) error {
+
+	key := getOutputKey(yttSource.Spec.Output)
+	if yttSource.Spec.Output.Gzip {
+		var err error
+		output, err = gzipOutput(output)
+		if err != nil {
+			return err
+		}
+	}
+
+	obj, err := newOutputObject(ref)
+	if err != nil {
+		return err
+	}
+	obj.SetNamespace(ref.Namespace)
+	obj.SetName(ref.Name)
+
+	result, err := controllerutil.CreateOrUpdate(ctx, r.Client, obj, func() error {
+		if !isOutputOwnedBy(obj, yttSource) && obj.GetResourceVersion() != "" {
+			return fmt.Errorf("%s %s/%s exists and is not managed by YttSource %s",
+				ref.Kind, ref.Namespace, ref.Name, getOutputOwnerValue(yttSource))
+		}
+
+		annotations := obj.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[extensionv1beta1.OutputOwnerAnnotation] = getOutputOwnerValue(yttSource)
+		obj.SetAnnotations(annotations)
+
+		if ref.Namespace == yttSource.Namespace {
+			if err := controllerutil.SetControllerReference(yttSource, obj, r.Scheme); err != nil {
+				return err
+			}
+		}
+
+		setOutputData(obj, key, output, yttSource.Spec.Output.Gzip)
+		return nil
+	})
+	if err != nil {
+		logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to write output to %s %s/%s: %v",
+			ref.Kind, ref.Namespace, ref.Name, err))
+		return err
+	}
+
+	logger.V(logs.LogDebug).Info(fmt.Sprintf("output %s %s/%s %s", ref.Kind, ref.Namespace, ref.Name, result))
+	return nil
+}
+
+func newOutputObject(ref *corev1.ObjectReference) (client.Object, error) {
+	switch ref.Kind {
+	case string(libsveltosv1beta1.ConfigMapReferencedResourceKind):
+		return &corev1.ConfigMap{}, nil
+	case string(libsveltosv1beta1.SecretReferencedResourceKind):
+		return &corev1.Secret{}, nil
+	default:
+		return nil, fmt.Errorf("output kind %s not supported", ref.Kind)
+	}
+}
+
+// setOutputData replaces the content of the ConfigMap/Secret with output.
+func setOutputData(obj client.Object, key string, output []byte, compressed bool) {
+	switch o := obj.(type) {
+	case *corev1.ConfigMap:
+		if compressed {
+			o.Data = nil
+			o.BinaryData = map[string][]byte{key: output}
+		} else {
+			o.Data = map[string]string{key: string(output)}
+			o.BinaryData = nil
+		}
+	case *corev1.Secret:
+		if o.Type == "" {
+			o.Type = libsveltosv1beta1.ClusterProfileSecretType
+		}
+		o.Data = map[string][]byte{key: output}
+	}
+}
+
+// getOutputData returns the output stored in the ConfigMap/Secret, decompressed
+// when compressed is true.
+func getOutputData(obj client.Object, key string, compressed bool) ([]byte, error) {
+	var data []byte
+	switch o := obj.(type) {
+	case *corev1.ConfigMap:
+		if compressed {
+			data = o.BinaryData[key]
+		} else {
+			data = []byte(o.Data[key])
+		}
+	case *corev1.Secret:
+		data = o.Data[key]
+	}
+
+	if !compressed {
+		return data, nil
+	}
+
+	gzipReader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer gzipReader.Close()
+	return io.ReadAll(gzipReader)
+}
+
+// isOutputOwnedBy returns true if obj was created to store yttSource output
+func isOutputOwnedBy(obj client.Object, yttSource *extensionv1beta1.YttSource) bool {
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		return false
+	}
+	return annotations[extensionv1beta1.OutputOwnerAnnotation] == getOutputOwnerValue(yttSource)
+}
+
+// deleteOutput removes the ConfigMap/Secret used to store yttSource output, if
+// it was created by this YttSource.
+func (r *YttSourceReconciler) deleteOutput(ctx context.Context, yttSource *extensionv1beta1.YttSource,
+	ref *corev1.ObjectReference, logger logr.Logger) error {
+
+	obj, err := newOutputObject(ref)
+	if err != nil {
+		return err
+	}
+
+	err = r.Get(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, obj)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if !isOutputOwnedBy(obj, yttSource) {
+		return nil
+	}
+
+	logger.V(logs.LogDebug).Info(fmt.Sprintf("deleting output %s %s/%s", ref.Kind, ref.Namespace, ref.Name))
+	return client.IgnoreNotFound(r.Delete(ctx, obj))
+}
+
+// reconcileDelete removes any output created by yttSource and then the finalizer.
+func (r *YttSourceReconciler) reconcileDelete(ctx context.Context, yttSource *extensionv1beta1.YttSource,
+	logger logr.Logger) error {
+
+	r.cleanMaps(yttSource)
+	r.clearURLSourceStates(types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name})
+
+	if yttSource.Status.OutputRef != nil {
+		if err := r.deleteOutput(ctx, yttSource, yttSource.Status.OutputRef, logger); err != nil {
+			return err
+		}
+	}
+
+	controllerutil.RemoveFinalizer(yttSource, extensionv1beta1.YttSourceFinalizer)
+	return nil
+}
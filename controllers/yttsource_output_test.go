@@ -0,0 +1,209 @@
+/*
+Copyright 2024. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	extensionv1beta1 "github.com/gianlucam76/ytt-controller/api/v1beta1"
+
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+)
+
+var _ = Describe("YttSource Output", func() {
+	It("Reconcile writes output to a ConfigMap with owner reference", func() {
+		configMap := getYttConfigMap()
+		yttSource := getYttSourceForConfigMap(configMap, "./")
+		yttSource.Spec.Output = &extensionv1beta1.Output{
+			Kind: string(libsveltosv1beta1.ConfigMapReferencedResourceKind),
+			Name: randomString(),
+		}
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource).
+			WithObjects(configMap, yttSource).Build()
+
+		reconciler := getYttSourceReconciler(c)
+		_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+		})
+		Expect(err).To(BeNil())
+
+		currentYttSource := &extensionv1beta1.YttSource{}
+		Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+			currentYttSource)).To(Succeed())
+		Expect(apimeta.IsStatusConditionTrue(currentYttSource.Status.Conditions,
+			extensionv1beta1.ReadyCondition)).To(BeTrue())
+		Expect(currentYttSource.Status.Resources).To(BeEmpty())
+		Expect(currentYttSource.Status.OutputDigest).To(HavePrefix("sha256:"))
+		Expect(currentYttSource.Status.OutputRef).ToNot(BeNil())
+		Expect(currentYttSource.Status.OutputRef.Namespace).To(Equal(yttSource.Namespace))
+		Expect(currentYttSource.Status.OutputRef.Name).To(Equal(yttSource.Spec.Output.Name))
+
+		output := &corev1.ConfigMap{}
+		Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Spec.Output.Name},
+			output)).To(Succeed())
+		Expect(output.Data[extensionv1beta1.DefaultOutputKey]).To(ContainSubstring("kind: Deployment"))
+		Expect(output.OwnerReferences).To(HaveLen(1))
+		Expect(output.OwnerReferences[0].Name).To(Equal(yttSource.Name))
+
+		By("Output modified by someone else is rendered again")
+		output.Data[extensionv1beta1.DefaultOutputKey] = randomString()
+		Expect(c.Update(context.TODO(), output)).To(Succeed())
+		_, err = reconciler.Reconcile(context.TODO(), reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+		})
+		Expect(err).To(BeNil())
+		Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Spec.Output.Name},
+			output)).To(Succeed())
+		Expect(output.Data[extensionv1beta1.DefaultOutputKey]).To(ContainSubstring("kind: Deployment"))
+	})
+
+	It("Reconcile does not write output to a different namespace unless allowed", func() {
+		configMap := getYttConfigMap()
+		yttSource := getYttSourceForConfigMap(configMap, "./")
+		yttSource.Spec.Output = &extensionv1beta1.Output{
+			Kind:      string(libsveltosv1beta1.SecretReferencedResourceKind),
+			Namespace: randomString(),
+			Name:      randomString(),
+		}
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource).
+			WithObjects(configMap, yttSource).Build()
+
+		reconciler := getYttSourceReconciler(c)
+		_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+		})
+		// Retrying cannot help: reconciliation is stalled
+		Expect(err).To(BeNil())
+
+		currentYttSource := &extensionv1beta1.YttSource{}
+		Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+			currentYttSource)).To(Succeed())
+		Expect(currentYttSource.Status.FailureMessage).ToNot(BeNil())
+		Expect(*currentYttSource.Status.FailureMessage).To(ContainSubstring("cross-namespace output is not allowed"))
+
+		secret := &corev1.Secret{}
+		err = c.Get(context.TODO(),
+			types.NamespacedName{Namespace: yttSource.Spec.Output.Namespace, Name: yttSource.Spec.Output.Name},
+			secret)
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("Reconcile writes gzipped output to a Secret in a different namespace", func() {
+		configMap := getYttConfigMap()
+		yttSource := getYttSourceForConfigMap(configMap, "./")
+		yttSource.Spec.Output = &extensionv1beta1.Output{
+			Kind:      string(libsveltosv1beta1.SecretReferencedResourceKind),
+			Namespace: randomString(),
+			Name:      randomString(),
+			Key:       randomString(),
+			Gzip:      true,
+		}
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource).
+			WithObjects(configMap, yttSource).Build()
+
+		reconciler := getYttSourceReconciler(c)
+		reconciler.AllowCrossNamespaceOutput = true
+		_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+		})
+		Expect(err).To(BeNil())
+
+		currentYttSource := &extensionv1beta1.YttSource{}
+		Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+			currentYttSource)).To(Succeed())
+		Expect(currentYttSource.Finalizers).To(ContainElement(extensionv1beta1.YttSourceFinalizer))
+
+		secret := &corev1.Secret{}
+		Expect(c.Get(context.TODO(),
+			types.NamespacedName{Namespace: yttSource.Spec.Output.Namespace, Name: yttSource.Spec.Output.Name},
+			secret)).To(Succeed())
+		Expect(secret.Type).To(Equal(libsveltosv1beta1.ClusterProfileSecretType))
+		Expect(secret.OwnerReferences).To(BeEmpty())
+
+		gzipReader, err := gzip.NewReader(bytes.NewReader(secret.Data[yttSource.Spec.Output.Key]))
+		Expect(err).To(BeNil())
+		content, err := io.ReadAll(gzipReader)
+		Expect(err).To(BeNil())
+		Expect(string(content)).To(ContainSubstring("kind: Deployment"))
+
+		By("Deleting YttSource removes the output Secret")
+		Expect(c.Delete(context.TODO(), currentYttSource)).To(Succeed())
+		_, err = reconciler.Reconcile(context.TODO(), reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+		})
+		Expect(err).To(BeNil())
+
+		err = c.Get(context.TODO(),
+			types.NamespacedName{Namespace: yttSource.Spec.Output.Namespace, Name: yttSource.Spec.Output.Name},
+			secret)
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("Reconcile does not overwrite a ConfigMap not managed by the YttSource", func() {
+		configMap := getYttConfigMap()
+		yttSource := getYttSourceForConfigMap(configMap, "./")
+		existing := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: yttSource.Namespace,
+				Name:      randomString(),
+			},
+			Data: map[string]string{randomString(): randomString()},
+		}
+		yttSource.Spec.Output = &extensionv1beta1.Output{
+			Kind: string(libsveltosv1beta1.ConfigMapReferencedResourceKind),
+			Name: existing.Name,
+		}
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource).
+			WithObjects(configMap, existing, yttSource).Build()
+
+		reconciler := getYttSourceReconciler(c)
+		_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+		})
+		Expect(err).ToNot(BeNil())
+
+		currentYttSource := &extensionv1beta1.YttSource{}
+		Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+			currentYttSource)).To(Succeed())
+		ready := apimeta.FindStatusCondition(currentYttSource.Status.Conditions, extensionv1beta1.ReadyCondition)
+		Expect(ready).ToNot(BeNil())
+		Expect(ready.Reason).To(Equal(extensionv1beta1.OutputWriteFailedReason))
+
+		current := &corev1.ConfigMap{}
+		Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: existing.Namespace, Name: existing.Name},
+			current)).To(Succeed())
+		Expect(current.Data).To(Equal(existing.Data))
+	})
+})
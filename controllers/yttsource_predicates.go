@@ -22,7 +22,6 @@ import (
 
 	"github.com/fluxcd/pkg/apis/meta"
 	sourcev1 "github.com/fluxcd/source-controller/api/v1"
-	sourcev1b2 "github.com/fluxcd/source-controller/api/v1beta2"
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -49,6 +48,15 @@ func ConfigMapPredicates(logger logr.Logger) predicate.Funcs {
 			}
 
 			if !reflect.DeepEqual(oldConfigMap.BinaryData, newConfigMap.BinaryData) {
+				log.V(logs.LogVerbose).Info(
+					"ConfigMap BinaryData changed. Will attempt to reconcile associated YttSources.",
+				)
+				return true
+			}
+
+			// ConfigMaps referenced for data values, or containing ytt files as
+			// plain keys, store their content in Data
+			if !reflect.DeepEqual(oldConfigMap.Data, newConfigMap.Data) {
 				log.V(logs.LogVerbose).Info(
 					"ConfigMap Data changed. Will attempt to reconcile associated YttSources.",
 				)
@@ -145,64 +153,23 @@ var (
 	}
 )
 
-type FluxGitRepositoryPredicate struct {
-	Logger logr.Logger
-}
-
-func (p FluxGitRepositoryPredicate) Create(obj event.TypedCreateEvent[*sourcev1.GitRepository]) bool {
-	return fluxCreatePredicate(obj.Object, p.Logger)
-}
-
-func (p FluxGitRepositoryPredicate) Update(obj event.TypedUpdateEvent[*sourcev1.GitRepository]) bool {
-	return fluxUpdatePredicate(obj.ObjectNew, obj.ObjectOld, p.Logger)
-}
-
-func (p FluxGitRepositoryPredicate) Delete(obj event.TypedDeleteEvent[*sourcev1.GitRepository]) bool {
-	return fluxDeletePredicate(obj.Object, p.Logger)
-}
-
-func (p FluxGitRepositoryPredicate) Generic(obj event.TypedGenericEvent[*sourcev1.GitRepository]) bool {
-	return fluxGenericPredicate(obj.Object, p.Logger)
-}
-
-type FluxOCIRepositoryPredicate struct {
-	Logger logr.Logger
-}
-
-func (p FluxOCIRepositoryPredicate) Create(obj event.TypedCreateEvent[*sourcev1b2.OCIRepository]) bool {
-	return fluxCreatePredicate(obj.Object, p.Logger)
-}
-
-func (p FluxOCIRepositoryPredicate) Update(obj event.TypedUpdateEvent[*sourcev1b2.OCIRepository]) bool {
-	return fluxUpdatePredicate(obj.ObjectNew, obj.ObjectOld, p.Logger)
-}
-
-func (p FluxOCIRepositoryPredicate) Delete(obj event.TypedDeleteEvent[*sourcev1b2.OCIRepository]) bool {
-	return fluxDeletePredicate(obj.Object, p.Logger)
-}
-
-func (p FluxOCIRepositoryPredicate) Generic(obj event.TypedGenericEvent[*sourcev1b2.OCIRepository]) bool {
-	return fluxGenericPredicate(obj.Object, p.Logger)
-}
-
-type FluxBucketPredicate struct {
-	Logger logr.Logger
-}
-
-func (p FluxBucketPredicate) Create(obj event.TypedCreateEvent[*sourcev1b2.Bucket]) bool {
-	return fluxCreatePredicate(obj.Object, p.Logger)
-}
-
-func (p FluxBucketPredicate) Update(obj event.TypedUpdateEvent[*sourcev1b2.Bucket]) bool {
-	return fluxUpdatePredicate(obj.ObjectNew, obj.ObjectOld, p.Logger)
-}
-
-func (p FluxBucketPredicate) Delete(obj event.TypedDeleteEvent[*sourcev1b2.Bucket]) bool {
-	return fluxDeletePredicate(obj.Object, p.Logger)
-}
-
-func (p FluxBucketPredicate) Generic(obj event.TypedGenericEvent[*sourcev1b2.Bucket]) bool {
-	return fluxGenericPredicate(obj.Object, p.Logger)
+// FluxSourcePredicates predicates for Flux sources. YttSourceReconciler watches Flux
+// source events and react to those by reconciling itself based on following predicates
+func FluxSourcePredicates(logger logr.Logger) predicate.Funcs {
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return fluxCreatePredicate(e.Object, logger)
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return fluxUpdatePredicate(e.ObjectNew, e.ObjectOld, logger)
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return fluxDeletePredicate(e.Object, logger)
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return fluxGenericPredicate(e.Object, logger)
+		},
+	}
 }
 
 func fluxCreatePredicate(obj client.Object, logger logr.Logger) bool {
@@ -254,35 +221,20 @@ func fluxGenericPredicate(obj client.Object, logger logr.Logger) bool {
 	return false
 }
 
+// hasArtifactChanged returns true if the artifact exposed by a Flux source has changed.
+// Objects received from the cache have no type information: sources are matched by type.
 func hasArtifactChanged(objNew, objOld client.Object) bool {
-	switch objNew.GetObjectKind().GroupVersionKind().Kind {
-	case sourcev1.GitRepositoryKind:
-		newGitRepo := objNew.(*sourcev1.GitRepository)
-		oldGitRepo := objOld.(*sourcev1.GitRepository)
-		if oldGitRepo == nil ||
-			!isArtifactSame(oldGitRepo.Status.Artifact, newGitRepo.Status.Artifact) {
-
-			return true
-		}
-	case sourcev1b2.BucketKind:
-		newBucket := objNew.(*sourcev1b2.Bucket)
-		oldBucket := objOld.(*sourcev1b2.Bucket)
-		if oldBucket == nil ||
-			!isArtifactSame(oldBucket.Status.Artifact, newBucket.Status.Artifact) {
-
-			return true
-		}
-	case sourcev1b2.OCIRepositoryKind:
-		newOCIRepo := objNew.(*sourcev1b2.OCIRepository)
-		oldOCIRepo := objOld.(*sourcev1b2.OCIRepository)
-		if oldOCIRepo == nil ||
-			!isArtifactSame(oldOCIRepo.Status.Artifact, newOCIRepo.Status.Artifact) {
-
-			return true
-		}
+	newSource, ok := objNew.(sourcev1.Source)
+	if !ok {
+		return false
 	}
 
-	return false
+	oldSource, ok := objOld.(sourcev1.Source)
+	if !ok || reflect.ValueOf(oldSource).IsNil() {
+		return true
+	}
+
+	return !isArtifactSame(oldSource.GetArtifact(), newSource.GetArtifact())
 }
 
 func isArtifactSame(oldArtifact, newArtifact *meta.Artifact) bool {
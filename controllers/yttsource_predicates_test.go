@@ -107,6 +107,25 @@ var _ = Describe("YttSource Predicates: ConfigMapPredicates", func() {
 		result := configMapPredicate.Update(e)
 		Expect(result).To(BeFalse())
 	})
+
+	It("Update returns true when Data has changed", func() {
+		configMapPredicate := controllers.ConfigMapPredicates(logger)
+		configMap.Data = map[string]string{randomString(): randomString()}
+
+		oldConfigMap := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: configMap.Name,
+			},
+		}
+
+		e := event.UpdateEvent{
+			ObjectNew: configMap,
+			ObjectOld: oldConfigMap,
+		}
+
+		result := configMapPredicate.Update(e)
+		Expect(result).To(BeTrue())
+	})
 })
 
 var _ = Describe("YttSource Predicates: SecretPredicates", func() {
@@ -201,19 +220,19 @@ var _ = Describe("YttSource Predicates: FluxSourcePredicates", func() {
 	})
 
 	It("Create reprocesses", func() {
-		sourcePredicate := controllers.FluxGitRepositoryPredicate{Logger: logger}
+		sourcePredicate := controllers.FluxSourcePredicates(logger)
 
-		result := sourcePredicate.Create(event.TypedCreateEvent[*sourcev1.GitRepository]{Object: gitRepository})
+		result := sourcePredicate.Create(event.CreateEvent{Object: gitRepository})
 		Expect(result).To(BeTrue())
 	})
 	It("Delete does reprocess", func() {
-		sourcePredicate := controllers.FluxGitRepositoryPredicate{Logger: logger}
+		sourcePredicate := controllers.FluxSourcePredicates(logger)
 
-		result := sourcePredicate.Delete(event.TypedDeleteEvent[*sourcev1.GitRepository]{Object: gitRepository})
+		result := sourcePredicate.Delete(event.DeleteEvent{Object: gitRepository})
 		Expect(result).To(BeTrue())
 	})
 	It("Update reprocesses when artifact has changed", func() {
-		sourcePredicate := controllers.FluxGitRepositoryPredicate{Logger: logger}
+		sourcePredicate := controllers.FluxSourcePredicates(logger)
 
 		gitRepository.Status.Artifact = &meta.Artifact{
 			Revision: randomString(),
@@ -228,12 +247,12 @@ var _ = Describe("YttSource Predicates: FluxSourcePredicates", func() {
 
 		controllers.AddTypeInformationToObject(scheme, oldGitRepository)
 
-		result := sourcePredicate.Update(event.TypedUpdateEvent[*sourcev1.GitRepository]{
+		result := sourcePredicate.Update(event.UpdateEvent{
 			ObjectNew: gitRepository, ObjectOld: oldGitRepository})
 		Expect(result).To(BeTrue())
 	})
 	It("Update does not reprocess when artifact has not changed", func() {
-		sourcePredicate := controllers.FluxGitRepositoryPredicate{Logger: logger}
+		sourcePredicate := controllers.FluxSourcePredicates(logger)
 
 		gitRepository.Status.Artifact = &meta.Artifact{
 			Revision: randomString(),
@@ -249,7 +268,7 @@ var _ = Describe("YttSource Predicates: FluxSourcePredicates", func() {
 
 		controllers.AddTypeInformationToObject(scheme, oldGitRepository)
 
-		result := sourcePredicate.Update(event.TypedUpdateEvent[*sourcev1.GitRepository]{
+		result := sourcePredicate.Update(event.UpdateEvent{
 			ObjectNew: gitRepository, ObjectOld: oldGitRepository})
 		Expect(result).To(BeFalse())
 	})
@@ -0,0 +1,80 @@
+/*
+Copyright 2024. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"carvel.dev/ytt/pkg/yamlmeta"
+
+	extensionv1beta1 "github.com/gianlucam76/ytt-controller/api/v1beta1"
+)
+
+// getRedactedResources returns ytt output, as stored in Status.Resources, with the values
+// of data and stringData of all Secrets replaced according to mode. docSet is not modified.
+func getRedactedResources(docSet *yamlmeta.DocumentSet, mode extensionv1beta1.SecretRedactionMode,
+) (string, error) {
+
+	redacted := docSet.DeepCopy()
+	for _, doc := range redacted.Items {
+		resource, ok := doc.Value.(*yamlmeta.Map)
+		if !ok || !isSecret(resource) {
+			continue
+		}
+
+		for _, item := range resource.Items {
+			if item.Key != "data" && item.Key != "stringData" {
+				continue
+			}
+			values, ok := item.Value.(*yamlmeta.Map)
+			if !ok {
+				continue
+			}
+			for _, value := range values.Items {
+				value.Value = redactValue(value.Value, mode)
+			}
+		}
+	}
+
+	bs, err := redacted.AsBytes()
+	if err != nil {
+		return "", err
+	}
+	return string(bs), nil
+}
+
+// isSecret returns true if resource is a core v1 Secret
+func isSecret(resource *yamlmeta.Map) bool {
+	var apiVersion, kind interface{}
+	for _, item := range resource.Items {
+		switch item.Key {
+		case "apiVersion":
+			apiVersion = item.Value
+		case "kind":
+			kind = item.Value
+		}
+	}
+	return apiVersion == "v1" && kind == "Secret"
+}
+
+func redactValue(value interface{}, mode extensionv1beta1.SecretRedactionMode) interface{} {
+	if mode == extensionv1beta1.SecretRedactionHash {
+		return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(fmt.Sprint(value))))
+	}
+	return extensionv1beta1.RedactedValue
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2024. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	extensionv1beta1 "github.com/gianlucam76/ytt-controller/api/v1beta1"
+
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+)
+
+const (
+	credentialsTemplate = `apiVersion: v1
+kind: Secret
+metadata:
+  name: credentials
+data:
+  token: c2VjcmV0LXRva2Vu
+stringData:
+  db_password: staging-password
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+data:
+  db_user: staging-user
+`
+)
+
+var _ = Describe("YttSource Controller: secret redaction", func() {
+	It("Reconcile reports Secrets values as rendered by default", func() {
+		currentYttSource := reconcileYttSource(getYttSourceWithCredentials(""))
+		Expect(currentYttSource.Status.Resources).To(ContainSubstring("db_password: staging-password"))
+		Expect(currentYttSource.Status.Resources).To(ContainSubstring("token: c2VjcmV0LXRva2Vu"))
+	})
+
+	It("Reconcile redacts Secrets values in Status.Resources", func() {
+		currentYttSource := reconcileYttSource(getYttSourceWithCredentials(extensionv1beta1.SecretRedactionRedact))
+		Expect(currentYttSource.Status.FailureMessage).To(BeNil())
+		Expect(currentYttSource.Status.Resources).ToNot(ContainSubstring("staging-password"))
+		Expect(currentYttSource.Status.Resources).ToNot(ContainSubstring("c2VjcmV0LXRva2Vu"))
+		Expect(currentYttSource.Status.Resources).To(ContainSubstring("db_password: " + extensionv1beta1.RedactedValue))
+		Expect(currentYttSource.Status.Resources).To(ContainSubstring("token: " + extensionv1beta1.RedactedValue))
+		// Only Secrets are redacted
+		Expect(currentYttSource.Status.Resources).To(ContainSubstring("db_user: staging-user"))
+	})
+
+	It("Reconcile hashes Secrets values in Status.Resources", func() {
+		currentYttSource := reconcileYttSource(getYttSourceWithCredentials(extensionv1beta1.SecretRedactionHash))
+		Expect(currentYttSource.Status.FailureMessage).To(BeNil())
+		Expect(currentYttSource.Status.Resources).ToNot(ContainSubstring("db_password: staging-password"))
+		Expect(currentYttSource.Status.Resources).To(ContainSubstring(
+			fmt.Sprintf("db_password: sha256:%x", sha256.Sum256([]byte("staging-password")))))
+	})
+
+	It("Reconcile writes real values to output and redacted values to Status.Resources", func() {
+		yttSource := getYttSourceWithCredentials(extensionv1beta1.SecretRedactionRedact)
+		yttSource.Spec.Output = &extensionv1beta1.Output{
+			Kind: string(libsveltosv1beta1.SecretReferencedResourceKind),
+			Name: randomString(),
+		}
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource).
+			WithObjects(yttSource).Build()
+
+		reconciler := getYttSourceReconciler(c)
+		_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+		})
+		Expect(err).To(BeNil())
+
+		currentYttSource := &extensionv1beta1.YttSource{}
+		Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+			currentYttSource)).To(Succeed())
+		Expect(currentYttSource.Status.Resources).To(ContainSubstring("db_password: " + extensionv1beta1.RedactedValue))
+		Expect(currentYttSource.Status.Resources).ToNot(ContainSubstring("staging-password"))
+
+		secret := &corev1.Secret{}
+		Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Spec.Output.Name},
+			secret)).To(Succeed())
+		Expect(string(secret.Data[extensionv1beta1.DefaultOutputKey])).To(ContainSubstring("db_password: staging-password"))
+	})
+})
+
+// getYttSourceWithCredentials returns a YttSource rendering a Secret and a ConfigMap
+func getYttSourceWithCredentials(mode extensionv1beta1.SecretRedactionMode) *extensionv1beta1.YttSource {
+	return &extensionv1beta1.YttSource{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       randomString(),
+			Namespace:  randomString(),
+			Generation: 1,
+		},
+		Spec: extensionv1beta1.YttSourceSpec{
+			Files:           map[string]string{"credentials.yaml": credentialsTemplate},
+			SecretRedaction: mode,
+		},
+	}
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2024. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	yttcmd "carvel.dev/ytt/pkg/cmd/template"
+	yttui "carvel.dev/ytt/pkg/cmd/ui"
+	"k8s.io/apimachinery/pkg/types"
+
+	extensionv1beta1 "github.com/gianlucam76/ytt-controller/api/v1beta1"
+)
+
+const (
+	defaultMaxRenderTimeout = 30 * time.Second
+)
+
+var (
+	// errRenderTimeout is returned when ytt does not complete evaluating the templates in time
+	errRenderTimeout = errors.New("ytt evaluation timed out")
+
+	// errOutputTooLarge is returned when ytt output exceeds MaxOutputSize
+	errOutputTooLarge = errors.New("ytt output too large")
+
+	// errEvaluationNotStarted is returned when ytt evaluation could not start in time,
+	// either because no evaluation slot became available or because the previous,
+	// abandoned, evaluation of the same YttSource is still running
+	errEvaluationNotStarted = errors.New("ytt evaluation could not start")
+)
+
+// getRenderTimeout returns the time ytt can spend evaluating the templates of
+// yttSource: Spec.Timeout, capped by MaxRenderTimeout.
+func (r *YttSourceReconciler) getRenderTimeout(yttSource *extensionv1beta1.YttSource) time.Duration {
+	maxTimeout := r.MaxRenderTimeout
+	if maxTimeout <= 0 {
+		maxTimeout = defaultMaxRenderTimeout
+	}
+
+	if yttSource.Spec.Timeout != nil && yttSource.Spec.Timeout.Duration > 0 &&
+		yttSource.Spec.Timeout.Duration < maxTimeout {
+
+		return yttSource.Spec.Timeout.Duration
+	}
+
+	return maxTimeout
+}
+
+// evaluate runs ytt on input within the render timeout of yttSource. Waiting for a
+// slot in the evaluation pool counts towards the timeout.
+// ytt (Starlark) evaluation cannot be interrupted, nor can its memory be bounded: ytt
+// does not expose the Starlark threads it runs. On timeout, the reconcile worker and the
+// slot in the evaluation pool are released right away, while the evaluation is abandoned
+// and keeps running till it returns. Abandoned evaluations are counted by the
+// abandonedEvaluations gauge. A new evaluation of the same YttSource is not started till
+// the abandoned one returns, so a runaway template runs at most one abandoned evaluation.
+func (r *YttSourceReconciler) evaluate(ctx context.Context, yttSource *extensionv1beta1.YttSource,
+	templatingOptions *yttcmd.Options, input yttcmd.Input) (yttcmd.Output, error) {
+
+	key := types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name}
+	if !r.startEvaluation(key) {
+		return yttcmd.Output{}, fmt.Errorf("%w: previous evaluation is still running", errEvaluationNotStarted)
+	}
+
+	timeout := r.getRenderTimeout(yttSource)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// ytt evaluation is CPU heavy. Wait for a free slot.
+	release, err := r.evaluationPool.acquire(ctx)
+	if err != nil {
+		r.endEvaluation(key)
+		if errors.Is(err, context.DeadlineExceeded) {
+			return yttcmd.Output{}, fmt.Errorf("%w: no evaluation slot available after %s",
+				errEvaluationNotStarted, timeout)
+		}
+		return yttcmd.Output{}, err
+	}
+
+	// The slot is released either when evaluation returns or on timeout, whichever comes first
+	var releaseOnce sync.Once
+	releaseSlot := func() { releaseOnce.Do(release) }
+
+	// Buffered, so an abandoned evaluation can always complete
+	result := make(chan yttcmd.Output, 1)
+	evaluationStart := time.Now()
+	go func() {
+		defer r.endEvaluation(key)
+		defer releaseSlot()
+		noopUI := yttui.NewCustomWriterTTY(false, noopWriter{}, noopWriter{})
+		result <- templatingOptions.RunWithFiles(input, noopUI)
+	}()
+
+	select {
+	case output := <-result:
+		observeEvaluationDuration(yttSource, evaluationStart)
+		return output, nil
+	case <-ctx.Done():
+		observeEvaluationDuration(yttSource, evaluationStart)
+		r.abandonEvaluation(key)
+		releaseSlot()
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return yttcmd.Output{}, fmt.Errorf("%w after %s", errRenderTimeout, timeout)
+		}
+		return yttcmd.Output{}, ctx.Err()
+	}
+}
+
+// startEvaluation records that an evaluation of the YttSource with given key is running.
+// Returns false if one already is.
+func (r *YttSourceReconciler) startEvaluation(key types.NamespacedName) bool {
+	r.evaluationsMux.Lock()
+	defer r.evaluationsMux.Unlock()
+
+	if r.runningEvaluations == nil {
+		r.runningEvaluations = make(map[types.NamespacedName]bool)
+	}
+	if _, ok := r.runningEvaluations[key]; ok {
+		return false
+	}
+	r.runningEvaluations[key] = false
+	return true
+}
+
+// abandonEvaluation records that the running evaluation of the YttSource with given key
+// was abandoned on timeout. Does nothing if the evaluation already returned.
+func (r *YttSourceReconciler) abandonEvaluation(key types.NamespacedName) {
+	r.evaluationsMux.Lock()
+	defer r.evaluationsMux.Unlock()
+
+	if abandoned, ok := r.runningEvaluations[key]; ok && !abandoned {
+		r.runningEvaluations[key] = true
+		abandonedEvaluations.Inc()
+	}
+}
+
+// endEvaluation records that the evaluation of the YttSource with given key returned
+func (r *YttSourceReconciler) endEvaluation(key types.NamespacedName) {
+	r.evaluationsMux.Lock()
+	defer r.evaluationsMux.Unlock()
+
+	if r.runningEvaluations[key] {
+		abandonedEvaluations.Dec()
+	}
+	delete(r.runningEvaluations, key)
+}
+
+// checkOutputSize returns an error if output exceeds MaxOutputSize
+func (r *YttSourceReconciler) checkOutputSize(output []byte) error {
+	if r.MaxOutputSize > 0 && int64(len(output)) > r.MaxOutputSize {
+		return fmt.Errorf("%w: %d bytes, maximum allowed is %d bytes", errOutputTooLarge,
+			len(output), r.MaxOutputSize)
+	}
+	return nil
+}
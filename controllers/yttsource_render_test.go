@@ -0,0 +1,197 @@
+/*
+Copyright 2024. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	extensionv1beta1 "github.com/gianlucam76/ytt-controller/api/v1beta1"
+	"github.com/gianlucam76/ytt-controller/controllers"
+)
+
+const (
+	// slowTemplate takes about a second to evaluate
+	slowTemplate = `#@ def spin():
+#@   n = 0
+#@   for i in range(2000000):
+#@     n += i
+#@   end
+#@   return n
+#@ end
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: spin
+data:
+  value: #@ str(spin())
+`
+)
+
+var _ = Describe("YttSource Controller: render limits", func() {
+	It("getRenderTimeout returns Spec.Timeout capped by MaxRenderTimeout", func() {
+		reconciler := getYttSourceReconciler(nil)
+		yttSource := &extensionv1beta1.YttSource{}
+
+		// Defaults to manager default
+		Expect(controllers.GetRenderTimeout(reconciler, yttSource)).To(Equal(30 * time.Second))
+
+		reconciler.MaxRenderTimeout = time.Minute
+		Expect(controllers.GetRenderTimeout(reconciler, yttSource)).To(Equal(time.Minute))
+
+		yttSource.Spec.Timeout = &metav1.Duration{Duration: 10 * time.Second}
+		Expect(controllers.GetRenderTimeout(reconciler, yttSource)).To(Equal(10 * time.Second))
+
+		yttSource.Spec.Timeout = &metav1.Duration{Duration: time.Hour}
+		Expect(controllers.GetRenderTimeout(reconciler, yttSource)).To(Equal(time.Minute))
+	})
+
+	It("Reconcile reports RenderTimeout when ytt evaluation exceeds Spec.Timeout", func() {
+		yttSource := getYttSourceWithFiles(map[string]string{"spin.yaml": slowTemplate})
+		yttSource.Spec.Timeout = &metav1.Duration{Duration: 100 * time.Millisecond}
+
+		start := time.Now()
+		currentYttSource := reconcileYttSource(yttSource)
+		Expect(time.Since(start)).To(BeNumerically("<", 900*time.Millisecond))
+
+		Expect(currentYttSource.Status.FailureMessage).ToNot(BeNil())
+		Expect(*currentYttSource.Status.FailureMessage).To(ContainSubstring("timed out"))
+		templateRendered := apimeta.FindStatusCondition(currentYttSource.Status.Conditions,
+			extensionv1beta1.TemplateRenderedCondition)
+		Expect(templateRendered).ToNot(BeNil())
+		Expect(templateRendered.Reason).To(Equal(extensionv1beta1.RenderTimeoutReason))
+		Expect(apimeta.IsStatusConditionTrue(currentYttSource.Status.Conditions,
+			extensionv1beta1.StalledCondition)).To(BeTrue())
+	})
+
+	It("Reconcile does not start a new evaluation while the abandoned one is running", func() {
+		abandoned := func() float64 { return testutil.ToFloat64(controllers.AbandonedEvaluations) }
+		// Evaluations abandoned by other tests return
+		Eventually(abandoned, 10*time.Second, 50*time.Millisecond).Should(BeZero())
+
+		yttSource := getYttSourceWithFiles(map[string]string{"spin.yaml": slowTemplate})
+		yttSource.Spec.Timeout = &metav1.Duration{Duration: 100 * time.Millisecond}
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource).
+			WithObjects(yttSource).Build()
+		reconciler := getYttSourceReconciler(c)
+		pool := controllers.SetEvaluationPool(reconciler, 1)
+
+		_, currentYttSource := reconcileYttSourceWithClient(c, reconciler, yttSource)
+		Expect(*currentYttSource.Status.FailureMessage).To(ContainSubstring("timed out"))
+		// Abandoned evaluation releases its slot and is counted
+		Expect(controllers.InUse(pool)).To(BeZero())
+		Expect(abandoned()).To(Equal(float64(1)))
+
+		// Any change causes a new reconciliation. Evaluation does not start again.
+		currentYttSource.Spec.Timeout = &metav1.Duration{Duration: 10 * time.Second}
+		currentYttSource.Generation++
+		Expect(c.Update(context.TODO(), currentYttSource)).To(Succeed())
+		_, currentYttSource = reconcileYttSourceWithClient(c, reconciler, currentYttSource)
+		Expect(*currentYttSource.Status.FailureMessage).To(ContainSubstring("previous evaluation is still running"))
+		Expect(apimeta.IsStatusConditionTrue(currentYttSource.Status.Conditions,
+			extensionv1beta1.StalledCondition)).To(BeFalse())
+		Expect(abandoned()).To(Equal(float64(1)))
+
+		// Once the abandoned evaluation returns, templates are evaluated again
+		Eventually(abandoned, 10*time.Second, 50*time.Millisecond).Should(BeZero())
+		_, currentYttSource = reconcileYttSourceWithClient(c, reconciler, currentYttSource)
+		Expect(currentYttSource.Status.FailureMessage).To(BeNil())
+		Expect(currentYttSource.Status.Resources).To(ContainSubstring("name: spin"))
+	})
+
+	It("Reconcile reports RenderTimeout when no evaluation slot is available in time", func() {
+		yttSource := getYttSourceWithFiles(map[string]string{"credentials.yaml": credentialsTemplate})
+		yttSource.Spec.Timeout = &metav1.Duration{Duration: 100 * time.Millisecond}
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource).
+			WithObjects(yttSource).Build()
+		reconciler := getYttSourceReconciler(c)
+		pool := controllers.SetEvaluationPool(reconciler, 1)
+
+		// A runaway evaluation holds the only slot
+		release, err := controllers.Acquire(pool, context.TODO())
+		Expect(err).To(BeNil())
+		defer release()
+
+		start := time.Now()
+		result, err := reconciler.Reconcile(context.TODO(), reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+		})
+		Expect(time.Since(start)).To(BeNumerically("<", 900*time.Millisecond))
+		// Not stalled: reconciliation is retried
+		Expect(err).ToNot(BeNil())
+		Expect(result.RequeueAfter).To(BeZero())
+
+		currentYttSource := &extensionv1beta1.YttSource{}
+		Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+			currentYttSource)).To(Succeed())
+		Expect(*currentYttSource.Status.FailureMessage).To(ContainSubstring("no evaluation slot available"))
+		templateRendered := apimeta.FindStatusCondition(currentYttSource.Status.Conditions,
+			extensionv1beta1.TemplateRenderedCondition)
+		Expect(templateRendered).ToNot(BeNil())
+		Expect(templateRendered.Reason).To(Equal(extensionv1beta1.RenderTimeoutReason))
+	})
+
+	It("Reconcile reports OutputTooLarge when ytt output exceeds MaxOutputSize", func() {
+		yttSource := getYttSourceWithFiles(map[string]string{"credentials.yaml": credentialsTemplate})
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource).
+			WithObjects(yttSource).Build()
+
+		reconciler := getYttSourceReconciler(c)
+		reconciler.MaxOutputSize = 64
+		_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+		})
+		Expect(err).To(BeNil())
+
+		currentYttSource := &extensionv1beta1.YttSource{}
+		Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+			currentYttSource)).To(Succeed())
+		Expect(currentYttSource.Status.Resources).To(BeEmpty())
+		templateRendered := apimeta.FindStatusCondition(currentYttSource.Status.Conditions,
+			extensionv1beta1.TemplateRenderedCondition)
+		Expect(templateRendered).ToNot(BeNil())
+		Expect(templateRendered.Reason).To(Equal(extensionv1beta1.OutputTooLargeReason))
+	})
+})
+
+// getYttSourceWithFiles returns a YttSource containing only inline files
+func getYttSourceWithFiles(files map[string]string) *extensionv1beta1.YttSource {
+	return &extensionv1beta1.YttSource{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       randomString(),
+			Namespace:  randomString(),
+			Generation: 1,
+		},
+		Spec: extensionv1beta1.YttSourceSpec{
+			Files: files,
+		},
+	}
+}
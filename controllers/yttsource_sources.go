@@ -0,0 +1,408 @@
+/*
+Copyright 2024. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	yttcmd "carvel.dev/ytt/pkg/cmd/template"
+	yttfiles "carvel.dev/ytt/pkg/files"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	extensionv1beta1 "github.com/gianlucam76/ytt-controller/api/v1beta1"
+
+	logs "github.com/projectsveltos/libsveltos/lib/logsettings"
+)
+
+const (
+	// inlineSourceKind identifies inline files in revisions and metrics
+	inlineSourceKind = "Inline"
+
+	// defaultPollInterval is the interval remote sources are polled at when
+	// their interval is not set
+	defaultPollInterval = 5 * time.Minute
+)
+
+// sourceMount is the content of a source, fetched in a directory, and where
+// its files are placed in the ytt input.
+type sourceMount struct {
+	ref *corev1.ObjectReference
+	// dir is the directory the source was fetched in. Must not be modified
+	// as it might be shared with other YttSources.
+	dir string
+	// path is the directory, relative to dir, containing ytt files.
+	path string
+	// prefix is the directory, relative to the root of ytt input, files are placed in.
+	prefix string
+	// overlay indicates files must be placed after files of all other sources
+	overlay bool
+	// revision is the revision of the source which has been fetched
+	revision string
+}
+
+// getSources returns all sources containing ytt files referenced by YttSource, in order.
+// When set, Spec.Kind/Name is the first source.
+func getSources(yttSource *extensionv1beta1.YttSource) []extensionv1beta1.SourceReference {
+	sources := make([]extensionv1beta1.SourceReference, 0, len(yttSource.Spec.Sources)+1)
+	if yttSource.Spec.Kind != "" {
+		sources = append(sources, extensionv1beta1.SourceReference{
+			Kind:       yttSource.Spec.Kind,
+			URL:        yttSource.Spec.URL,
+			OCI:        yttSource.Spec.OCI,
+			Namespace:  yttSource.Spec.Namespace,
+			Name:       yttSource.Spec.Name,
+			Path:       yttSource.Spec.Path,
+			DataLayout: yttSource.Spec.DataLayout,
+		})
+	}
+
+	return append(sources, yttSource.Spec.Sources...)
+}
+
+// getSourceReference returns the ObjectReference for a source referenced by YttSource.
+// Unless allowCrossNamespace is set, an error is returned if the source is in a namespace
+// different from the YttSource one.
+func getSourceReference(yttSource *extensionv1beta1.YttSource, source *extensionv1beta1.SourceReference,
+	allowCrossNamespace bool) (*corev1.ObjectReference, error) {
+
+	namespace, err := getReferenceNamespace(yttSource, source.Namespace, allowCrossNamespace)
+	if err != nil {
+		return nil, fmt.Errorf("source %s %s: %w", source.Kind, source.Name, err)
+	}
+
+	return &corev1.ObjectReference{
+		APIVersion: getReferenceAPIVersion(source.Kind),
+		Kind:       source.Kind,
+		Namespace:  namespace,
+		Name:       source.Name,
+	}, nil
+}
+
+// getReferenceNamespace returns the namespace of a resource referenced by yttSource:
+// namespace or, when empty, the YttSource one. Unless allowCrossNamespace is set, an
+// error is returned if that is not the YttSource namespace. Webhook enforces the same
+// policy, but it can be disabled.
+func getReferenceNamespace(yttSource *extensionv1beta1.YttSource, namespace string,
+	allowCrossNamespace bool) (string, error) {
+
+	if namespace == "" {
+		return yttSource.Namespace, nil
+	}
+	if namespace != yttSource.Namespace && !allowCrossNamespace {
+		return "", fmt.Errorf("%w: namespace must be %s, not %s", errCrossNamespaceReference,
+			yttSource.Namespace, namespace)
+	}
+	return namespace, nil
+}
+
+// isRemoteSource returns true if source is not a resource in the cluster but is fetched
+// from a remote location (a URL or an OCI registry). Changes of such sources cannot be watched.
+func isRemoteSource(source *extensionv1beta1.SourceReference) bool {
+	return source.Kind == extensionv1beta1.URLSourceKind || source.Kind == extensionv1beta1.OCISourceKind
+}
+
+// getSourceSecretReferences returns the Secrets a remote source is fetched with
+// (HTTP headers, CA bundle, registry credentials, cosign public keys)
+func getSourceSecretReferences(namespace string, source *extensionv1beta1.SourceReference) []corev1.ObjectReference {
+	switch source.Kind {
+	case extensionv1beta1.URLSourceKind:
+		return getURLSourceSecretReferences(namespace, source.URL)
+	case extensionv1beta1.OCISourceKind:
+		return getOCISourceSecretReferences(namespace, source.OCI)
+	}
+	return nil
+}
+
+// getSourceSecret returns the Secret, referenced by a remote source, the source is fetched
+// with. The content of such Secrets is sent to a location chosen by whoever creates the
+// YttSource, so whatever cross-namespace references are allowed, they must be in the
+// YttSource namespace. An error is returned if the Secret type is not one of allowedTypes.
+func getSourceSecret(ctx context.Context, c client.Client, namespace string, ref *corev1.SecretReference,
+	allowedTypes []corev1.SecretType) (*corev1.Secret, error) {
+
+	if ref.Namespace != "" && ref.Namespace != namespace {
+		return nil, fmt.Errorf("%w: Secret %s/%s must be in namespace %s", errCrossNamespaceReference,
+			ref.Namespace, ref.Name, namespace)
+	}
+	return getSecret(ctx, c, types.NamespacedName{Namespace: namespace, Name: ref.Name}, allowedTypes)
+}
+
+// getPollInterval returns after how long YttSource must be reconciled again to detect
+// changes of its remote sources, or zero if it does not need to. Tarballs pinned by
+// checksum and artifacts referenced by digest cannot change.
+func getPollInterval(yttSource *extensionv1beta1.YttSource) time.Duration {
+	var interval time.Duration
+	sources := getSources(yttSource)
+	for i := range sources {
+		var sourceInterval *metav1.Duration
+		switch {
+		case sources[i].Kind == extensionv1beta1.URLSourceKind && sources[i].URL != nil &&
+			sources[i].URL.Checksum == "":
+			sourceInterval = sources[i].URL.Interval
+		case sources[i].Kind == extensionv1beta1.OCISourceKind && sources[i].OCI != nil &&
+			!strings.Contains(sources[i].OCI.Reference, "@"):
+			sourceInterval = sources[i].OCI.Interval
+		default:
+			continue
+		}
+
+		current := defaultPollInterval
+		if sourceInterval != nil && sourceInterval.Duration > 0 {
+			current = sourceInterval.Duration
+		}
+		if interval == 0 || current < interval {
+			interval = current
+		}
+	}
+	return interval
+}
+
+// getMountPrefix returns the directory, relative to the root of ytt input, files of
+// source are placed in. Libraries are placed in the ytt library directory.
+func getMountPrefix(source *extensionv1beta1.SourceReference) string {
+	if source.Type != extensionv1beta1.SourceTypeLibrary {
+		return source.MountPrefix
+	}
+
+	libraryName := source.LibraryName
+	if libraryName == "" {
+		libraryName = source.Name
+	}
+	return path.Join(extensionv1beta1.LibraryDirectory, libraryName, source.MountPrefix)
+}
+
+// prepareSources fetches the content of all sources referenced by YttSource.
+// It returns the fetched sources and a function to invoke once done with them.
+func (r *YttSourceReconciler) prepareSources(ctx context.Context, yttSource *extensionv1beta1.YttSource,
+	logger logr.Logger) ([]sourceMount, func(), error) {
+
+	sources := getSources(yttSource)
+	if len(sources) == 0 && len(yttSource.Spec.Files) == 0 {
+		return nil, nil, fmt.Errorf("%w: no source referenced", errSourceNotFound)
+	}
+
+	var cleanups []func()
+	cleanup := func() {
+		for i := range cleanups {
+			cleanups[i]()
+		}
+	}
+
+	allowedSecretTypes := r.getAllowedSecretTypes(yttSource)
+	r.pruneURLSourceStates(types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name}, sources)
+
+	mounts := make([]sourceMount, len(sources))
+	for i := range sources {
+		ref, err := getSourceReference(yttSource, &sources[i], r.AllowCrossNamespaceReferences)
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		var dir, revision string
+		var sourceCleanup func()
+		switch sources[i].Kind {
+		case extensionv1beta1.URLSourceKind:
+			dir, revision, sourceCleanup, err = r.prepareFileSystemWithURL(ctx, yttSource, i, sources[i].URL,
+				allowedSecretTypes, logger)
+		case extensionv1beta1.OCISourceKind:
+			dir, revision, sourceCleanup, err = prepareFileSystemWithOCI(ctx, r.Client, r.ArtifactCache,
+				r.downloadPool, yttSource.Namespace, sources[i].OCI, allowedSecretTypes, logger)
+		default:
+			dir, revision, sourceCleanup, err = r.prepareFileSystem(ctx, ref, sources[i].DataLayout,
+				allowedSecretTypes, logger)
+		}
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		cleanups = append(cleanups, sourceCleanup)
+
+		mounts[i] = sourceMount{
+			ref:      ref,
+			dir:      dir,
+			path:     sources[i].Path,
+			prefix:   getMountPrefix(&sources[i]),
+			overlay:  sources[i].Type == extensionv1beta1.SourceTypeOverlay,
+			revision: revision,
+		}
+	}
+
+	return mounts, cleanup, nil
+}
+
+// getRevision returns the revision of the fetched sources and inline files. With a single
+// source and no inline files, that is the source revision. With inline files only, that
+// is the digest of inline files.
+func getRevision(mounts []sourceMount, files map[string]string) string {
+	if len(mounts) == 1 && len(files) == 0 {
+		return mounts[0].revision
+	}
+	if len(mounts) == 0 {
+		return getFilesDigest(files)
+	}
+
+	revisions := make([]string, len(mounts))
+	for i := range mounts {
+		revisions[i] = fmt.Sprintf("%s/%s/%s@%s", mounts[i].ref.Kind, mounts[i].ref.Namespace,
+			mounts[i].ref.Name, mounts[i].revision)
+	}
+	if len(files) != 0 {
+		revisions = append(revisions, fmt.Sprintf("%s@%s", inlineSourceKind, getFilesDigest(files)))
+	}
+	return strings.Join(revisions, ", ")
+}
+
+// getFilesDigest returns a digest of inline files
+func getFilesDigest(files map[string]string) string {
+	h := sha256.New()
+	for _, name := range getSortedFileNames(files) {
+		fmt.Fprintf(h, "%s=%s\n", name, files[name])
+	}
+	return fmt.Sprintf("sha256:%x", h.Sum(nil))
+}
+
+func getSortedFileNames(files map[string]string) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// verifyPaths returns an error if the path of any source does not exist
+func verifyPaths(mounts []sourceMount, logger logr.Logger) error {
+	for i := range mounts {
+		dirPath := filepath.Join(mounts[i].dir, mounts[i].path)
+		if _, err := os.Stat(dirPath); err != nil {
+			logger.V(logs.LogInfo).Info(fmt.Sprintf("ytt path not found: %v", err))
+			if len(mounts) == 1 {
+				return fmt.Errorf("path %s not found in source", mounts[i].path)
+			}
+			return fmt.Errorf("path %s not found in %s %s/%s", mounts[i].path,
+				mounts[i].ref.Kind, mounts[i].ref.Namespace, mounts[i].ref.Name)
+		}
+	}
+	return nil
+}
+
+// templatesAsInput wraps the files of all sources, each in a files.File, into a template.Input.
+// Each file is named after its path relative to the source path, within the source mount prefix,
+// as if ytt was invoked on a single directory containing all sources.
+// Files from overlay sources come after templates and libraries, so that ytt applies overlays
+// after all templates, in order. Inline files, which are never written to the filesystem, come last.
+// When decryptor is set, matching files are decrypted in memory: plaintext is never written to the
+// filesystem, which might be shared with other YttSources.
+func templatesAsInput(mounts []sourceMount, inline map[string]string, decryptor *sopsDecryptor,
+	logger logr.Logger) (yttcmd.Input, error) {
+
+	var files []*yttfiles.File
+	seen := make(map[string]*corev1.ObjectReference)
+
+	ordered := make([]sourceMount, 0, len(mounts))
+	for i := range mounts {
+		if !mounts[i].overlay {
+			ordered = append(ordered, mounts[i])
+		}
+	}
+	for i := range mounts {
+		if mounts[i].overlay {
+			ordered = append(ordered, mounts[i])
+		}
+	}
+	mounts = ordered
+
+	for i := range mounts {
+		dirPath := filepath.Join(mounts[i].dir, mounts[i].path)
+
+		// Get all files in the directory
+		currentFiles, err := getFilesRecursively(dirPath)
+		if err != nil {
+			logger.V(logs.LogInfo).Info(fmt.Sprintf("failed to list files in directory %s: %v", dirPath, err))
+			return yttcmd.Input{}, err
+		}
+
+		for j := range currentFiles {
+			relPath, err := filepath.Rel(dirPath, currentFiles[j])
+			if err != nil {
+				return yttcmd.Input{}, err
+			}
+			name := filepath.ToSlash(filepath.Join(mounts[i].prefix, relPath))
+			if ref, ok := seen[name]; ok {
+				return yttcmd.Input{}, fmt.Errorf("file %s is provided by both %s %s/%s and %s %s/%s", name,
+					ref.Kind, ref.Namespace, ref.Name,
+					mounts[i].ref.Kind, mounts[i].ref.Namespace, mounts[i].ref.Name)
+			}
+			seen[name] = mounts[i].ref
+
+			content, err := readFileContent(currentFiles[j])
+			if err != nil {
+				logger.V(logs.LogInfo).Info(fmt.Sprintf("Failed to read file %s: %v", currentFiles[j], err))
+				return yttcmd.Input{}, err
+			}
+			data := []byte(content)
+			if decryptor != nil && decryptor.matches(name) {
+				data, err = decryptor.decrypt(name, data)
+				if err != nil {
+					logger.V(logs.LogInfo).Info(fmt.Sprintf("Failed to decrypt file %s: %v", name, err))
+					return yttcmd.Input{}, err
+				}
+			}
+			file, err := yttfiles.NewFileFromSource(yttfiles.NewBytesSource(name, data))
+			if err != nil {
+				return yttcmd.Input{}, err
+			}
+			files = append(files, file)
+		}
+	}
+
+	inlineNames := make(map[string]string, len(inline))
+	for _, name := range getSortedFileNames(inline) {
+		// "a.yml" and "./a.yml" are the same file for ytt
+		fileName := path.Clean(name)
+		if ref, ok := seen[fileName]; ok {
+			return yttcmd.Input{}, fmt.Errorf("file %s is provided by both %s %s/%s and spec.files", fileName,
+				ref.Kind, ref.Namespace, ref.Name)
+		}
+		if other, ok := inlineNames[fileName]; ok {
+			return yttcmd.Input{}, fmt.Errorf("spec.files %q and %q are the same file %s", other, name, fileName)
+		}
+		inlineNames[fileName] = name
+
+		file, err := yttfiles.NewFileFromSource(yttfiles.NewBytesSource(fileName, []byte(inline[name])))
+		if err != nil {
+			return yttcmd.Input{}, err
+		}
+		files = append(files, file)
+	}
+
+	// ytt orders overlays and data values by file order
+	return yttcmd.Input{Files: yttfiles.NewSortedFiles(files)}, nil
+}
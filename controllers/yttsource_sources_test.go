@@ -0,0 +1,359 @@
+/*
+Copyright 2024. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	extensionv1beta1 "github.com/gianlucam76/ytt-controller/api/v1beta1"
+
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+)
+
+const (
+	replicasOverlay = `#@ load("@ytt:overlay", "overlay")
+#@overlay/match by=overlay.subset({"kind": "Deployment"})
+---
+spec:
+  replicas: 3
+`
+
+	deploymentTemplate = `#@ load("@helpers:labels.star", "labels")
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  labels: #@ labels("app")
+spec:
+  replicas: 1
+`
+
+	labelsLibrary = `def labels(name):
+  return {"app.kubernetes.io/name": name}
+end
+`
+)
+
+var _ = Describe("YttSource Controller: multiple sources", func() {
+	It("Reconcile merges all sources in one ytt invocation", func() {
+		configMap := getYttConfigMap()
+		overlays := getConfigMapWithFiles(configMap.Namespace, map[string]string{"replicas.yaml": replicasOverlay})
+		yttSource := getYttSourceForConfigMap(configMap, "./")
+		yttSource.Spec.Sources = []extensionv1beta1.SourceReference{
+			{
+				Kind:        string(libsveltosv1beta1.ConfigMapReferencedResourceKind),
+				Namespace:   overlays.Namespace,
+				Name:        overlays.Name,
+				MountPrefix: "overlays",
+			},
+		}
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource).
+			WithObjects(configMap, overlays, yttSource).Build()
+
+		reconciler := getYttSourceReconciler(c)
+		_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+		})
+		Expect(err).To(BeNil())
+
+		currentYttSource := &extensionv1beta1.YttSource{}
+		Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+			currentYttSource)).To(Succeed())
+
+		Expect(currentYttSource.Status.FailureMessage).To(BeNil())
+		Expect(currentYttSource.Status.Resources).To(ContainSubstring("replicas: 3"))
+		Expect(currentYttSource.Status.LastAppliedRevision).To(ContainSubstring(configMap.Name))
+		Expect(currentYttSource.Status.LastAppliedRevision).To(ContainSubstring(overlays.Name))
+		Expect(apimeta.IsStatusConditionTrue(currentYttSource.Status.Conditions,
+			extensionv1beta1.ReadyCondition)).To(BeTrue())
+	})
+
+	It("Reconcile fails when the same file is provided by two sources", func() {
+		configMap := getYttConfigMap()
+		duplicate := getYttConfigMap()
+		yttSource := getYttSourceForConfigMap(configMap, "./")
+		yttSource.Spec.Sources = []extensionv1beta1.SourceReference{
+			{
+				Kind:      string(libsveltosv1beta1.ConfigMapReferencedResourceKind),
+				Namespace: duplicate.Namespace,
+				Name:      duplicate.Name,
+			},
+		}
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource).
+			WithObjects(configMap, duplicate, yttSource).Build()
+
+		reconciler := getYttSourceReconciler(c)
+		_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+		})
+		Expect(err).ToNot(BeNil())
+
+		currentYttSource := &extensionv1beta1.YttSource{}
+		Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+			currentYttSource)).To(Succeed())
+
+		Expect(currentYttSource.Status.FailureMessage).ToNot(BeNil())
+		Expect(*currentYttSource.Status.FailureMessage).To(ContainSubstring("is provided by both"))
+		Expect(apimeta.IsStatusConditionFalse(currentYttSource.Status.Conditions,
+			extensionv1beta1.ReadyCondition)).To(BeTrue())
+	})
+
+	It("Reconcile uses the YttSource namespace for sources with no namespace", func() {
+		configMap := getYttConfigMap()
+		yttSource := getYttSourceForConfigMap(configMap, "./")
+		configMap.Namespace = yttSource.Namespace
+		yttSource.Spec = extensionv1beta1.YttSourceSpec{
+			Sources: []extensionv1beta1.SourceReference{
+				{Kind: string(libsveltosv1beta1.ConfigMapReferencedResourceKind), Name: configMap.Name},
+			},
+		}
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource).
+			WithObjects(configMap, yttSource).Build()
+
+		reconciler := getYttSourceReconciler(c)
+		_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+		})
+		Expect(err).To(BeNil())
+
+		currentYttSource := &extensionv1beta1.YttSource{}
+		Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+			currentYttSource)).To(Succeed())
+
+		Expect(currentYttSource.Status.FailureMessage).To(BeNil())
+		Expect(currentYttSource.Status.Resources).ToNot(BeEmpty())
+	})
+})
+
+var _ = Describe("YttSource Controller: overlay and library sources", func() {
+	It("Reconcile places libraries in _ytt_lib and applies overlays last, in order", func() {
+		namespace := randomString()
+		overlays := getConfigMapWithFiles(namespace, map[string]string{"replicas.yaml": replicasOverlay})
+		templates := getConfigMapWithFiles(namespace, map[string]string{"deployment.yaml": deploymentTemplate})
+		library := getConfigMapWithFiles(namespace, map[string]string{"labels.star": labelsLibrary})
+		moreOverlays := getConfigMapWithFiles(namespace, map[string]string{
+			"replicas.yaml": strings.ReplaceAll(replicasOverlay, "replicas: 3", "replicas: 5"),
+		})
+
+		yttSource := &extensionv1beta1.YttSource{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       randomString(),
+				Namespace:  namespace,
+				Generation: 1,
+			},
+			Spec: extensionv1beta1.YttSourceSpec{
+				Sources: []extensionv1beta1.SourceReference{
+					// overlays are listed first but still applied after templates
+					{
+						Kind: string(libsveltosv1beta1.ConfigMapReferencedResourceKind), Name: overlays.Name,
+						Type: extensionv1beta1.SourceTypeOverlay, MountPrefix: "overlays/1",
+					},
+					{
+						Kind: string(libsveltosv1beta1.ConfigMapReferencedResourceKind), Name: templates.Name,
+						Type: extensionv1beta1.SourceTypeTemplate,
+					},
+					{
+						Kind: string(libsveltosv1beta1.ConfigMapReferencedResourceKind), Name: library.Name,
+						Type: extensionv1beta1.SourceTypeLibrary, LibraryName: "helpers",
+					},
+					{
+						Kind: string(libsveltosv1beta1.ConfigMapReferencedResourceKind), Name: moreOverlays.Name,
+						Type: extensionv1beta1.SourceTypeOverlay, MountPrefix: "overlays/2",
+					},
+				},
+			},
+		}
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource).
+			WithObjects(overlays, templates, library, moreOverlays, yttSource).Build()
+
+		reconciler := getYttSourceReconciler(c)
+		_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+		})
+		Expect(err).To(BeNil())
+
+		currentYttSource := &extensionv1beta1.YttSource{}
+		Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+			currentYttSource)).To(Succeed())
+
+		Expect(currentYttSource.Status.FailureMessage).To(BeNil())
+		Expect(currentYttSource.Status.Resources).To(ContainSubstring("app.kubernetes.io/name: app"))
+		Expect(currentYttSource.Status.Resources).To(ContainSubstring("replicas: 5"))
+	})
+
+	It("Reconcile fails when a template loads a library which is not referenced", func() {
+		templates := getConfigMapWithFiles(randomString(), map[string]string{"deployment.yaml": deploymentTemplate})
+		yttSource := getYttSourceForConfigMap(templates, "./")
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource).
+			WithObjects(templates, yttSource).Build()
+
+		reconciler := getYttSourceReconciler(c)
+		_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+		})
+		// Evaluation errors are not retried
+		Expect(err).To(BeNil())
+
+		currentYttSource := &extensionv1beta1.YttSource{}
+		Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+			currentYttSource)).To(Succeed())
+
+		ready := apimeta.FindStatusCondition(currentYttSource.Status.Conditions, extensionv1beta1.ReadyCondition)
+		Expect(ready).ToNot(BeNil())
+		Expect(ready.Reason).To(Equal(extensionv1beta1.YttEvaluationFailedReason))
+	})
+})
+
+var _ = Describe("YttSource Controller: inline files", func() {
+	It("Reconcile renders a YttSource containing only inline files", func() {
+		yttSource := &extensionv1beta1.YttSource{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       randomString(),
+				Namespace:  randomString(),
+				Generation: 1,
+			},
+			Spec: extensionv1beta1.YttSourceSpec{
+				Files: map[string]string{
+					"deployment.yaml":              deploymentTemplate,
+					"_ytt_lib/helpers/labels.star": labelsLibrary,
+				},
+			},
+		}
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource).
+			WithObjects(yttSource).Build()
+
+		reconciler := getYttSourceReconciler(c)
+		_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+		})
+		Expect(err).To(BeNil())
+
+		currentYttSource := &extensionv1beta1.YttSource{}
+		Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+			currentYttSource)).To(Succeed())
+
+		Expect(currentYttSource.Status.FailureMessage).To(BeNil())
+		Expect(currentYttSource.Status.Resources).To(ContainSubstring("app.kubernetes.io/name: app"))
+		Expect(currentYttSource.Status.LastAppliedRevision).To(HavePrefix("sha256:"))
+	})
+
+	It("Reconcile combines inline files with sources", func() {
+		configMap := getYttConfigMap()
+		yttSource := getYttSourceForConfigMap(configMap, "./")
+		yttSource.Spec.Files = map[string]string{"overlays/replicas.yaml": replicasOverlay}
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource).
+			WithObjects(configMap, yttSource).Build()
+
+		reconciler := getYttSourceReconciler(c)
+		_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+		})
+		Expect(err).To(BeNil())
+
+		currentYttSource := &extensionv1beta1.YttSource{}
+		Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+			currentYttSource)).To(Succeed())
+
+		Expect(currentYttSource.Status.FailureMessage).To(BeNil())
+		Expect(currentYttSource.Status.Resources).To(ContainSubstring("replicas: 3"))
+		Expect(currentYttSource.Status.LastAppliedRevision).To(ContainSubstring("Inline@sha256:"))
+	})
+
+	It("Reconcile fails when an inline file is also provided by a source", func() {
+		configMap := getYttConfigMap()
+		yttSource := getYttSourceForConfigMap(configMap, "./")
+		yttSource.Spec.Files = map[string]string{"./app.yaml": deploymentTemplate}
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource).
+			WithObjects(configMap, yttSource).Build()
+
+		reconciler := getYttSourceReconciler(c)
+		_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+		})
+		Expect(err).ToNot(BeNil())
+
+		currentYttSource := &extensionv1beta1.YttSource{}
+		Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+			currentYttSource)).To(Succeed())
+
+		Expect(currentYttSource.Status.FailureMessage).ToNot(BeNil())
+		Expect(*currentYttSource.Status.FailureMessage).To(ContainSubstring("app.yaml is provided by both"))
+	})
+	It("Reconcile fails when two inline files are the same file", func() {
+		yttSource := getYttSourceWithFiles(map[string]string{
+			"deployment.yaml":   deploymentTemplate,
+			"./deployment.yaml": deploymentTemplate,
+		})
+
+		currentYttSource := reconcileYttSource(yttSource)
+		Expect(currentYttSource.Status.FailureMessage).ToNot(BeNil())
+		Expect(*currentYttSource.Status.FailureMessage).To(ContainSubstring("are the same file deployment.yaml"))
+	})
+})
+
+// getConfigMapWithFiles returns a ConfigMap containing files, as ytt.tar.gz
+func getConfigMapWithFiles(namespace string, files map[string]string) *corev1.ConfigMap {
+	var buf bytes.Buffer
+	gzWriter := gzip.NewWriter(&buf)
+	tarWriter := tar.NewWriter(gzWriter)
+	Expect(tarWriter.WriteHeader(&tar.Header{Name: "./", Mode: 0755, Typeflag: tar.TypeDir})).To(Succeed())
+	for name, content := range files {
+		Expect(tarWriter.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0600,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		})).To(Succeed())
+		_, err := tarWriter.Write([]byte(content))
+		Expect(err).To(BeNil())
+	}
+	Expect(tarWriter.Close()).To(Succeed())
+	Expect(gzWriter.Close()).To(Succeed())
+
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      randomString(),
+			Namespace: namespace,
+		},
+		BinaryData: map[string][]byte{
+			"ytt.tar.gz": buf.Bytes(),
+		},
+	}
+}
@@ -20,154 +20,39 @@ import (
 	"context"
 	"fmt"
 
-	corev1 "k8s.io/api/core/v1"
 	"k8s.io/klog/v2/textlogger"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
-	sourcev1 "github.com/fluxcd/source-controller/api/v1"
-	sourcev1b2 "github.com/fluxcd/source-controller/api/v1beta2"
-
-	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
 	logs "github.com/projectsveltos/libsveltos/lib/logsettings"
 )
 
-func (r *YttSourceReconciler) requeueYttSourceForFluxGitRepository(
-	ctx context.Context, o *sourcev1.GitRepository,
-) []reconcile.Request {
-
-	return r.requeueYttSourceForFluxSource(ctx, o)
-}
-
-func (r *YttSourceReconciler) requeueYttSourceForFluxOCIRepository(
-	ctx context.Context, o *sourcev1b2.OCIRepository,
-) []reconcile.Request {
-
-	return r.requeueYttSourceForFluxSource(ctx, o)
-}
-
-func (r *YttSourceReconciler) requeueYttSourceForFluxBucket(
-	ctx context.Context, o *sourcev1b2.Bucket,
-) []reconcile.Request {
-
-	return r.requeueYttSourceForFluxSource(ctx, o)
-}
-
-func (r *YttSourceReconciler) requeueYttSourceForFluxSource(
-	_ context.Context, o client.Object,
-) []reconcile.Request {
-
-	logger := textlogger.NewLogger(textlogger.NewConfig()).WithValues(
-		"objectMapper",
-		"requeueYttSourceForFluxSources",
-		"reference",
-		o.GetName(),
-	)
-
-	logger.V(logs.LogDebug).Info("reacting to flux source change")
-
-	r.PolicyMux.Lock()
-	defer r.PolicyMux.Unlock()
-
-	// Following is needed as o.GetObjectKind().GroupVersionKind().Kind is not set
-	var key corev1.ObjectReference
-	switch o.(type) {
-	case *sourcev1.GitRepository:
-		key = corev1.ObjectReference{
-			APIVersion: sourcev1.GroupVersion.String(),
-			Kind:       sourcev1.GitRepositoryKind,
-			Namespace:  o.GetNamespace(),
-			Name:       o.GetName(),
-		}
-	case *sourcev1b2.OCIRepository:
-		key = corev1.ObjectReference{
-			APIVersion: sourcev1b2.GroupVersion.String(),
-			Kind:       sourcev1b2.OCIRepositoryKind,
-			Namespace:  o.GetNamespace(),
-			Name:       o.GetName(),
-		}
-	case *sourcev1b2.Bucket:
-		key = corev1.ObjectReference{
-			APIVersion: sourcev1b2.GroupVersion.String(),
-			Kind:       sourcev1b2.BucketKind,
-			Namespace:  o.GetNamespace(),
-			Name:       o.GetName(),
-		}
-	default:
-		key = corev1.ObjectReference{
-			APIVersion: o.GetObjectKind().GroupVersionKind().GroupVersion().String(),
-			Kind:       o.GetObjectKind().GroupVersionKind().Kind,
-			Namespace:  o.GetNamespace(),
-			Name:       o.GetName(),
-		}
-	}
-
-	logger.V(logs.LogDebug).Info(fmt.Sprintf("referenced key: %s", key))
-
-	requests := make([]ctrl.Request, r.getReferenceMapForEntry(&key).Len())
-
-	consumers := r.getReferenceMapForEntry(&key).Items()
-	for i := range consumers {
-		logger.V(logs.LogDebug).Info(fmt.Sprintf("requeue consumer: %s", consumers[i]))
-		requests[i] = ctrl.Request{
-			NamespacedName: client.ObjectKey{
-				Name:      consumers[i].Name,
-				Namespace: consumers[i].Namespace,
-			},
-		}
-	}
-
-	return requests
-}
-
+// requeueYttSourceForReference returns the YttSources referencing o, either as a source
+// of ytt files or for data values and decryption keys.
 func (r *YttSourceReconciler) requeueYttSourceForReference(
 	ctx context.Context, o client.Object,
 ) []reconcile.Request {
 
 	logger := textlogger.NewLogger(textlogger.NewConfig()).WithValues(
 		"objectMapper",
-		"requeueYttSourceForConfigMap",
+		"requeueYttSourceForReference",
 		"reference",
 		o.GetName(),
 	)
 
-	logger.V(logs.LogDebug).Info("reacting to configMap/secret change")
+	logger.V(logs.LogDebug).Info("reacting to referenced resource change")
 
 	r.PolicyMux.Lock()
 	defer r.PolicyMux.Unlock()
 
-	// Following is needed as o.GetObjectKind().GroupVersionKind().Kind is not set
-	var key corev1.ObjectReference
-	switch o.(type) {
-	case *corev1.ConfigMap:
-		key = corev1.ObjectReference{
-			APIVersion: corev1.SchemeGroupVersion.String(),
-			Kind:       string(libsveltosv1beta1.ConfigMapReferencedResourceKind),
-			Namespace:  o.GetNamespace(),
-			Name:       o.GetName(),
-		}
-	case *corev1.Secret:
-		key = corev1.ObjectReference{
-			APIVersion: corev1.SchemeGroupVersion.String(),
-			Kind:       string(libsveltosv1beta1.SecretReferencedResourceKind),
-			Namespace:  o.GetNamespace(),
-			Name:       o.GetName(),
-		}
-	default:
-		key = corev1.ObjectReference{
-			APIVersion: o.GetObjectKind().GroupVersionKind().GroupVersion().String(),
-			Kind:       o.GetObjectKind().GroupVersionKind().Kind,
-			Namespace:  o.GetNamespace(),
-			Name:       o.GetName(),
-		}
-	}
+	key := getReferenceKey(o)
 
 	logger.V(logs.LogDebug).Info(fmt.Sprintf("referenced key: %s", key))
 
-	requests := make([]ctrl.Request, r.getReferenceMapForEntry(&key).Len())
+	requests := make([]ctrl.Request, r.getReferenceMapForEntry(key).Len())
 
-	consumers := r.getReferenceMapForEntry(&key).Items()
+	consumers := r.getReferenceMapForEntry(key).Items()
 	for i := range consumers {
 		logger.V(logs.LogDebug).Info(fmt.Sprintf("requeue consumer: %s", consumers[i]))
 		requests[i] = ctrl.Request{
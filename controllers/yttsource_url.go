@@ -0,0 +1,478 @@
+/*
+Copyright 2024. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/fluxcd/pkg/tar"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	extensionv1beta1 "github.com/gianlucam76/ytt-controller/api/v1beta1"
+
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+	logs "github.com/projectsveltos/libsveltos/lib/logsettings"
+)
+
+const (
+	// urlRequestTimeout bounds the time spent on a single request, download included
+	urlRequestTimeout = 5 * time.Minute
+)
+
+var (
+	// errChecksumMismatch is returned when a tarball does not match URLSource.Checksum
+	errChecksumMismatch = errors.New("checksum mismatch")
+
+	// errDownloadTooLarge is returned when a tarball is larger than MaxDownloadSize
+	errDownloadTooLarge = errors.New("download too large")
+
+	// errURLSourceChanged is returned when the revision of a URL source cannot be known
+	// without downloading it again
+	errURLSourceChanged = errors.New("URL source content might have changed")
+)
+
+// urlSourceState is what is known about the tarball last downloaded for a URL source.
+// It allows following requests to be conditional.
+type urlSourceState struct {
+	// fetcherKey identifies the address and headers the tarball was downloaded with
+	fetcherKey   string
+	etag         string
+	lastModified string
+	// digest is the sha256 digest of the tarball
+	digest string
+}
+
+// getURLSourceState returns the state of the source at index of yttSource, or nil if
+// unknown or if it was downloaded with a different address or headers
+func (r *YttSourceReconciler) getURLSourceState(yttSource types.NamespacedName, index int,
+	fetcherKey string) *urlSourceState {
+
+	r.urlSourceStatesMux.Lock()
+	defer r.urlSourceStatesMux.Unlock()
+	state := r.urlSourceStates[yttSource][index]
+	if state == nil || state.fetcherKey != fetcherKey {
+		return nil
+	}
+	return state
+}
+
+func (r *YttSourceReconciler) setURLSourceState(yttSource types.NamespacedName, index int,
+	state *urlSourceState) {
+
+	r.urlSourceStatesMux.Lock()
+	defer r.urlSourceStatesMux.Unlock()
+	if r.urlSourceStates == nil {
+		r.urlSourceStates = make(map[types.NamespacedName]map[int]*urlSourceState)
+	}
+	if r.urlSourceStates[yttSource] == nil {
+		r.urlSourceStates[yttSource] = make(map[int]*urlSourceState)
+	}
+	r.urlSourceStates[yttSource][index] = state
+}
+
+// pruneURLSourceStates forgets the state of the sources of yttSource which are not
+// URL sources anymore
+func (r *YttSourceReconciler) pruneURLSourceStates(yttSource types.NamespacedName,
+	sources []extensionv1beta1.SourceReference) {
+
+	r.urlSourceStatesMux.Lock()
+	defer r.urlSourceStatesMux.Unlock()
+	for index := range r.urlSourceStates[yttSource] {
+		if index >= len(sources) || sources[index].Kind != extensionv1beta1.URLSourceKind {
+			delete(r.urlSourceStates[yttSource], index)
+		}
+	}
+	if len(r.urlSourceStates[yttSource]) == 0 {
+		delete(r.urlSourceStates, yttSource)
+	}
+}
+
+// clearURLSourceStates forgets the state of all URL sources of yttSource
+func (r *YttSourceReconciler) clearURLSourceStates(yttSource types.NamespacedName) {
+	r.urlSourceStatesMux.Lock()
+	defer r.urlSourceStatesMux.Unlock()
+	delete(r.urlSourceStates, yttSource)
+}
+
+// urlFetcher downloads tarballs from the address of a URLSource
+type urlFetcher struct {
+	address string
+	headers http.Header
+	client  *http.Client
+	// maxSize is the maximum size of the tarball, both downloaded and extracted.
+	// Zero means no limit.
+	maxSize int64
+}
+
+// newURLFetcher returns a urlFetcher for source. Headers and CA bundle are read from
+// the Secrets referenced by source, which must be in namespace and of one of allowedSecretTypes.
+func newURLFetcher(ctx context.Context, c client.Client, namespace string,
+	source *extensionv1beta1.URLSource, allowedSecretTypes []corev1.SecretType, maxSize int64,
+) (*urlFetcher, error) {
+
+	fetcher := &urlFetcher{
+		address: source.Address,
+		headers: make(http.Header),
+		client:  &http.Client{Timeout: urlRequestTimeout},
+		maxSize: maxSize,
+	}
+
+	if ref := source.HeadersSecretRef; ref != nil {
+		secret, err := getSourceSecret(ctx, c, namespace, ref, allowedSecretTypes)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range secret.Data {
+			fetcher.headers.Set(key, string(value))
+		}
+	}
+
+	if ref := source.CASecretRef; ref != nil {
+		secret, err := getSourceSecret(ctx, c, namespace, ref, allowedSecretTypes)
+		if err != nil {
+			return nil, err
+		}
+		caBundle, ok := secret.Data[extensionv1beta1.URLSourceCAKey]
+		if !ok {
+			return nil, fmt.Errorf("secret %s/%s does not contain key %s", secret.Namespace, secret.Name,
+				extensionv1beta1.URLSourceCAKey)
+		}
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil {
+			rootCAs = x509.NewCertPool()
+		}
+		if !rootCAs.AppendCertsFromPEM(caBundle) {
+			return nil, fmt.Errorf("secret %s/%s: no valid certificate found in key %s", secret.Namespace,
+				secret.Name, extensionv1beta1.URLSourceCAKey)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: rootCAs, MinVersion: tls.VersionTLS12}
+		fetcher.client.Transport = transport
+	}
+
+	return fetcher, nil
+}
+
+// getURLSourceSecretReferences returns the Secrets source reads headers and CA bundle from
+func getURLSourceSecretReferences(namespace string, source *extensionv1beta1.URLSource) []corev1.ObjectReference {
+	if source == nil {
+		return nil
+	}
+
+	kind := string(libsveltosv1beta1.SecretReferencedResourceKind)
+	var refs []corev1.ObjectReference
+	for _, ref := range []*corev1.SecretReference{source.HeadersSecretRef, source.CASecretRef} {
+		if ref == nil {
+			continue
+		}
+		refs = append(refs, corev1.ObjectReference{
+			APIVersion: getReferenceAPIVersion(kind),
+			Kind:       kind,
+			Namespace:  namespace,
+			Name:       ref.Name,
+		})
+	}
+	return refs
+}
+
+// stateKey identifies the address and headers of this fetcher. Different credentials
+// might give access to different content.
+func (f *urlFetcher) stateKey() string {
+	names := make([]string, 0, len(f.headers))
+	for name := range f.headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s=%s\n", name, f.headers.Get(name))
+	}
+	return fmt.Sprintf("%s#%x", f.address, h.Sum(nil))
+}
+
+// cacheKey returns the key, in the artifact cache, of the tarball with given digest.
+// Tarballs downloaded from different addresses or with different headers never share
+// an entry: a cached tarball is only served to requests which could download it.
+func (f *urlFetcher) cacheKey(digest string) string {
+	return getArtifactCacheKey(extensionv1beta1.URLSourceKind,
+		fmt.Sprintf("%x", sha256.Sum256([]byte(f.stateKey()))), digest)
+}
+
+// request sends a GET request to the address. When state is set, the request is
+// conditional and a nil response is returned if the server reports the tarball has
+// not changed. On success, caller must close the response body.
+func (f *urlFetcher) request(ctx context.Context, state *urlSourceState) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.address, http.NoBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a new request: %w", err)
+	}
+	for name := range f.headers {
+		req.Header.Set(name, f.headers.Get(name))
+	}
+	if state != nil {
+		if state.etag != "" {
+			req.Header.Set("If-None-Match", state.etag)
+		}
+		if state.lastModified != "" {
+			req.Header.Set("If-Modified-Since", state.lastModified)
+		}
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download tarball: %w", err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp, nil
+	case http.StatusNotModified:
+		resp.Body.Close()
+		if state == nil {
+			return nil, fmt.Errorf("unexpected status %s from %s", resp.Status, f.address)
+		}
+		return nil, nil
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to download tarball from %s (status: %s)", f.address, resp.Status)
+	}
+}
+
+// download fetches the tarball into a temporary file. When state is set, the request is
+// conditional and, if the tarball has not changed, an empty path and state are returned.
+// Otherwise the path of the tarball and its new state are returned and caller must remove
+// the file once done.
+func (f *urlFetcher) download(ctx context.Context, state *urlSourceState) (string, *urlSourceState, error) {
+	resp, err := f.request(ctx, state)
+	if err != nil || resp == nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+
+	file, err := os.CreateTemp("", "url-source-*.tar.gz")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+
+	h := sha256.New()
+	err = f.copyTarball(io.MultiWriter(file, h), resp.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", nil, fmt.Errorf("failed to download tarball from %s: %w", f.address, err)
+	}
+
+	return file.Name(), &urlSourceState{
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		digest:       fmt.Sprintf("sha256:%x", h.Sum(nil)),
+	}, nil
+}
+
+// copyTarball copies body to w, failing if body is larger than maxSize.
+// Headers can lie, so the response content length is not trusted.
+func (f *urlFetcher) copyTarball(w io.Writer, body io.Reader) error {
+	if f.maxSize <= 0 {
+		_, err := io.Copy(w, body)
+		return err
+	}
+
+	if _, err := io.Copy(w, io.LimitReader(body, f.maxSize)); err != nil {
+		return err
+	}
+	if n, _ := io.Copy(io.Discard, body); n > 0 {
+		return fmt.Errorf("%w: tarball is %d bytes greater than the maximum size of %d bytes",
+			errDownloadTooLarge, n, f.maxSize)
+	}
+	return nil
+}
+
+// fetch downloads the tarball, verifies it matches digest, when set, and extracts it to dir
+func (f *urlFetcher) fetch(ctx context.Context, digest, dir string) (*urlSourceState, error) {
+	tarball, state, err := f.download(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tarball)
+
+	if digest != "" && state.digest != digest {
+		return nil, fmt.Errorf("%w: tarball from %s has digest %s, expected %s", errChecksumMismatch,
+			f.address, state.digest, digest)
+	}
+
+	return state, f.extract(tarball, dir)
+}
+
+// extract extracts the gzip compressed tarball to dir, failing if its content
+// is larger than maxSize
+func (f *urlFetcher) extract(tarball, dir string) error {
+	file, err := os.Open(tarball)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return tar.Untar(file, dir, tar.WithMaxUntarSize(getMaxUntarSize(f.maxSize)))
+}
+
+// getURLSourceRevision returns the digest of the tarball currently served for source, the
+// source at index of yttSource, without downloading it. That is the checksum, when set.
+// Otherwise a conditional request is sent and errURLSourceChanged is returned if the tarball
+// might have changed since it was last downloaded.
+func (r *YttSourceReconciler) getURLSourceRevision(ctx context.Context, yttSource *extensionv1beta1.YttSource,
+	index int, source *extensionv1beta1.URLSource, allowedSecretTypes []corev1.SecretType) (string, error) {
+
+	if source == nil {
+		return "", fmt.Errorf("url must be set for kind %s", extensionv1beta1.URLSourceKind)
+	}
+	if source.Checksum != "" {
+		return source.Checksum, nil
+	}
+
+	fetcher, err := newURLFetcher(ctx, r.Client, yttSource.Namespace, source, allowedSecretTypes,
+		r.MaxDownloadSize)
+	if err != nil {
+		return "", err
+	}
+
+	yttSourceKey := types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name}
+	state := r.getURLSourceState(yttSourceKey, index, fetcher.stateKey())
+	if state == nil {
+		return "", errURLSourceChanged
+	}
+
+	resp, err := fetcher.request(ctx, state)
+	if err != nil {
+		return "", err
+	}
+	if resp != nil {
+		// Content is not read here: it will be downloaded while preparing sources
+		resp.Body.Close()
+		return "", errURLSourceChanged
+	}
+	return state.digest, nil
+}
+
+// prepareFileSystemWithURL downloads the tarball described by source, the source at index
+// of yttSource, and extracts it. Tarballs are stored in the artifact cache keyed by digest,
+// address and headers, so a tarball downloaded by a YttSource is never served to a YttSource
+// sending different credentials. When the checksum is set and the tarball is cached for the
+// same address and headers, nothing is downloaded. Otherwise, with
+// the artifact cache, requests are conditional so tarballs which have not changed are not
+// downloaded again. Without the artifact cache, the state of the tarball is still recorded
+// so that getURLSourceRevision can tell whether it changed.
+func (r *YttSourceReconciler) prepareFileSystemWithURL(ctx context.Context,
+	yttSource *extensionv1beta1.YttSource, index int, source *extensionv1beta1.URLSource,
+	allowedSecretTypes []corev1.SecretType, logger logr.Logger,
+) (dir, revision string, cleanup func(), err error) {
+
+	if source == nil {
+		return "", "", nil, fmt.Errorf("url must be set for kind %s", extensionv1beta1.URLSourceKind)
+	}
+
+	fetcher, err := newURLFetcher(ctx, r.Client, yttSource.Namespace, source, allowedSecretTypes,
+		r.MaxDownloadSize)
+	if err != nil {
+		return "", "", nil, err
+	}
+	yttSourceKey := types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name}
+	fetcherKey := fetcher.stateKey()
+	artifactCache := r.ArtifactCache
+
+	// Downloads are network heavy. Wait for a free slot.
+	release, err := r.downloadPool.acquire(ctx)
+	if err != nil {
+		return "", "", nil, err
+	}
+	defer release()
+
+	fetchTarball := func(digest string) func(dir string) error {
+		return func(dir string) error {
+			_, err := fetcher.fetch(ctx, digest, dir)
+			return err
+		}
+	}
+
+	if artifactCache != nil && source.Checksum != "" {
+		dir, cleanup, err = artifactCache.Acquire(fetcher.cacheKey(source.Checksum), fetchTarball(source.Checksum))
+		if err != nil {
+			return "", "", nil, err
+		}
+		return dir, source.Checksum, cleanup, nil
+	}
+
+	if artifactCache == nil {
+		tmpDir, err := os.MkdirTemp("", "url-source-")
+		if err != nil {
+			return "", "", nil, fmt.Errorf("tmp dir error: %w", err)
+		}
+		cleanup = func() { os.RemoveAll(tmpDir) }
+
+		state, err := fetcher.fetch(ctx, source.Checksum, tmpDir)
+		if err != nil {
+			cleanup()
+			return "", "", nil, err
+		}
+		state.fetcherKey = fetcherKey
+		r.setURLSourceState(yttSourceKey, index, state)
+		return tmpDir, state.digest, cleanup, nil
+	}
+
+	previousState := r.getURLSourceState(yttSourceKey, index, fetcherKey)
+	tarball, state, err := fetcher.download(ctx, previousState)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	if tarball == "" {
+		logger.V(logs.LogDebug).Info(fmt.Sprintf("%s not modified", source.Address))
+		// Tarball has not changed. Download it again only if it was evicted from the cache.
+		dir, cleanup, err = artifactCache.Acquire(fetcher.cacheKey(previousState.digest),
+			fetchTarball(previousState.digest))
+		if err != nil {
+			return "", "", nil, err
+		}
+		return dir, previousState.digest, cleanup, nil
+	}
+	defer os.Remove(tarball)
+
+	dir, cleanup, err = artifactCache.Acquire(fetcher.cacheKey(state.digest), func(dir string) error {
+		return fetcher.extract(tarball, dir)
+	})
+	if err != nil {
+		return "", "", nil, err
+	}
+	state.fetcherKey = fetcherKey
+	r.setURLSourceState(yttSourceKey, index, state)
+	return dir, state.digest, cleanup, nil
+}
@@ -0,0 +1,370 @@
+/*
+Copyright 2024. projectsveltos.io. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	extensionv1beta1 "github.com/gianlucam76/ytt-controller/api/v1beta1"
+	"github.com/gianlucam76/ytt-controller/controllers"
+
+	libsveltosv1beta1 "github.com/projectsveltos/libsveltos/api/v1beta1"
+)
+
+const (
+	urlTemplate = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: from-url
+data:
+  source: url
+`
+)
+
+var _ = Describe("YttSource Controller: URL source", func() {
+	var tarball []byte
+	var digest string
+	var downloads atomic.Int32
+	var server *httptest.Server
+
+	BeforeEach(func() {
+		tarball = getTarGz(map[string]string{"deploy/app.yaml": urlTemplate})
+		digest = fmt.Sprintf("sha256:%x", sha256.Sum256(tarball))
+		downloads.Store(0)
+	})
+
+	AfterEach(func() {
+		if server != nil {
+			server.Close()
+		}
+	})
+
+	// serveTarball serves tarball with an ETag, requiring the Authorization header when set
+	serveTarball := func(authorization string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if authorization != "" && r.Header.Get("Authorization") != authorization {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			etag := `"` + digest + `"`
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			downloads.Add(1)
+			w.Header().Set("ETag", etag)
+			_, _ = w.Write(tarball)
+		}
+	}
+
+	It("Reconcile renders a tarball fetched over HTTP with headers from a Secret", func() {
+		server = httptest.NewServer(serveTarball("Bearer token"))
+
+		headers := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: randomString(), Name: randomString()},
+			Type:       libsveltosv1beta1.ClusterProfileSecretType,
+			Data:       map[string][]byte{"Authorization": []byte("Bearer token")},
+		}
+		yttSource := getYttSourceForURL(headers.Namespace, &extensionv1beta1.URLSource{
+			Address:          server.URL + "/bundle.tar.gz",
+			HeadersSecretRef: &corev1.SecretReference{Name: headers.Name},
+			Interval:         &metav1.Duration{Duration: time.Minute},
+		})
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource).
+			WithObjects(yttSource, headers).Build()
+		result, currentYttSource := reconcileYttSourceWithClient(c, getYttSourceReconciler(c), yttSource)
+		Expect(currentYttSource.Status.FailureMessage).To(BeNil())
+		Expect(currentYttSource.Status.Resources).To(ContainSubstring("name: from-url"))
+		Expect(currentYttSource.Status.LastAppliedRevision).To(Equal(digest))
+		// Changes cannot be watched: YttSource is polled
+		Expect(result.RequeueAfter).To(Equal(time.Minute))
+	})
+
+	It("Reconcile fails when the tarball does not match the checksum", func() {
+		server = httptest.NewServer(serveTarball(""))
+
+		yttSource := getYttSourceForURL(randomString(), &extensionv1beta1.URLSource{
+			Address:  server.URL,
+			Checksum: fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(randomString()))),
+		})
+
+		currentYttSource := reconcileYttSource(yttSource)
+		Expect(currentYttSource.Status.FailureMessage).ToNot(BeNil())
+		Expect(*currentYttSource.Status.FailureMessage).To(ContainSubstring("checksum mismatch"))
+	})
+
+	It("Reconcile does not poll tarballs pinned by checksum", func() {
+		server = httptest.NewServer(serveTarball(""))
+
+		yttSource := getYttSourceForURL(randomString(), &extensionv1beta1.URLSource{
+			Address:  server.URL,
+			Checksum: digest,
+		})
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource).
+			WithObjects(yttSource).Build()
+		result, currentYttSource := reconcileYttSourceWithClient(c, getYttSourceReconciler(c), yttSource)
+		Expect(currentYttSource.Status.FailureMessage).To(BeNil())
+		Expect(currentYttSource.Status.LastAppliedRevision).To(Equal(digest))
+		Expect(result.RequeueAfter).To(BeZero())
+	})
+
+	It("Reconcile does not download again a tarball which has not changed", func() {
+		server = httptest.NewServer(serveTarball(""))
+
+		cacheDir, err := os.MkdirTemp("", "url-cache-")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(cacheDir)
+		cache, err := controllers.NewArtifactCache(cacheDir, 1024*1024)
+		Expect(err).To(BeNil())
+
+		yttSource := getYttSourceForURL(randomString(), &extensionv1beta1.URLSource{
+			Address: server.URL + "/" + randomString(),
+		})
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource).
+			WithObjects(yttSource).Build()
+		reconciler := getYttSourceReconciler(c)
+		reconciler.ArtifactCache = cache
+
+		result, currentYttSource := reconcileYttSourceWithClient(c, reconciler, yttSource)
+		Expect(currentYttSource.Status.FailureMessage).To(BeNil())
+		Expect(result.RequeueAfter).To(Equal(5 * time.Minute))
+
+		// Force rendering again: tarball is served from the cache
+		currentYttSource.Spec.Path = "./deploy"
+		currentYttSource.Generation++
+		Expect(c.Update(context.TODO(), currentYttSource)).To(Succeed())
+		_, currentYttSource = reconcileYttSourceWithClient(c, reconciler, currentYttSource)
+		Expect(currentYttSource.Status.FailureMessage).To(BeNil())
+		Expect(currentYttSource.Status.Resources).To(ContainSubstring("name: from-url"))
+
+		Expect(downloads.Load()).To(Equal(int32(1)))
+	})
+
+	It("Reconcile does not serve a cached tarball to a YttSource with different headers", func() {
+		server = httptest.NewServer(serveTarball("Bearer token"))
+
+		cacheDir, err := os.MkdirTemp("", "url-cache-")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(cacheDir)
+		cache, err := controllers.NewArtifactCache(cacheDir, 1024*1024)
+		Expect(err).To(BeNil())
+
+		headers := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: randomString(), Name: randomString()},
+			Type:       libsveltosv1beta1.ClusterProfileSecretType,
+			Data:       map[string][]byte{"Authorization": []byte("Bearer token")},
+		}
+		yttSource := getYttSourceForURL(headers.Namespace, &extensionv1beta1.URLSource{
+			Address:          server.URL,
+			Checksum:         digest,
+			HeadersSecretRef: &corev1.SecretReference{Name: headers.Name},
+		})
+		// Same tarball, pinned by the same checksum, without credentials
+		other := getYttSourceForURL(randomString(), &extensionv1beta1.URLSource{
+			Address:  server.URL,
+			Checksum: digest,
+		})
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource, other).
+			WithObjects(yttSource, other, headers).Build()
+		reconciler := getYttSourceReconciler(c)
+		reconciler.ArtifactCache = cache
+
+		_, currentYttSource := reconcileYttSourceWithClient(c, reconciler, yttSource)
+		Expect(currentYttSource.Status.FailureMessage).To(BeNil())
+		Expect(currentYttSource.Status.Resources).To(ContainSubstring("name: from-url"))
+
+		_, currentYttSource = reconcileYttSourceWithClient(c, reconciler, other)
+		Expect(currentYttSource.Status.FailureMessage).ToNot(BeNil())
+		Expect(*currentYttSource.Status.FailureMessage).To(ContainSubstring(http.StatusText(http.StatusUnauthorized)))
+		Expect(currentYttSource.Status.Resources).To(BeEmpty())
+	})
+
+	It("Reconcile refuses tarballs larger than MaxDownloadSize", func() {
+		server = httptest.NewServer(serveTarball(""))
+
+		yttSource := getYttSourceForURL(randomString(), &extensionv1beta1.URLSource{
+			Address: server.URL,
+		})
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource).
+			WithObjects(yttSource).Build()
+		reconciler := getYttSourceReconciler(c)
+		reconciler.MaxDownloadSize = int64(len(tarball) - 1)
+
+		_, currentYttSource := reconcileYttSourceWithClient(c, reconciler, yttSource)
+		Expect(currentYttSource.Status.FailureMessage).ToNot(BeNil())
+		Expect(*currentYttSource.Status.FailureMessage).To(ContainSubstring("download too large"))
+
+		reconciler.MaxDownloadSize = int64(len(tarball))
+		_, currentYttSource = reconcileYttSourceWithClient(c, reconciler, currentYttSource)
+		Expect(currentYttSource.Status.FailureMessage).To(BeNil())
+		Expect(currentYttSource.Status.Resources).To(ContainSubstring("name: from-url"))
+	})
+
+	It("Reconcile does not download again a tarball which has not changed without artifact cache", func() {
+		server = httptest.NewServer(serveTarball(""))
+
+		yttSource := getYttSourceForURL(randomString(), &extensionv1beta1.URLSource{
+			Address: server.URL + "/" + randomString(),
+		})
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(yttSource).
+			WithObjects(yttSource).Build()
+		reconciler := getYttSourceReconciler(c)
+
+		// Revision is unknown till the tarball is downloaded once: the first poll renders again
+		_, currentYttSource := reconcileYttSourceWithClient(c, reconciler, yttSource)
+		Expect(currentYttSource.Status.FailureMessage).To(BeNil())
+		_, currentYttSource = reconcileYttSourceWithClient(c, reconciler, currentYttSource)
+		Expect(currentYttSource.Status.FailureMessage).To(BeNil())
+		Expect(downloads.Load()).To(Equal(int32(2)))
+
+		// Tarball has not changed: server answers Not Modified and nothing is rendered again
+		_, currentYttSource = reconcileYttSourceWithClient(c, reconciler, currentYttSource)
+		Expect(currentYttSource.Status.FailureMessage).To(BeNil())
+		Expect(currentYttSource.Status.Resources).To(ContainSubstring("name: from-url"))
+		Expect(downloads.Load()).To(Equal(int32(2)))
+	})
+
+	It("Reconcile refuses Secrets with a type which is not allowed", func() {
+		server = httptest.NewServer(serveTarball("Bearer token"))
+
+		headers := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: randomString(), Name: randomString()},
+			Data:       map[string][]byte{"Authorization": []byte("Bearer token")},
+		}
+		yttSource := getYttSourceForURL(headers.Namespace, &extensionv1beta1.URLSource{
+			Address:          server.URL,
+			HeadersSecretRef: &corev1.SecretReference{Name: headers.Name},
+		})
+
+		currentYttSource := reconcileYttSource(yttSource, headers)
+		Expect(currentYttSource.Status.FailureMessage).ToNot(BeNil())
+		Expect(*currentYttSource.Status.FailureMessage).To(ContainSubstring("secret type not allowed"))
+		Expect(downloads.Load()).To(BeZero())
+	})
+
+	It("Reconcile refuses Secrets in another namespace", func() {
+		server = httptest.NewServer(serveTarball("Bearer token"))
+
+		headers := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: randomString(), Name: randomString()},
+			Type:       libsveltosv1beta1.ClusterProfileSecretType,
+			Data:       map[string][]byte{"Authorization": []byte("Bearer token")},
+		}
+		yttSource := getYttSourceForURL(randomString(), &extensionv1beta1.URLSource{
+			Address:          server.URL,
+			HeadersSecretRef: &corev1.SecretReference{Namespace: headers.Namespace, Name: headers.Name},
+		})
+
+		currentYttSource := reconcileYttSource(yttSource, headers)
+		Expect(currentYttSource.Status.FailureMessage).ToNot(BeNil())
+		Expect(*currentYttSource.Status.FailureMessage).To(ContainSubstring("cross-namespace reference is not allowed"))
+		Expect(downloads.Load()).To(BeZero())
+	})
+
+	It("Reconcile verifies the server with the CA bundle from a Secret", func() {
+		server = httptest.NewTLSServer(serveTarball(""))
+
+		ca := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: randomString(), Name: randomString()},
+			Type:       libsveltosv1beta1.ClusterProfileSecretType,
+			Data: map[string][]byte{
+				extensionv1beta1.URLSourceCAKey: pem.EncodeToMemory(&pem.Block{
+					Type: "CERTIFICATE", Bytes: server.Certificate().Raw}),
+			},
+		}
+		yttSource := getYttSourceForURL(ca.Namespace, &extensionv1beta1.URLSource{
+			Address: server.URL,
+		})
+
+		// Without the CA bundle, the server certificate is not trusted
+		currentYttSource := reconcileYttSource(yttSource)
+		Expect(currentYttSource.Status.FailureMessage).ToNot(BeNil())
+		Expect(*currentYttSource.Status.FailureMessage).To(ContainSubstring("certificate"))
+
+		yttSource.Spec.URL.CASecretRef = &corev1.SecretReference{Name: ca.Name}
+		currentYttSource = reconcileYttSource(yttSource, ca)
+		Expect(currentYttSource.Status.FailureMessage).To(BeNil())
+		Expect(currentYttSource.Status.Resources).To(ContainSubstring("name: from-url"))
+	})
+})
+
+// getYttSourceForURL returns a YttSource rendering the tarball described by url
+func getYttSourceForURL(namespace string, url *extensionv1beta1.URLSource) *extensionv1beta1.YttSource {
+	return &extensionv1beta1.YttSource{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       randomString(),
+			Namespace:  namespace,
+			Generation: 1,
+		},
+		Spec: extensionv1beta1.YttSourceSpec{
+			Kind: extensionv1beta1.URLSourceKind,
+			Name: randomString(),
+			URL:  url,
+		},
+	}
+}
+
+// reconcileYttSourceWithClient reconciles yttSource once with reconciler and returns
+// the result and the current version of yttSource
+func reconcileYttSourceWithClient(c client.Client, reconciler *controllers.YttSourceReconciler,
+	yttSource *extensionv1beta1.YttSource) (reconcile.Result, *extensionv1beta1.YttSource) {
+
+	result, _ := reconciler.Reconcile(context.TODO(), reconcile.Request{
+		NamespacedName: types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+	})
+
+	currentYttSource := &extensionv1beta1.YttSource{}
+	Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: yttSource.Namespace, Name: yttSource.Name},
+		currentYttSource)).To(Succeed())
+	return result, currentYttSource
+}
+
+// getTarGz returns a gzip compressed tar archive containing files
+func getTarGz(files map[string]string) []byte {
+	var buf bytes.Buffer
+	gzWriter := gzip.NewWriter(&buf)
+	_, err := gzWriter.Write(getTar(files))
+	Expect(err).To(BeNil())
+	Expect(gzWriter.Close()).To(Succeed())
+	return buf.Bytes()
+}